@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+
+	"github.com/xaxes/self-update/autoupdate"
+	"github.com/xaxes/self-update/check"
+	"github.com/xaxes/self-update/config"
+	"go.uber.org/zap"
+)
+
+// autoUpdate starts upgrades with `start` in background, as allowed
+// by the current configuration.
+func autoUpdate(ctx context.Context, conf *config.Reloadable, version func() string, start upgradeStarter) {
+	policy := func() autoupdate.Policy {
+		p, err := conf.Config().AutoUpdate.Policy()
+		if err != nil {
+			// The policy is validated when the configuration is loaded.
+			zap.L().Error("auto-update policy", zap.Error(err))
+		}
+
+		return p
+	}
+
+	newest := func(ctx context.Context) (check.Candidate, error) {
+		return candidates.Newest(ctx, version())
+	}
+
+	go autoupdate.New(policy, newest, start).Run(ctx)
+}
@@ -0,0 +1,155 @@
+package autoupdate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidCron = errors.New("invalid cron expression")
+
+// field is a set of allowed values of a cron field, one bit per value.
+type field uint64
+
+func (f field) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// bounds of the cron fields, in order.
+var bounds = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// Cron is a parsed cron(5) expression: minute, hour, day of month,
+// month and day of week.
+//
+// Fields support `*`, values, ranges (`1-5`), lists (`1,3`) and steps
+// (`*/15`, `0-30/10`). Sunday is 0 or 7.
+type Cron struct {
+	minute, hour, dom, month, dow field
+
+	// domAny and dowAny are set for days starting with `*`; as in
+	// cron(5), if both days are restricted, a time matching either
+	// of them matches.
+	domAny, dowAny bool
+}
+
+// ParseCron parses the cron expression `s`.
+func ParseCron(s string) (Cron, error) {
+	fields := strings.Fields(s)
+	if len(fields) != len(bounds) {
+		return Cron{}, fmt.Errorf(`%w "%s": %d fields, want %d`, errInvalidCron, s, len(fields), len(bounds))
+	}
+
+	parsed := make([]field, len(fields))
+	for i, f := range fields {
+		b := bounds[i]
+
+		max := b.max
+		if i == 4 {
+			// Sunday may be written as 7.
+			max = 7
+		}
+
+		v, err := parseField(f, b.min, max)
+		if err != nil {
+			return Cron{}, fmt.Errorf(`%w "%s": %s: %v`, errInvalidCron, s, b.name, err)
+		}
+
+		parsed[i] = v
+	}
+
+	if parsed[4].has(7) {
+		parsed[4] |= 1
+	}
+
+	return Cron{
+		minute: parsed[0],
+		hour:   parsed[1],
+		dom:    parsed[2],
+		month:  parsed[3],
+		dow:    parsed[4],
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseField parses a comma-separated list of ranges within [min, max].
+func parseField(s string, min, max int) (field, error) {
+	var f field
+
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf(`invalid step "%s"`, part[i+1:])
+			}
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bs := strings.SplitN(rng, "-", 2)
+
+			var err error
+			if lo, err = strconv.Atoi(bs[0]); err != nil {
+				return 0, fmt.Errorf(`invalid value "%s"`, bs[0])
+			}
+			if hi, err = strconv.Atoi(bs[1]); err != nil {
+				return 0, fmt.Errorf(`invalid value "%s"`, bs[1])
+			}
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf(`invalid value "%s"`, rng)
+			}
+
+			lo, hi = v, v
+			// `5/10` means from 5 to the end every 10.
+			if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf(`range "%s" out of %d-%d`, rng, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+
+	return f, nil
+}
+
+// Matches reports whether the minute of `t` matches the expression.
+func (c Cron) Matches(t time.Time) bool {
+	if !c.minute.has(t.Minute()) || !c.hour.has(t.Hour()) || !c.month.has(int(t.Month())) {
+		return false
+	}
+
+	dom, dow := c.dom.has(t.Day()), c.dow.has(int(t.Weekday()))
+
+	switch {
+	case c.domAny || c.dowAny:
+		return dom && dow
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,126 @@
+package autoupdate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	// 2020-10-19 is a Monday.
+	at := func(s string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name    string
+		expr    string
+		t       time.Time
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "every minute",
+			expr: "* * * * *",
+			t:    at("2020-10-19 02:17"),
+			want: true,
+		},
+		{
+			name: "exact",
+			expr: "30 2 * * *",
+			t:    at("2020-10-19 02:30"),
+			want: true,
+		},
+		{
+			name: "other minute",
+			expr: "30 2 * * *",
+			t:    at("2020-10-19 02:31"),
+			want: false,
+		},
+		{
+			name: "step",
+			expr: "*/15 * * * *",
+			t:    at("2020-10-19 02:45"),
+			want: true,
+		},
+		{
+			name: "range with step",
+			expr: "0-30/10 * * * *",
+			t:    at("2020-10-19 02:40"),
+			want: false,
+		},
+		{
+			name: "list",
+			expr: "0 1,3,5 * * *",
+			t:    at("2020-10-19 03:00"),
+			want: true,
+		},
+		{
+			name: "weekdays",
+			expr: "0 2 * * 1-5",
+			t:    at("2020-10-19 02:00"),
+			want: true,
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 2 * * 7",
+			t:    at("2020-10-18 02:00"),
+			want: true,
+		},
+		{
+			name: "restricted days match either",
+			expr: "0 2 1 * 1",
+			t:    at("2020-10-19 02:00"),
+			want: true,
+		},
+		{
+			name: "day of month with any day of week",
+			expr: "0 2 1 * *",
+			t:    at("2020-10-19 02:00"),
+			want: false,
+		},
+		{
+			name:    "too few fields",
+			expr:    "0 2 * *",
+			wantErr: true,
+		},
+		{
+			name:    "out of range",
+			expr:    "60 * * * *",
+			wantErr: true,
+		},
+		{
+			name:    "reversed range",
+			expr:    "* 5-1 * * *",
+			wantErr: true,
+		},
+		{
+			name:    "invalid step",
+			expr:    "*/0 * * * *",
+			wantErr: true,
+		},
+		{
+			name:    "names",
+			expr:    "0 2 * * MON",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCron() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := c.Matches(tt.t); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+package autoupdate
+
+import "github.com/xaxes/self-update/metrics"
+
+var checks = metrics.Default.NewCounterVec(
+	"self_update_auto_update_checks_total",
+	"Number of automatic upgrade checks, by their decision.",
+	"decision",
+)
@@ -0,0 +1,67 @@
+package autoupdate
+
+import "time"
+
+// defaultInterval is the time between consecutive checks if not configured.
+const defaultInterval = 5 * time.Minute
+
+// Window is a maintenance window opening at times matching `Start`.
+type Window struct {
+	Start    Cron
+	Duration time.Duration
+}
+
+// Contains reports whether `t` falls within the window.
+func (w Window) Contains(t time.Time) bool {
+	// Windows open at full minutes; the earliest opening which still
+	// covers `t` is `Duration` before it.
+	open := t.Truncate(time.Minute)
+	for earliest := t.Add(-w.Duration); open.After(earliest); open = open.Add(-time.Minute) {
+		if w.Start.Matches(open) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Policy decides when upgrades are started automatically.
+type Policy struct {
+	Enabled  bool
+	Interval time.Duration  // Time between consecutive checks
+	Windows  []Window       // Upgrades are allowed anytime if empty
+	Location *time.Location // Time zone of the windows, UTC if nil
+	Jitter   time.Duration  // Maximum random delay added to each check
+	Soak     time.Duration  // Time a candidate has to be seen for before the upgrade
+	DryRun   bool           // Log the upgrade instead of starting it
+}
+
+// interval returns the time between consecutive checks.
+func (p Policy) interval() time.Duration {
+	if p.Interval <= 0 {
+		return defaultInterval
+	}
+
+	return p.Interval
+}
+
+// InWindow reports whether upgrades are allowed at `t`.
+func (p Policy) InWindow(t time.Time) bool {
+	if len(p.Windows) == 0 {
+		return true
+	}
+
+	loc := p.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+
+	for _, w := range p.Windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,94 @@
+package autoupdate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicy_InWindow(t *testing.T) {
+	nightly, err := ParseCron("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	window := []Window{{Start: nightly, Duration: 2 * time.Hour}}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		t      time.Time
+		want   bool
+	}{
+		{
+			name:   "no windows",
+			policy: Policy{},
+			t:      time.Date(2020, 10, 19, 12, 0, 0, 0, time.UTC),
+			want:   true,
+		},
+		{
+			name:   "opening",
+			policy: Policy{Windows: window},
+			t:      time.Date(2020, 10, 19, 2, 0, 0, 0, time.UTC),
+			want:   true,
+		},
+		{
+			name:   "inside",
+			policy: Policy{Windows: window},
+			t:      time.Date(2020, 10, 19, 3, 59, 59, 0, time.UTC),
+			want:   true,
+		},
+		{
+			name:   "closing",
+			policy: Policy{Windows: window},
+			t:      time.Date(2020, 10, 19, 4, 0, 0, 0, time.UTC),
+			want:   false,
+		},
+		{
+			name:   "before",
+			policy: Policy{Windows: window},
+			t:      time.Date(2020, 10, 19, 1, 59, 0, 0, time.UTC),
+			want:   false,
+		},
+		{
+			name:   "over midnight",
+			policy: Policy{Windows: []Window{{Start: mustCron(t, "0 23 * * *"), Duration: 2 * time.Hour}}},
+			t:      time.Date(2020, 10, 20, 0, 30, 0, 0, time.UTC),
+			want:   true,
+		},
+		{
+			name:   "time zone",
+			policy: Policy{Windows: window, Location: warsaw},
+			t:      time.Date(2020, 10, 19, 0, 30, 0, 0, time.UTC),
+			want:   true,
+		},
+		{
+			name:   "time zone outside",
+			policy: Policy{Windows: window, Location: warsaw},
+			t:      time.Date(2020, 10, 19, 2, 30, 0, 0, time.UTC),
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.InWindow(tt.t); got != tt.want {
+				t.Errorf("InWindow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustCron(t *testing.T, s string) Cron {
+	t.Helper()
+
+	c, err := ParseCron(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
@@ -0,0 +1,145 @@
+// Package autoupdate starts upgrades without human interaction, within
+// maintenance windows and once candidates are old enough.
+package autoupdate
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/xaxes/self-update/check"
+	"github.com/xaxes/self-update/upgrade"
+	"go.uber.org/zap"
+)
+
+// Decisions of a check, reported in the metrics.
+const (
+	decisionDisabled    = "disabled"
+	decisionNoCandidate = "no-candidate"
+	decisionError       = "error"
+	decisionSoaking     = "soaking"
+	decisionOutside     = "outside-window"
+	decisionDryRun      = "dry-run"
+	decisionStarted     = "started"
+	decisionInProgress  = "in-progress"
+	decisionFailed      = "failed"
+)
+
+// Scheduler periodically looks for candidates and starts upgrades
+// allowed by its policy.
+type Scheduler struct {
+	policy func() Policy
+	newest func(ctx context.Context) (check.Candidate, error)
+	start  func(ctx context.Context) error
+
+	// rand is seeded per process, so instances sharing a window
+	// do not check at the same time.
+	rand *rand.Rand
+
+	mu sync.Mutex
+	// seen holds the time candidates were first seen at, by path and version.
+	seen map[string]time.Time
+}
+
+// New returns a scheduler.
+//
+// `policy` is called before every check, so it may change while
+// the scheduler runs. `newest` returns the newest candidate and `start`
+// starts the upgrade to it.
+func New(policy func() Policy, newest func(ctx context.Context) (check.Candidate, error), start func(ctx context.Context) error) *Scheduler {
+	return &Scheduler{
+		policy: policy,
+		newest: newest,
+		start:  start,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// Run checks for upgrades until `ctx` is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		p := s.policy()
+
+		wait := p.interval()
+		if p.Jitter > 0 {
+			wait += time.Duration(s.rand.Int63n(int64(p.Jitter)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		decision := s.check(ctx, s.policy(), time.Now())
+		checks.With(decision).Inc()
+	}
+}
+
+// check decides whether to upgrade at `now` and starts the upgrade.
+func (s *Scheduler) check(ctx context.Context, p Policy, now time.Time) string {
+	if !p.Enabled {
+		return decisionDisabled
+	}
+
+	// Candidates are looked for outside of the windows too, so they
+	// soak in the meantime.
+	c, err := s.newest(ctx)
+	if err != nil {
+		if errors.Is(err, check.ErrNoCandidate) {
+			return decisionNoCandidate
+		}
+
+		zap.L().Error("auto-update: get newest upgrade candidate", zap.Error(err))
+		return decisionError
+	}
+
+	logger := zap.L().With(zap.String("candidate", c.Path), zap.Stringer("version", c.Version))
+
+	if age := s.age(c, now); age < p.Soak {
+		logger.Info("auto-update: candidate soaking", zap.Duration("remaining", p.Soak-age))
+		return decisionSoaking
+	}
+
+	if !p.InWindow(now) {
+		logger.Debug("auto-update: outside maintenance window")
+		return decisionOutside
+	}
+
+	if p.DryRun {
+		logger.Info("auto-update: would upgrade", zap.Bool("dry_run", true))
+		return decisionDryRun
+	}
+
+	if err := s.start(ctx); err != nil {
+		if errors.Is(err, upgrade.ErrInProgress) {
+			logger.Debug("auto-update: start upgrade", zap.Error(err))
+			return decisionInProgress
+		}
+
+		logger.Error("auto-update: start upgrade", zap.Error(err))
+		return decisionFailed
+	}
+
+	logger.Info("auto-update: upgrade started")
+	return decisionStarted
+}
+
+// age returns the time since `c` was first seen.
+func (s *Scheduler) age(c check.Candidate, now time.Time) time.Duration {
+	key := c.Path + "@" + c.Version.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first, ok := s.seen[key]
+	if !ok {
+		first = now
+		s.seen[key] = now
+	}
+
+	return now.Sub(first)
+}
@@ -0,0 +1,109 @@
+package autoupdate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/xaxes/self-update/check"
+	"github.com/xaxes/self-update/upgrade"
+)
+
+func TestScheduler_check(t *testing.T) {
+	candidate := check.Candidate{Path: "/opt/upgrades/1.1.0", Version: semver.MustParse("1.1.0")}
+	nightly := []Window{{Start: mustCron(t, "0 2 * * *"), Duration: time.Hour}}
+
+	now := time.Date(2020, 10, 19, 2, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		newestErr  error
+		startErr   error
+		firstSeen  time.Duration // before now
+		want       string
+		wantStarts int
+	}{
+		{
+			name:   "disabled",
+			policy: Policy{},
+			want:   decisionDisabled,
+		},
+		{
+			name:      "no candidate",
+			policy:    Policy{Enabled: true},
+			newestErr: check.ErrNoCandidate,
+			want:      decisionNoCandidate,
+		},
+		{
+			name:      "scan error",
+			policy:    Policy{Enabled: true},
+			newestErr: errors.New("permission denied"),
+			want:      decisionError,
+		},
+		{
+			name:      "soaking",
+			policy:    Policy{Enabled: true, Soak: time.Hour},
+			firstSeen: 30 * time.Minute,
+			want:      decisionSoaking,
+		},
+		{
+			name:   "outside window",
+			policy: Policy{Enabled: true, Windows: []Window{{Start: mustCron(t, "0 12 * * *"), Duration: time.Hour}}},
+			want:   decisionOutside,
+		},
+		{
+			name:   "dry run",
+			policy: Policy{Enabled: true, Windows: nightly, DryRun: true},
+			want:   decisionDryRun,
+		},
+		{
+			name:       "soaked",
+			policy:     Policy{Enabled: true, Windows: nightly, Soak: time.Hour},
+			firstSeen:  2 * time.Hour,
+			want:       decisionStarted,
+			wantStarts: 1,
+		},
+		{
+			name:       "in progress",
+			policy:     Policy{Enabled: true},
+			startErr:   upgrade.ErrInProgress,
+			want:       decisionInProgress,
+			wantStarts: 1,
+		},
+		{
+			name:       "start failed",
+			policy:     Policy{Enabled: true},
+			startErr:   errors.New("no space left on device"),
+			want:       decisionFailed,
+			wantStarts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			starts := 0
+
+			s := New(
+				func() Policy { return tt.policy },
+				func(context.Context) (check.Candidate, error) { return candidate, tt.newestErr },
+				func(context.Context) error {
+					starts++
+					return tt.startErr
+				},
+			)
+
+			if tt.firstSeen > 0 {
+				s.age(candidate, now.Add(-tt.firstSeen))
+			}
+
+			if got := s.check(context.Background(), tt.policy, now); got != tt.want {
+				t.Errorf("check() = %v, want %v", got, tt.want)
+			}
+			if starts != tt.wantStarts {
+				t.Errorf("check() started %d upgrades, want %d", starts, tt.wantStarts)
+			}
+		})
+	}
+}
@@ -0,0 +1,414 @@
+package check
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/xaxes/self-update/upgrade"
+	"go.uber.org/zap"
+)
+
+// Limits of the extracted contents of an archive.
+const (
+	maxArchiveSize    = 1 << 30 // bytes
+	maxArchiveEntries = 10000
+)
+
+// manifestName is the name of the manifest at the root of an archive.
+const manifestName = "manifest.json"
+
+var (
+	errArchiveTooLarge = errors.New("archive exceeds the size limits")
+	errNoBinary        = errors.New("archive contains no binary")
+)
+
+// manifest describes the contents of an archive.
+type manifest struct {
+	Version string `json:"version"`
+	Binary  string `json:"binary"` // path relative to the root of the archive
+
+	// Patch is the path of a bsdiff patch turning the running binary
+	// with BaseSHA256 into the binary with SHA256.
+	Patch      string `json:"patch"`
+	BaseSHA256 string `json:"base_sha256"`
+	SHA256     string `json:"sha256"`
+}
+
+// isArchive reports whether `name` is a supported archive.
+func isArchive(name string) bool {
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stage extracts the archive at `path` into a versioned directory in
+// `dir` and returns its binary as the candidate.
+//
+// The version is read from the archive's `manifest.json` or, if it
+// has none, from `<binary> -version`. Archives staged before are
+// extracted again and compared with the staged copy, which is kept if
+// it matches, so that the path of the candidate stays the same, and is
+// replaced otherwise.
+//
+// Archives with a patch instead of the binary are applied to the
+// running binary.
+func Stage(path, dir string) (Candidate, error) {
+	hash, err := hashFile(path)
+	if err != nil {
+		return Candidate{}, err
+	}
+
+	exe, err := upgrade.Executable()
+	if err != nil {
+		return Candidate{}, err
+	}
+
+	return stage(path, hash, dir, exe)
+}
+
+// stage stages the archive at `path` with `hash`; patches in it are
+// applied to `base`.
+func stage(path, hash, dir, base string) (Candidate, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Candidate{}, err
+	}
+
+	tmp, err := ioutil.TempDir(dir, ".extract-")
+	if err != nil {
+		return Candidate{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := extract(path, tmp); err != nil {
+		return Candidate{}, fmt.Errorf("extract %s: %w", path, err)
+	}
+
+	root, m, err := archiveContents(tmp)
+	if err != nil {
+		return Candidate{}, err
+	}
+
+	if m.Patch != "" {
+		if err := applyPatch(root, m, base); err != nil {
+			return Candidate{}, fmt.Errorf("patch %s: %w", path, err)
+		}
+	}
+
+	bin, err := findBinary(root, m)
+	if err != nil {
+		return Candidate{}, err
+	}
+
+	if err := checkPlatform(bin); err != nil {
+		var inc *Incompatible
+		if errors.As(err, &inc) {
+			inc.Path = path
+		}
+		return Candidate{}, err
+	}
+
+	var v *semver.Version
+	if m.Version != "" {
+		v, err = semver.NewVersion(m.Version)
+	} else {
+		zap.L().Debug("check version", zap.String("bin", bin))
+		v, err = versionFromBin(bin)
+	}
+	if err != nil {
+		return Candidate{}, fmt.Errorf("version of %s: %w", path, err)
+	}
+
+	rel, err := filepath.Rel(tmp, bin)
+	if err != nil {
+		return Candidate{}, err
+	}
+
+	// The staging directory may have been written to since the archive
+	// was staged, so the staged copy is only trusted if it matches.
+	want, err := hashTree(tmp)
+	if err != nil {
+		return Candidate{}, err
+	}
+
+	final := filepath.Join(dir, v.String()+"-"+hash)
+	switch got, err := hashTree(final); {
+	case err == nil && got == want:
+		return Candidate{filepath.Join(final, rel), v}, nil
+	case err == nil:
+		zap.L().Warn("replace modified staged archive", zap.String("archive", path), zap.String("dir", final))
+		if err := os.RemoveAll(final); err != nil {
+			return Candidate{}, err
+		}
+	case !os.IsNotExist(err):
+		return Candidate{}, err
+	}
+
+	if err := os.Rename(tmp, final); err != nil {
+		// Staged concurrently.
+		if got, hashErr := hashTree(final); hashErr == nil && got == want {
+			return Candidate{filepath.Join(final, rel), v}, nil
+		}
+
+		return Candidate{}, err
+	}
+
+	zap.L().Info("stage archive", zap.String("archive", path), zap.String("dir", final), zap.Stringer("version", v))
+
+	return Candidate{filepath.Join(final, rel), v}, nil
+}
+
+// hashTree returns the SHA-256 of the names, modes and contents of the
+// files in `dir`.
+func hashTree(dir string) (string, error) {
+	h := sha256.New()
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %v\n", filepath.ToSlash(rel), fi.Mode())
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\n", sum)
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// archiveContents returns the root of the extracted archive in `dir`
+// and its manifest. A single top-level directory is the root.
+func archiveContents(dir string) (string, manifest, error) {
+	root := dir
+
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", manifest{}, err
+	}
+	if len(fs) == 1 && fs[0].IsDir() {
+		root = filepath.Join(dir, fs[0].Name())
+	}
+
+	var m manifest
+	b, err := ioutil.ReadFile(filepath.Join(root, manifestName))
+	switch {
+	case os.IsNotExist(err):
+		return root, m, nil
+	case err != nil:
+		return "", m, err
+	}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "", m, fmt.Errorf("parse %s: %w", manifestName, err)
+	}
+
+	return root, m, nil
+}
+
+// findBinary returns the binary named in `m` or the only executable at
+// `root`.
+func findBinary(root string, m manifest) (string, error) {
+	if m.Binary != "" {
+		bin, err := safeJoin(root, m.Binary)
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := os.Stat(bin); err != nil {
+			return "", err
+		}
+
+		return bin, nil
+	}
+
+	fs, err := ioutil.ReadDir(root)
+	if err != nil {
+		return "", err
+	}
+
+	var bins []string
+	for _, f := range filter(filter(fs, dirFilter), noexecFilter(root)) {
+		if isExecutable(f) {
+			bins = append(bins, filepath.Join(root, f.Name()))
+		}
+	}
+
+	if len(bins) != 1 {
+		return "", fmt.Errorf("%w: %d executables found, name one in %s", errNoBinary, len(bins), manifestName)
+	}
+
+	return bins[0], nil
+}
+
+func isExecutable(f os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		return strings.HasSuffix(f.Name(), ".exe")
+	}
+
+	return executableFilter(f)
+}
+
+// safeJoin joins `name` of an archive entry to `dir`.
+//
+// It returns an error if the result would be outside of `dir`.
+func safeJoin(dir, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("archive entry %q has an absolute path", name)
+	}
+
+	path := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %q is outside of the archive", name)
+	}
+
+	return path, nil
+}
+
+// extractor writes the entries of an archive within the size limits.
+type extractor struct {
+	dir     string
+	size    int64
+	entries int
+}
+
+func (e *extractor) entry(name string, mode os.FileMode, r io.Reader) error {
+	e.entries++
+	if e.entries > maxArchiveEntries {
+		return errArchiveTooLarge
+	}
+
+	path, err := safeJoin(e.dir, name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(path, 0755)
+	case !mode.IsRegular():
+		// Links could point outside of the directory.
+		return fmt.Errorf("archive entry %q is not a regular file", name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()&0755)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, io.LimitReader(r, maxArchiveSize-e.size+1))
+	e.size += n
+	if err == nil && e.size > maxArchiveSize {
+		err = errArchiveTooLarge
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// extract extracts the archive at `path` into `dir`.
+func extract(path, dir string) error {
+	e := &extractor{dir: dir}
+
+	if strings.HasSuffix(path, ".zip") {
+		return extractZip(path, e)
+	}
+
+	return extractTarGz(path, e)
+}
+
+func extractTarGz(path string, e *extractor) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		if err := e.entry(h.Name, h.FileInfo().Mode(), tr); err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(path string, e *extractor) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+
+		err = e.entry(zf.Name, zf.Mode(), r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
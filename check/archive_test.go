@@ -0,0 +1,239 @@
+package check
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is a file of a test archive; a `link` entry is a symlink.
+type archiveEntry struct {
+	name string
+	body string
+	mode int64
+	link string
+}
+
+func writeTarGz(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: e.mode, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, e.link, 0
+		}
+
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		h.SetMode(os.FileMode(e.mode))
+
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("binaries are shell scripts")
+	}
+
+	script := func(version string) string { return "#!/bin/sh\necho " + version + "\n" }
+
+	tests := []struct {
+		name        string
+		archive     string
+		entries     []archiveEntry
+		wantVersion string
+		wantBin     string
+		wantErr     string
+	}{
+		{
+			name:    "manifest",
+			archive: "release.tar.gz",
+			entries: []archiveEntry{
+				{name: "manifest.json", body: `{"version": "1.2.0", "binary": "bin/service"}`, mode: 0644},
+				{name: "bin/service", body: script("0.0.0"), mode: 0755},
+				{name: "README.md", body: "docs", mode: 0644},
+			},
+			// The binary is not executed.
+			wantVersion: "1.2.0",
+			wantBin:     "bin/service",
+		},
+		{
+			name:    "top-level directory",
+			archive: "release.tgz",
+			entries: []archiveEntry{
+				{name: "release-1.3.0/service", body: script("1.3.0"), mode: 0755},
+				{name: "release-1.3.0/LICENSE", body: "license", mode: 0644},
+			},
+			wantVersion: "1.3.0",
+			wantBin:     "release-1.3.0/service",
+		},
+		{
+			name:    "zip",
+			archive: "release.zip",
+			entries: []archiveEntry{
+				{name: "service", body: script("1.4.0"), mode: 0755},
+			},
+			wantVersion: "1.4.0",
+			wantBin:     "service",
+		},
+		{
+			name:    "several binaries",
+			archive: "release.tar.gz",
+			entries: []archiveEntry{
+				{name: "service", body: script("1.5.0"), mode: 0755},
+				{name: "tool", body: script("1.5.0"), mode: 0755},
+			},
+			wantErr: "2 executables found",
+		},
+		{
+			name:    "path traversal",
+			archive: "release.tar.gz",
+			entries: []archiveEntry{
+				{name: "../service", body: script("1.6.0"), mode: 0755},
+			},
+			wantErr: "outside of the archive",
+		},
+		{
+			name:    "absolute path",
+			archive: "release.zip",
+			entries: []archiveEntry{
+				{name: "/tmp/service", body: script("1.7.0"), mode: 0755},
+			},
+			wantErr: "absolute path",
+		},
+		{
+			name:    "manifest path traversal",
+			archive: "release.tar.gz",
+			entries: []archiveEntry{
+				{name: "manifest.json", body: `{"version": "1.8.0", "binary": "../../service"}`, mode: 0644},
+			},
+			wantErr: "outside of the archive",
+		},
+		{
+			name:    "symlink",
+			archive: "release.tar.gz",
+			entries: []archiveEntry{
+				{name: "service", link: "/bin/sh"},
+			},
+			wantErr: "not a regular file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "stage")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, tt.archive)
+			if strings.HasSuffix(path, ".zip") {
+				writeZip(t, path, tt.entries)
+			} else {
+				writeTarGz(t, path, tt.entries)
+			}
+
+			staging := filepath.Join(dir, "staging")
+			got, err := Stage(path, staging)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Stage() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Stage() error = %v", err)
+			}
+
+			if got.Version.String() != tt.wantVersion {
+				t.Errorf("Stage() version = %v, want %v", got.Version, tt.wantVersion)
+			}
+			if !strings.HasSuffix(got.Path, filepath.FromSlash("/"+tt.wantBin)) || !strings.HasPrefix(got.Path, staging) {
+				t.Errorf("Stage() path = %v, want %v in %v", got.Path, tt.wantBin, staging)
+			}
+
+			// Staged archives keep their path.
+			again, err := Stage(path, staging)
+			if err != nil {
+				t.Fatalf("Stage() again error = %v", err)
+			}
+			if again.Path != got.Path || !again.Version.Equal(got.Version) {
+				t.Errorf("Stage() again = %v, want %v", again, got)
+			}
+
+			// Modified staged binaries are replaced.
+			want, err := ioutil.ReadFile(got.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(got.Path, []byte(script("9.9.9")), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if again, err = Stage(path, staging); err != nil {
+				t.Fatalf("Stage() modified error = %v", err)
+			}
+			if b, err := ioutil.ReadFile(again.Path); err != nil || string(b) != string(want) {
+				t.Errorf("Stage() modified = %q, %v, want %q", b, err, want)
+			}
+
+			fs, err := ioutil.ReadDir(staging)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fs) != 1 {
+				t.Errorf("staging directory has %d entries, want 1", len(fs))
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package check
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// bsdiffMagic starts patches in the format of bsdiff 4.x.
+const bsdiffMagic = "BSDIFF40"
+
+var errCorruptPatch = errors.New("corrupt patch")
+
+// offtin decodes a sign-magnitude little-endian integer of bsdiff.
+func offtin(b []byte) int64 {
+	x := int64(binary.LittleEndian.Uint64(b) &^ (1 << 63))
+	if b[7]&0x80 != 0 {
+		x = -x
+	}
+
+	return x
+}
+
+// bspatchChunk is the number of bytes bspatch holds in memory at once.
+const bspatchChunk = 64 << 10
+
+// bspatch applies the bsdiff `patch` to `old` and writes the result to
+// `w`.
+//
+// The patch consists of a header and three bzip2 compressed blocks:
+// control triples, bytes added to `old` and bytes inserted as they are.
+// The result is streamed, as its size is only known from the header.
+func bspatch(old, patch []byte, w io.Writer) error {
+	if len(patch) < 32 || string(patch[:8]) != bsdiffMagic {
+		return fmt.Errorf("%w: bad header", errCorruptPatch)
+	}
+
+	ctrlLen, diffLen, newSize := offtin(patch[8:]), offtin(patch[16:]), offtin(patch[24:])
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || newSize > maxArchiveSize ||
+		32+ctrlLen+diffLen > int64(len(patch)) {
+		return fmt.Errorf("%w: bad header", errCorruptPatch)
+	}
+
+	ctrl := bzip2.NewReader(bytes.NewReader(patch[32 : 32+ctrlLen]))
+	diff := bzip2.NewReader(bytes.NewReader(patch[32+ctrlLen : 32+ctrlLen+diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(patch[32+ctrlLen+diffLen:]))
+
+	chunk := make([]byte, bspatchChunk)
+	buf := make([]byte, 24)
+
+	var oldPos, newPos int64
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, buf); err != nil {
+			return fmt.Errorf("%w: read control: %v", errCorruptPatch, err)
+		}
+		x, y, z := offtin(buf), offtin(buf[8:]), offtin(buf[16:])
+
+		if x < 0 || y < 0 || newPos+x+y > newSize {
+			return fmt.Errorf("%w: bad control", errCorruptPatch)
+		}
+
+		for x > 0 {
+			n := min64(x, bspatchChunk)
+			if _, err := io.ReadFull(diff, chunk[:n]); err != nil {
+				return fmt.Errorf("%w: read diff: %v", errCorruptPatch, err)
+			}
+			for i := int64(0); i < n; i++ {
+				if oldPos+i >= 0 && oldPos+i < int64(len(old)) {
+					chunk[i] += old[oldPos+i]
+				}
+			}
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return err
+			}
+
+			x -= n
+			newPos += n
+			oldPos += n
+		}
+
+		for y > 0 {
+			n := min64(y, bspatchChunk)
+			if _, err := io.ReadFull(extra, chunk[:n]); err != nil {
+				return fmt.Errorf("%w: read extra: %v", errCorruptPatch, err)
+			}
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return err
+			}
+
+			y -= n
+			newPos += n
+		}
+		oldPos += z
+	}
+
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,74 @@
+package check
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func Test_bspatch(t *testing.T) {
+	base := readTestdata(t, "base")
+	patch := readTestdata(t, "base-patched.bsdiff")
+	patched := readTestdata(t, "patched")
+
+	corrupt := append([]byte(nil), patch...)
+	corrupt[40] ^= 0xff
+
+	tests := []struct {
+		name    string
+		patch   []byte
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:  "patch",
+			patch: patch,
+			want:  patched,
+		},
+		{
+			name:    "bad magic",
+			patch:   append([]byte("BSDIFF41"), patch[8:]...),
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			patch:   patch[:len(patch)/2],
+			wantErr: true,
+		},
+		{
+			name:    "corrupt block",
+			patch:   corrupt,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bytes.Buffer
+			err := bspatch(base, tt.patch, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bspatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, errCorruptPatch) {
+					t.Errorf("bspatch() error = %v, want %v", err, errCorruptPatch)
+				}
+				return
+			}
+			if !bytes.Equal(got.Bytes(), tt.want) {
+				t.Errorf("bspatch() = %q, want %q", got.Bytes(), tt.want)
+			}
+		})
+	}
+}
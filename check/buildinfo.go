@@ -0,0 +1,222 @@
+package check
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	errNoBuildInfo  = errors.New("no Go build info")
+	errOldBuildInfo = errors.New("build info of Go before 1.18 is not supported")
+)
+
+// The build info starts with the magic, aligned to 16 bytes, within the
+// first 64 KiB of its section.
+var buildInfoMagic = []byte("\xff Go buildinf:")
+
+const (
+	buildInfoAlign   = 16
+	buildInfoMaxScan = 64 << 10
+)
+
+// module is a module of the build info.
+type module struct {
+	Path    string
+	Version string
+	Sum     string
+	Replace *module
+}
+
+// buildInfo is the build info Go embeds in binaries, see `go version -m`.
+type buildInfo struct {
+	GoVersion string
+	Path      string
+	Main      module
+	Deps      []*module
+	Settings  map[string]string // e.g. vcs.revision
+}
+
+// readBuildInfo reads the build info of the ELF, PE or Mach-O binary at
+// `path`.
+func readBuildInfo(path string) (*buildInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := buildInfoSection(f)
+	if err != nil {
+		return nil, err
+	}
+
+	for off := 0; off+len(buildInfoMagic) <= len(data) && off < buildInfoMaxScan; off += buildInfoAlign {
+		if bytes.HasPrefix(data[off:], buildInfoMagic) {
+			return parseBuildInfoHeader(data[off:])
+		}
+	}
+
+	return nil, errNoBuildInfo
+}
+
+// buildInfoSection returns the contents of the section the build info is
+// stored in.
+func buildInfoSection(r io.ReaderAt) ([]byte, error) {
+	if f, err := elf.NewFile(r); err == nil {
+		s := f.Section(".go.buildinfo")
+		if s == nil {
+			s = f.Section(".data")
+		}
+		if s == nil {
+			return nil, errNoBuildInfo
+		}
+		return s.Data()
+	}
+
+	if f, err := pe.NewFile(r); err == nil {
+		s := f.Section(".data")
+		if s == nil {
+			return nil, errNoBuildInfo
+		}
+		return s.Data()
+	}
+
+	if f, err := macho.NewFile(r); err == nil {
+		s := f.Section("__go_buildinfo")
+		if s == nil {
+			s = f.Section("__data")
+		}
+		if s == nil {
+			return nil, errNoBuildInfo
+		}
+		return s.Data()
+	}
+
+	return nil, errors.New("not an ELF, PE or Mach-O binary")
+}
+
+// parseBuildInfoHeader parses the build info starting with the magic.
+//
+// Since Go 1.18, the header of 32 bytes is followed by the Go version and
+// the module info as length-prefixed strings.
+func parseBuildInfoHeader(b []byte) (*buildInfo, error) {
+	const headerSize = 32
+	if len(b) < headerSize {
+		return nil, errNoBuildInfo
+	}
+
+	const flagInline = 0x2
+	if b[len(buildInfoMagic)+1]&flagInline == 0 {
+		return nil, errOldBuildInfo
+	}
+
+	b = b[headerSize:]
+	version, b, ok := readVarString(b)
+	if !ok {
+		return nil, errNoBuildInfo
+	}
+	mod, _, ok := readVarString(b)
+	if !ok {
+		return nil, errNoBuildInfo
+	}
+
+	// The module info is wrapped in 16-byte sentinels.
+	if len(mod) >= 33 && mod[len(mod)-17] == '\n' {
+		mod = mod[16 : len(mod)-16]
+	}
+
+	info, err := parseModInfo(mod)
+	if err != nil {
+		return nil, err
+	}
+	info.GoVersion = version
+
+	return info, nil
+}
+
+func readVarString(b []byte) (string, []byte, bool) {
+	n, l := binary.Uvarint(b)
+	if l <= 0 || n > uint64(len(b)-l) {
+		return "", nil, false
+	}
+
+	return string(b[l : l+int(n)]), b[l+int(n):], true
+}
+
+// parseModInfo parses the module info in the format of `go version -m`.
+func parseModInfo(s string) (*buildInfo, error) {
+	info := &buildInfo{Settings: make(map[string]string)}
+
+	var last *module
+	for n, line := range strings.Split(s, "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		bad := fmt.Errorf("build info:%d: unexpected %q", n+1, line)
+
+		switch fields[0] {
+		case "path":
+			if len(fields) != 2 {
+				return nil, bad
+			}
+			info.Path = fields[1]
+		case "mod", "dep", "=>":
+			if len(fields) < 3 {
+				return nil, bad
+			}
+
+			m := &module{Path: fields[1], Version: fields[2]}
+			if len(fields) > 3 {
+				m.Sum = fields[3]
+			}
+
+			switch fields[0] {
+			case "mod":
+				info.Main = *m
+				last = &info.Main
+			case "dep":
+				info.Deps = append(info.Deps, m)
+				last = m
+			default:
+				if last == nil || last.Replace != nil {
+					return nil, bad
+				}
+				last.Replace = m
+			}
+		case "build":
+			kv := strings.SplitN(strings.Join(fields[1:], "\t"), "=", 2)
+			if len(kv) != 2 {
+				return nil, bad
+			}
+			info.Settings[kv[0]] = kv[1]
+		}
+	}
+
+	return info, nil
+}
+
+// replaced returns the modules replaced in the build.
+func (b *buildInfo) replaced() []string {
+	var rs []string
+	if b.Main.Replace != nil {
+		rs = append(rs, b.Main.Path+" => "+b.Main.Replace.Path)
+	}
+
+	for _, d := range b.Deps {
+		if d.Replace != nil {
+			rs = append(rs, d.Path+" => "+d.Replace.Path)
+		}
+	}
+
+	return rs
+}
@@ -0,0 +1,66 @@
+package check
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func Test_readBuildInfo(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := readBuildInfo(exe)
+	if err != nil {
+		t.Fatalf("readBuildInfo() error = %v", err)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("readBuildInfo() GoVersion = %s, want %s", info.GoVersion, runtime.Version())
+	}
+	if info.Main.Path != "github.com/xaxes/self-update" {
+		t.Errorf("readBuildInfo() Main.Path = %s, want github.com/xaxes/self-update", info.Main.Path)
+	}
+
+	if _, err := readBuildInfo(filepath.Join("testdata", "base")); err == nil {
+		t.Error("readBuildInfo() of a shell script error = nil, want an error")
+	}
+}
+
+func Test_parseModInfo(t *testing.T) {
+	s := "path\tgithub.com/xaxes/self-update\n" +
+		"mod\tgithub.com/xaxes/self-update\tv1.2.0\th1:main=\n" +
+		"dep\tgithub.com/Masterminds/semver\tv1.5.0\th1:semver=\n" +
+		"=>\t../semver\t(devel)\t\n" +
+		"dep\tgo.uber.org/zap\tv1.16.0\th1:zap=\n" +
+		"build\tvcs.revision=0123abcd\n"
+
+	got, err := parseModInfo(s)
+	if err != nil {
+		t.Fatalf("parseModInfo() error = %v", err)
+	}
+
+	want := &buildInfo{
+		Path: "github.com/xaxes/self-update",
+		Main: module{Path: "github.com/xaxes/self-update", Version: "v1.2.0", Sum: "h1:main="},
+		Deps: []*module{
+			{Path: "github.com/Masterminds/semver", Version: "v1.5.0", Sum: "h1:semver=", Replace: &module{Path: "../semver", Version: "(devel)"}},
+			{Path: "go.uber.org/zap", Version: "v1.16.0", Sum: "h1:zap="},
+		},
+		Settings: map[string]string{"vcs.revision": "0123abcd"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseModInfo() = %+v, want %+v", got, want)
+	}
+
+	if rs := got.replaced(); !reflect.DeepEqual(rs, []string{"github.com/Masterminds/semver => ../semver"}) {
+		t.Errorf("replaced() = %v", rs)
+	}
+
+	if _, err := parseModInfo("=>\t../orphan\t\t\n"); err == nil {
+		t.Error("parseModInfo() of a replacement without a module error = nil, want an error")
+	}
+}
@@ -1,8 +1,8 @@
 package check
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"runtime"
@@ -17,26 +17,28 @@ type Candidate struct {
 	Version *semver.Version
 }
 
-func updateCandidates(fs []os.FileInfo) []os.FileInfo {
+// updateCandidates filters out the files of `dir` that are not upgrade
+// candidates.
+func updateCandidates(dir string, fs []os.FileInfo) []os.FileInfo {
 	fs = filter(fs, dirFilter)
-	fs = filter(fs, sameFileFilter)
 
 	if runtime.GOOS != "windows" {
 		fs = filter(fs, executableFilter)
+		fs = filter(fs, writableDirFilter(dir))
+		fs = filter(fs, noexecFilter(dir))
 	}
 
+	// Last, as copies of the running binary are hashed.
+	fs = filter(fs, sameFileFilter(dir))
+
 	return fs
 }
 
-func updateCandidatesFromDir(dir string) ([]os.FileInfo, error) {
-	fs, err := ioutil.ReadDir(dir)
-	if err != nil {
-		return nil, err
+func isNewer(new, curr *semver.Version) bool {
+	if curr.GreaterThan(new) || curr.Equal(new) {
+		return false
 	}
-
-	fs = updateCandidates(fs)
-
-	return fs, nil
+	return true
 }
 
 // byVersion implements sort.Interface for []Candidate.
@@ -46,6 +48,10 @@ func (v byVersion) Len() int           { return len(v) }
 func (v byVersion) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
 func (v byVersion) Less(i, j int) bool { return v[i].Version.LessThan(v[j].Version) }
 
+// errVersionOutput is returned for candidates printing something else
+// than a version.
+var errVersionOutput = errors.New("parse version")
+
 func versionFromBin(fpath string) (*semver.Version, error) {
 	outRaw, err := exec.Command(fpath, "-version").CombinedOutput()
 	if err != nil {
@@ -56,7 +62,7 @@ func versionFromBin(fpath string) (*semver.Version, error) {
 
 	new, err := semver.NewVersion(out)
 	if err != nil {
-		return nil, fmt.Errorf(`parse version "%s": %w`, out, err)
+		return nil, fmt.Errorf(`%w "%s": %v`, errVersionOutput, out, err)
 	}
 
 	return new, nil
@@ -1,8 +1,13 @@
 package check
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+
+	"github.com/Masterminds/semver"
 )
 
 func Test_updateCandidates(t *testing.T) {
@@ -12,9 +17,14 @@ func Test_updateCandidates(t *testing.T) {
 			isDir: true,
 		},
 	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
 	sameFile := []os.FileInfo{
 		fileInfo{
-			name: os.Args[0],
+			name: filepath.Base(exe),
+			mode: 0755,
 		},
 	}
 	validFile := []os.FileInfo{
@@ -24,6 +34,11 @@ func Test_updateCandidates(t *testing.T) {
 		},
 	}
 	empty := []os.FileInfo{}
+
+	// The directory of the running binary, which is not writable by
+	// others.
+	dir := filepath.Dir(exe)
+
 	type args struct {
 		fs []os.FileInfo
 	}
@@ -70,7 +85,7 @@ func Test_updateCandidates(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := updateCandidates(tt.args.fs)
+			got := updateCandidates(dir, tt.args.fs)
 			if len(got) != len(tt.want) {
 				t.Errorf("filter() = %v, want %v", got, tt.want)
 			}
@@ -83,3 +98,84 @@ func Test_updateCandidates(t *testing.T) {
 		})
 	}
 }
+
+func Test_probeFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("candidates are shell scripts")
+	}
+
+	dir, err := ioutil.TempDir("", "probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{name: "exit", script: "#!/bin/sh\nexit 1\n", want: "exit"},
+		{name: "version", script: "#!/bin/sh\necho unknown\n", want: "version"},
+		{name: "exec", want: "exec"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if tt.script != "" {
+				if err := ioutil.WriteFile(path, []byte(tt.script), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := versionFromBin(path)
+			if got := probeFailure(err); got != tt.want {
+				t.Errorf("probeFailure(%v) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isNewer(t *testing.T) {
+	type args struct {
+		new  *semver.Version
+		curr *semver.Version
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			name: "same version",
+			args: args{
+				new:  semver.MustParse("1.0.0"),
+				curr: semver.MustParse("1.0.0"),
+			},
+			want: false,
+		},
+		{
+			name: "curr newer",
+			args: args{
+				new:  semver.MustParse("1.0.0"),
+				curr: semver.MustParse("1.1.0"),
+			},
+			want: false,
+		},
+		{
+			name: "new newer",
+			args: args{
+				new:  semver.MustParse("1.1.0"),
+				curr: semver.MustParse("1.0.0"),
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isNewer(tt.args.new, tt.args.curr); got != tt.want {
+				t.Errorf("isNewer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,629 @@
+package check
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files signing a candidate, named after it, e.g. `self-update-1.2.0.bundle`.
+const (
+	bundleExt = ".bundle" // cosign or Sigstore bundle
+	sigExt    = ".sig"    // base64-encoded signature
+	certExt   = ".pem"    // certificate of the signature
+)
+
+var (
+	errUnsigned  = errors.New("no signature")
+	errSignature = errors.New("signature does not verify")
+	errNoTlog    = errors.New("no transparency log entry")
+	errIdentity  = errors.New("certificate of another identity")
+)
+
+// Extensions of Fulcio certificates holding the OIDC issuer: the raw
+// string in the deprecated one, a DER UTF8String in the other.
+var (
+	oidIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// PublicRekorKey is the PEM public key of the Sigstore public-good
+// transparency log, rekor.sigstore.dev.
+const PublicRekorKey = `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE2G2Y+2tabdTV5BcGiBIx0a9fAFwr
+kBbmLSGtks4L3qX6yYY0zufBnhC8Ur/iy55GhWP/9A/bY2LhC30M9+RYtw==
+-----END PUBLIC KEY-----
+`
+
+// Verifier verifies cosign signatures of candidates offline.
+//
+// The signing certificate has to chain up to a trusted root and be issued
+// to the expected identity. If a transparency log key is configured, the
+// signature has to come with a log entry signed by it, which proves the
+// certificate was valid when the signature was logged; otherwise the
+// certificate has to be valid now.
+type Verifier struct {
+	roots         *x509.CertPool
+	intermediates []*x509.Certificate
+	rekor         *ecdsa.PublicKey
+	rekorID       string // hex SHA-256 of the PKIX key
+	subject       string
+	issuer        string
+}
+
+// NewVerifier returns a verifier trusting the PEM certificates in `roots`,
+// self-signed ones as roots and others as intermediates, and the PEM
+// public key `rekorKey` of the transparency log, if not empty.
+//
+// Certificates have to be issued to `subject`, an email address or a URI,
+// and by the OIDC `issuer`, unless it is empty.
+func NewVerifier(roots, rekorKey []byte, subject, issuer string) (*Verifier, error) {
+	if subject == "" {
+		return nil, errors.New("cosign: identity is required")
+	}
+
+	v := &Verifier{roots: x509.NewCertPool(), subject: subject, issuer: issuer}
+
+	certs, err := parseCerts(roots)
+	if err != nil {
+		return nil, fmt.Errorf("cosign: roots: %w", err)
+	}
+
+	var n int
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil {
+			v.roots.AddCert(c)
+			n++
+		} else {
+			v.intermediates = append(v.intermediates, c)
+		}
+	}
+	if n == 0 {
+		return nil, errors.New("cosign: roots: no self-signed certificate")
+	}
+
+	if len(rekorKey) == 0 {
+		return v, nil
+	}
+
+	b, _ := pem.Decode(rekorKey)
+	if b == nil || b.Type != "PUBLIC KEY" {
+		return nil, errors.New("cosign: transparency log key: no PEM public key")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(b.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cosign: transparency log key: %w", err)
+	}
+
+	var ok bool
+	if v.rekor, ok = pub.(*ecdsa.PublicKey); !ok {
+		return nil, fmt.Errorf("cosign: transparency log key: unsupported %T", pub)
+	}
+
+	sum := sha256.Sum256(b.Bytes)
+	v.rekorID = hex.EncodeToString(sum[:])
+
+	return v, nil
+}
+
+// sigBundle is the signature of a file with the material to verify it.
+type sigBundle struct {
+	sig   []byte
+	certs []*x509.Certificate // leaf first
+	entry *tlogEntry
+	// digest is the SHA-256 of the signed file, if the bundle lists it.
+	digest []byte
+	// envelope is the signed DSSE envelope of an attestation.
+	envelope *envelope
+}
+
+// tlogEntry is an entry of the Rekor transparency log with its signed
+// entry timestamp (SET).
+type tlogEntry struct {
+	body           []byte
+	integratedTime int64
+	logIndex       int64
+	logID          string // hex
+	set            []byte
+}
+
+// Verify checks the signature of the file at `path` with the SHA-256
+// `hash`, read from `<path>.bundle` or `<path>.sig` and `<path>.pem`.
+func (v *Verifier) Verify(path, hash string) error {
+	digest, err := hex.DecodeString(hash)
+	if err != nil {
+		return err
+	}
+
+	b, err := readBundle(path)
+	if err != nil {
+		return err
+	}
+	if b.envelope != nil {
+		return fmt.Errorf("cosign: %s: %w: bundle of an attestation", path, errSignature)
+	}
+
+	if err := v.verify(b, digest); err != nil {
+		return fmt.Errorf("cosign: %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (v *Verifier) verify(b *sigBundle, digest []byte) error {
+	if b.digest != nil && !bytes.Equal(b.digest, digest) {
+		return fmt.Errorf("%w: bundle of another file", errSignature)
+	}
+
+	leaf := b.certs[0]
+
+	now := time.Now()
+	if v.rekor != nil {
+		if b.entry == nil {
+			return errNoTlog
+		}
+
+		if err := v.verifyEntry(b, digest); err != nil {
+			return err
+		}
+
+		now = time.Unix(b.entry.integratedTime, 0)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range v.intermediates {
+		intermediates.AddCert(c)
+	}
+	for _, c := range b.certs[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := v.checkIdentity(leaf); err != nil {
+		return err
+	}
+
+	return verifySig(leaf.PublicKey, digest, b.sig)
+}
+
+// checkIdentity checks the subject and the issuer of `c`.
+func (v *Verifier) checkIdentity(c *x509.Certificate) error {
+	var sans []string
+	sans = append(sans, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		sans = append(sans, u.String())
+	}
+
+	found := false
+	for _, san := range sans {
+		if san == v.subject {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: issued to %v, want %s", errIdentity, sans, v.subject)
+	}
+
+	if v.issuer == "" {
+		return nil
+	}
+
+	issuer := certIssuer(c)
+	if issuer != v.issuer {
+		return fmt.Errorf("%w: issued by %q, want %q", errIdentity, issuer, v.issuer)
+	}
+
+	return nil
+}
+
+// certIssuer returns the OIDC issuer of a Fulcio certificate.
+func certIssuer(c *x509.Certificate) string {
+	var issuer string
+	for _, e := range c.Extensions {
+		switch {
+		case e.Id.Equal(oidIssuerV2):
+			var s string
+			if _, err := asn1.UnmarshalWithParams(e.Value, &s, "utf8"); err == nil {
+				return s
+			}
+		case e.Id.Equal(oidIssuer):
+			issuer = string(e.Value)
+		}
+	}
+
+	return issuer
+}
+
+// verifyEntry checks that the log entry of `b` is signed by the log and
+// records the signature of `digest` by the certificate.
+func (v *Verifier) verifyEntry(b *sigBundle, digest []byte) error {
+	e := b.entry
+	if e.logID != v.rekorID {
+		return fmt.Errorf("%w: entry of an unknown log %s", errSignature, e.logID)
+	}
+
+	// The SET signs the canonical JSON of the entry; the keys are in
+	// lexicographic order.
+	payload, err := json.Marshal(struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	}{base64.StdEncoding.EncodeToString(e.body), e.integratedTime, e.logID, e.logIndex})
+	if err != nil {
+		return err
+	}
+
+	if err := verifySig(v.rekor, sha256Sum(payload), e.set); err != nil {
+		return fmt.Errorf("log entry: %w", err)
+	}
+
+	var body struct {
+		Kind string          `json:"kind"`
+		Spec json.RawMessage `json:"spec"`
+	}
+	if err := json.Unmarshal(e.body, &body); err != nil {
+		return fmt.Errorf("log entry: %w", err)
+	}
+
+	var (
+		sig  []byte
+		cert []byte
+	)
+	switch {
+	case b.envelope == nil && (body.Kind == "hashedrekord" || body.Kind == "rekord"):
+		// hashedrekord and rekord entries have the same layout.
+		var spec struct {
+			Data struct {
+				Hash struct {
+					Algorithm string `json:"algorithm"`
+					Value     string `json:"value"`
+				} `json:"hash"`
+			} `json:"data"`
+			Signature struct {
+				Content   []byte `json:"content"`
+				PublicKey struct {
+					Content []byte `json:"content"`
+				} `json:"publicKey"`
+			} `json:"signature"`
+		}
+		if err := json.Unmarshal(body.Spec, &spec); err != nil {
+			return fmt.Errorf("log entry: %w", err)
+		}
+
+		if spec.Data.Hash.Algorithm != "sha256" || !strings.EqualFold(spec.Data.Hash.Value, hex.EncodeToString(digest)) {
+			return fmt.Errorf("%w: log entry of another file", errSignature)
+		}
+		sig, cert = spec.Signature.Content, spec.Signature.PublicKey.Content
+	case b.envelope != nil && body.Kind == "dsse":
+		var spec struct {
+			PayloadHash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"payloadHash"`
+			Signatures []struct {
+				Signature []byte `json:"signature"`
+				Verifier  []byte `json:"verifier"`
+			} `json:"signatures"`
+		}
+		if err := json.Unmarshal(body.Spec, &spec); err != nil {
+			return fmt.Errorf("log entry: %w", err)
+		}
+
+		h := spec.PayloadHash
+		if h.Algorithm != "sha256" || !strings.EqualFold(h.Value, hex.EncodeToString(sha256Sum(b.envelope.Payload))) {
+			return fmt.Errorf("%w: log entry of another attestation", errSignature)
+		}
+		for _, s := range spec.Signatures {
+			if bytes.Equal(s.Signature, b.sig) {
+				sig, cert = s.Signature, s.Verifier
+			}
+		}
+	default:
+		return fmt.Errorf("log entry: unsupported kind %q", body.Kind)
+	}
+
+	if !bytes.Equal(sig, b.sig) {
+		return fmt.Errorf("%w: log entry of another signature", errSignature)
+	}
+
+	certs, err := parseCerts(cert)
+	if err != nil || !bytes.Equal(certs[0].Raw, b.certs[0].Raw) {
+		return fmt.Errorf("%w: log entry of another certificate", errSignature)
+	}
+
+	return nil
+}
+
+// verifySig checks the ASN.1 ECDSA or PKCS #1 v1.5 signature `sig` of
+// the SHA-256 `digest`.
+func verifySig(pub crypto.PublicKey, digest, sig []byte) error {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) > 0 {
+			return fmt.Errorf("%w: malformed ECDSA signature", errSignature)
+		}
+
+		if !ecdsa.Verify(pub, digest, rs.R, rs.S) {
+			return errSignature
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig); err != nil {
+			return errSignature
+		}
+	default:
+		return fmt.Errorf("unsupported key %T", pub)
+	}
+
+	return nil
+}
+
+func sha256Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// readBundle reads the signature of the file at `path`.
+func readBundle(path string) (*sigBundle, error) {
+	b, err := ioutil.ReadFile(path + bundleExt)
+	if err == nil {
+		bu, err := parseBundle(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path+bundleExt, err)
+		}
+
+		return bu, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	sig, err := ioutil.ReadFile(path + sigExt)
+	if os.IsNotExist(err) {
+		return nil, errUnsigned
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ioutil.ReadFile(path + certExt)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: no certificate", path+sigExt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bu := &sigBundle{}
+	if bu.sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err != nil {
+		return nil, fmt.Errorf("%s: %w", path+sigExt, err)
+	}
+	if bu.certs, err = parseCerts(cert); err != nil {
+		return nil, fmt.Errorf("%s: %w", path+certExt, err)
+	}
+
+	return bu, nil
+}
+
+// parseBundle parses a bundle written by `cosign sign-blob --bundle`, or
+// a Sigstore bundle with a message signature or a DSSE envelope.
+func parseBundle(b []byte) (*sigBundle, error) {
+	var probe struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(probe.MediaType, "application/vnd.dev.sigstore.bundle") {
+		return parseSigstoreBundle(b)
+	}
+
+	var cb struct {
+		Base64Signature string `json:"base64Signature"`
+		Cert            string `json:"cert"`
+		RekorBundle     *struct {
+			SignedEntryTimestamp []byte `json:"SignedEntryTimestamp"`
+			Payload              struct {
+				Body           []byte `json:"body"`
+				IntegratedTime int64  `json:"integratedTime"`
+				LogIndex       int64  `json:"logIndex"`
+				LogID          string `json:"logID"`
+			} `json:"Payload"`
+		} `json:"rekorBundle"`
+	}
+	if err := json.Unmarshal(b, &cb); err != nil {
+		return nil, err
+	}
+
+	var (
+		bu  sigBundle
+		err error
+	)
+	if bu.sig, err = base64.StdEncoding.DecodeString(cb.Base64Signature); err != nil || len(bu.sig) == 0 {
+		return nil, errors.New("bad base64Signature")
+	}
+	if bu.certs, err = parseCerts([]byte(cb.Cert)); err != nil {
+		return nil, fmt.Errorf("cert: %w", err)
+	}
+
+	if rb := cb.RekorBundle; rb != nil {
+		bu.entry = &tlogEntry{
+			body:           rb.Payload.Body,
+			integratedTime: rb.Payload.IntegratedTime,
+			logIndex:       rb.Payload.LogIndex,
+			logID:          rb.Payload.LogID,
+			set:            rb.SignedEntryTimestamp,
+		}
+	}
+
+	return &bu, nil
+}
+
+func parseSigstoreBundle(b []byte) (*sigBundle, error) {
+	type rawCert struct {
+		RawBytes []byte `json:"rawBytes"`
+	}
+
+	// Integers are strings in the JSON encoding of protocol buffers.
+	var sb struct {
+		VerificationMaterial struct {
+			Certificate          *rawCert `json:"certificate"`
+			X509CertificateChain *struct {
+				Certificates []rawCert `json:"certificates"`
+			} `json:"x509CertificateChain"`
+			TlogEntries []struct {
+				LogIndex string `json:"logIndex"`
+				LogID    struct {
+					KeyID []byte `json:"keyId"`
+				} `json:"logId"`
+				IntegratedTime   string `json:"integratedTime"`
+				InclusionPromise *struct {
+					SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+				} `json:"inclusionPromise"`
+				CanonicalizedBody []byte `json:"canonicalizedBody"`
+			} `json:"tlogEntries"`
+		} `json:"verificationMaterial"`
+		MessageSignature *struct {
+			MessageDigest struct {
+				Algorithm string `json:"algorithm"`
+				Digest    []byte `json:"digest"`
+			} `json:"messageDigest"`
+			Signature []byte `json:"signature"`
+		} `json:"messageSignature"`
+		DSSEEnvelope *envelope `json:"dsseEnvelope"`
+	}
+	if err := json.Unmarshal(b, &sb); err != nil {
+		return nil, err
+	}
+
+	var bu sigBundle
+	switch ms, env := sb.MessageSignature, sb.DSSEEnvelope; {
+	case ms != nil && len(ms.Signature) > 0:
+		bu.sig = ms.Signature
+		if ms.MessageDigest.Algorithm != "" {
+			if ms.MessageDigest.Algorithm != "SHA2_256" {
+				return nil, fmt.Errorf("unsupported digest %s", ms.MessageDigest.Algorithm)
+			}
+
+			bu.digest = ms.MessageDigest.Digest
+		}
+	case env != nil && len(env.Signatures) > 0:
+		bu.sig = env.Signatures[0].Sig
+		bu.envelope = env
+	default:
+		return nil, errors.New("no message signature or DSSE envelope")
+	}
+
+	vm := sb.VerificationMaterial
+	var raws []rawCert
+	switch {
+	case vm.Certificate != nil:
+		raws = []rawCert{*vm.Certificate}
+	case vm.X509CertificateChain != nil:
+		raws = vm.X509CertificateChain.Certificates
+	}
+	if len(raws) == 0 {
+		return nil, errors.New("no certificate")
+	}
+
+	for _, r := range raws {
+		c, err := x509.ParseCertificate(r.RawBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		bu.certs = append(bu.certs, c)
+	}
+
+	for _, e := range vm.TlogEntries {
+		if e.InclusionPromise == nil {
+			continue
+		}
+
+		integrated, err := strconv.ParseInt(e.IntegratedTime, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("integratedTime: %w", err)
+		}
+		index, err := strconv.ParseInt(e.LogIndex, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("logIndex: %w", err)
+		}
+
+		bu.entry = &tlogEntry{
+			body:           e.CanonicalizedBody,
+			integratedTime: integrated,
+			logIndex:       index,
+			logID:          hex.EncodeToString(e.LogID.KeyID),
+			set:            e.InclusionPromise.SignedEntryTimestamp,
+		}
+		break
+	}
+
+	return &bu, nil
+}
+
+// parseCerts parses PEM certificates, which may be base64-encoded as in
+// cosign bundles.
+func parseCerts(b []byte) ([]*x509.Certificate, error) {
+	b = bytes.TrimSpace(b)
+	if !bytes.HasPrefix(b, []byte("-----")) {
+		dec, err := base64.StdEncoding.DecodeString(string(b))
+		if err != nil {
+			return nil, errors.New("no PEM certificate")
+		}
+		b = dec
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, c)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificate")
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,472 @@
+package check
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	testIdentity = "https://github.com/xaxes/self-update/.github/workflows/release.yml@refs/tags/v1.2.0"
+	testIssuer   = "https://token.actions.githubusercontent.com"
+)
+
+// testPKI is a certificate authority and a transparency log.
+type testPKI struct {
+	t *testing.T
+
+	root, intermediate       *x509.Certificate
+	rootKey, intermediateKey *ecdsa.PrivateKey
+	rekorKey                 *ecdsa.PrivateKey
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+func createCert(t *testing.T, tmpl, parent *x509.Certificate, pub *ecdsa.PublicKey, priv *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+
+	p := &testPKI{t: t, rootKey: newKey(t), intermediateKey: newKey(t), rekorKey: newKey(t)}
+
+	ca := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-24 * time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+	}
+
+	rootTmpl := ca(1, "root")
+	p.root = createCert(t, rootTmpl, rootTmpl, &p.rootKey.PublicKey, p.rootKey)
+	p.intermediate = createCert(t, ca(2, "intermediate"), p.root, &p.intermediateKey.PublicKey, p.rootKey)
+
+	return p
+}
+
+// leaf issues a signing certificate valid from `notBefore` for 10 minutes.
+func (p *testPKI) leaf(key *ecdsa.PrivateKey, identity string, notBefore time.Time) *x509.Certificate {
+	p.t.Helper()
+
+	u, err := url.Parse(identity)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	issuer, err := asn1.MarshalWithParams(testIssuer, "utf8")
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(3),
+		NotBefore:       notBefore,
+		NotAfter:        notBefore.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{u},
+		ExtraExtensions: []pkix.Extension{{Id: oidIssuerV2, Value: issuer}},
+	}
+
+	return createCert(p.t, tmpl, p.intermediate, &key.PublicKey, p.intermediateKey)
+}
+
+func (p *testPKI) verifier(withRekor bool) *Verifier {
+	p.t.Helper()
+
+	roots := pemCert(p.root) + pemCert(p.intermediate)
+
+	var rekorKey []byte
+	if withRekor {
+		der, err := x509.MarshalPKIXPublicKey(&p.rekorKey.PublicKey)
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		rekorKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+
+	v, err := NewVerifier([]byte(roots), rekorKey, testIdentity, testIssuer)
+	if err != nil {
+		p.t.Fatalf("NewVerifier() error = %v", err)
+	}
+
+	return v
+}
+
+func pemCert(c *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}))
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, digest []byte) []byte {
+	t.Helper()
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+// signedBlob is a signed file with its log entry.
+type signedBlob struct {
+	digest []byte
+	sig    []byte
+	cert   *x509.Certificate
+
+	body           []byte
+	integratedTime int64
+	logIndex       int64
+	logID          string
+	set            []byte
+}
+
+// signBlob signs `b` with a certificate issued to `identity` and logs the
+// signature.
+func (p *testPKI) signBlob(b []byte, identity string, integrated time.Time) signedBlob {
+	p.t.Helper()
+
+	key := newKey(p.t)
+	s := signedBlob{
+		digest:         sha256Sum(b),
+		cert:           p.leaf(key, identity, integrated.Add(-time.Minute)),
+		integratedTime: integrated.Unix(),
+		logIndex:       42,
+	}
+	s.sig = sign(p.t, key, s.digest)
+	s.logID = p.logID()
+
+	s.body = p.entryBody(s.digest, s.sig, s.cert)
+	s.set = p.signEntry(s)
+
+	return s
+}
+
+// logID returns the ID of the transparency log.
+func (p *testPKI) logID() string {
+	p.t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(&p.rekorKey.PublicKey)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	return hex.EncodeToString(sha256Sum(der))
+}
+
+func (p *testPKI) entryBody(digest, sig []byte, cert *x509.Certificate) []byte {
+	p.t.Helper()
+
+	body := map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]interface{}{
+			"data": map[string]interface{}{
+				"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(digest)},
+			},
+			"signature": map[string]interface{}{
+				"content":   sig,
+				"publicKey": map[string]interface{}{"content": []byte(pemCert(cert))},
+			},
+		},
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	return b
+}
+
+func (p *testPKI) signEntry(s signedBlob) []byte {
+	p.t.Helper()
+
+	payload := `{"body":"` + base64.StdEncoding.EncodeToString(s.body) +
+		`","integratedTime":` + strconv.FormatInt(s.integratedTime, 10) +
+		`,"logID":"` + s.logID +
+		`","logIndex":` + strconv.FormatInt(s.logIndex, 10) + `}`
+
+	return sign(p.t, p.rekorKey, sha256Sum([]byte(payload)))
+}
+
+// cosignBundle encodes `s` like `cosign sign-blob --bundle`.
+func cosignBundle(t *testing.T, s signedBlob) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]interface{}{
+		"base64Signature": base64.StdEncoding.EncodeToString(s.sig),
+		"cert":            base64.StdEncoding.EncodeToString([]byte(pemCert(s.cert))),
+		"rekorBundle": map[string]interface{}{
+			"SignedEntryTimestamp": s.set,
+			"Payload": map[string]interface{}{
+				"body":           base64.StdEncoding.EncodeToString(s.body),
+				"integratedTime": s.integratedTime,
+				"logIndex":       s.logIndex,
+				"logID":          s.logID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// sigstoreBundle encodes `s` as a Sigstore bundle.
+func sigstoreBundle(t *testing.T, s signedBlob) []byte {
+	t.Helper()
+
+	logID, err := hex.DecodeString(s.logID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json",
+		"verificationMaterial": map[string]interface{}{
+			"certificate": map[string]interface{}{"rawBytes": s.cert.Raw},
+			"tlogEntries": []interface{}{map[string]interface{}{
+				"logIndex":          strconv.FormatInt(s.logIndex, 10),
+				"logId":             map[string]interface{}{"keyId": logID},
+				"kindVersion":       map[string]string{"kind": "hashedrekord", "version": "0.0.1"},
+				"integratedTime":    strconv.FormatInt(s.integratedTime, 10),
+				"inclusionPromise":  map[string]interface{}{"signedEntryTimestamp": s.set},
+				"canonicalizedBody": s.body,
+			}},
+		},
+		"messageSignature": map[string]interface{}{
+			"messageDigest": map[string]interface{}{"algorithm": "SHA2_256", "digest": s.digest},
+			"signature":     s.sig,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	p := newTestPKI(t)
+	content := []byte("#!/bin/sh\necho 1.2.0\n")
+	hash := hex.EncodeToString(sha256Sum(content))
+
+	// The certificate expired long ago; the log proves it was valid.
+	integrated := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		// files are the signature files by extension.
+		files     func() map[string][]byte
+		withRekor bool
+		wantErr   bool
+	}{
+		{
+			name: "cosign bundle",
+			files: func() map[string][]byte {
+				return map[string][]byte{bundleExt: cosignBundle(t, p.signBlob(content, testIdentity, integrated))}
+			},
+			withRekor: true,
+		},
+		{
+			name: "Sigstore bundle",
+			files: func() map[string][]byte {
+				return map[string][]byte{bundleExt: sigstoreBundle(t, p.signBlob(content, testIdentity, integrated))}
+			},
+			withRekor: true,
+		},
+		{
+			name: "signature and certificate",
+			files: func() map[string][]byte {
+				s := p.signBlob(content, testIdentity, time.Now())
+				return map[string][]byte{
+					sigExt:  []byte(base64.StdEncoding.EncodeToString(s.sig)),
+					certExt: []byte(pemCert(s.cert)),
+				}
+			},
+		},
+		{
+			name: "expired certificate without log entry",
+			files: func() map[string][]byte {
+				s := p.signBlob(content, testIdentity, integrated)
+				return map[string][]byte{
+					sigExt:  []byte(base64.StdEncoding.EncodeToString(s.sig)),
+					certExt: []byte(pemCert(s.cert)),
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "log entry required",
+			files: func() map[string][]byte {
+				s := p.signBlob(content, testIdentity, time.Now())
+				return map[string][]byte{
+					sigExt:  []byte(base64.StdEncoding.EncodeToString(s.sig)),
+					certExt: []byte(pemCert(s.cert)),
+				}
+			},
+			withRekor: true,
+			wantErr:   true,
+		},
+		{
+			name:    "unsigned",
+			files:   func() map[string][]byte { return nil },
+			wantErr: true,
+		},
+		{
+			name: "another identity",
+			files: func() map[string][]byte {
+				return map[string][]byte{bundleExt: cosignBundle(t, p.signBlob(content, "https://example.com/fork", integrated))}
+			},
+			withRekor: true,
+			wantErr:   true,
+		},
+		{
+			name: "another file",
+			files: func() map[string][]byte {
+				return map[string][]byte{bundleExt: cosignBundle(t, p.signBlob([]byte("other"), testIdentity, integrated))}
+			},
+			withRekor: true,
+			wantErr:   true,
+		},
+		{
+			name: "untrusted log",
+			files: func() map[string][]byte {
+				s := p.signBlob(content, testIdentity, integrated)
+				s.set = sign(t, newKey(t), sha256Sum(s.body))
+				return map[string][]byte{bundleExt: cosignBundle(t, s)}
+			},
+			withRekor: true,
+			wantErr:   true,
+		},
+		{
+			name: "logged outside the validity",
+			files: func() map[string][]byte {
+				s := p.signBlob(content, testIdentity, integrated)
+				s.integratedTime = time.Now().Unix()
+				s.set = p.signEntry(s)
+				return map[string][]byte{bundleExt: cosignBundle(t, s)}
+			},
+			withRekor: true,
+			wantErr:   true,
+		},
+		{
+			name: "untrusted root",
+			files: func() map[string][]byte {
+				other := newTestPKI(t)
+				other.rekorKey = p.rekorKey
+				return map[string][]byte{bundleExt: cosignBundle(t, other.signBlob(content, testIdentity, integrated))}
+			},
+			withRekor: true,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "cosign")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "self-update-1.2.0")
+			if err := ioutil.WriteFile(path, content, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for ext, b := range tt.files() {
+				if err := ioutil.WriteFile(path+ext, b, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = p.verifier(tt.withRekor).Verify(path, hash)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	p := newTestPKI(t)
+
+	tests := []struct {
+		name     string
+		roots    string
+		identity string
+	}{
+		{"no identity", pemCert(p.root), ""},
+		{"no roots", "", testIdentity},
+		{"intermediates only", pemCert(p.intermediate), testIdentity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewVerifier([]byte(tt.roots), nil, tt.identity, ""); err == nil {
+				t.Error("NewVerifier() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestNewVerifier_publicRekorKey(t *testing.T) {
+	p := newTestPKI(t)
+
+	v, err := NewVerifier([]byte(pemCert(p.root)), []byte(PublicRekorKey), testIdentity, "")
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	// The log ID of rekor.sigstore.dev.
+	if want := "c0d23d6ad406973f9559f3ba2d1ca01f84147d8ffc5b8445c224f98b9591801d"; v.rekorID != want {
+		t.Errorf("rekorID = %s, want %s", v.rekorID, want)
+	}
+}
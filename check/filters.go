@@ -2,6 +2,11 @@ package check
 
 import (
 	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/xaxes/self-update/upgrade"
+	"go.uber.org/zap"
 )
 
 type filterPredicate func(f os.FileInfo) bool
@@ -11,24 +16,199 @@ func dirFilter(f os.FileInfo) bool {
 	return !f.IsDir()
 }
 
-// sameFileFilter filters out the binary itself.
-func sameFileFilter(f os.FileInfo) bool {
-	return os.Args[0] != f.Name()
+// running is the running binary, hashed once per version of it.
+var running struct {
+	mu   sync.Mutex
+	fi   os.FileInfo
+	hash string
+}
+
+// runningBinary returns the file info and the SHA-256 of the running
+// binary.
+func runningBinary() (os.FileInfo, string, error) {
+	exe, err := upgrade.Executable()
+	if err != nil {
+		return nil, "", err
+	}
+
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return nil, "", err
+	}
+
+	running.mu.Lock()
+	defer running.mu.Unlock()
+
+	if running.fi != nil && os.SameFile(running.fi, fi) && running.fi.Size() == fi.Size() && running.fi.ModTime().Equal(fi.ModTime()) {
+		return running.fi, running.hash, nil
+	}
+
+	hash, err := hashFile(exe)
+	if err != nil {
+		return nil, "", err
+	}
+	running.fi, running.hash = fi, hash
+
+	return fi, hash, nil
 }
 
-// executableFilter filters out files without executable bit.
+// sameFileFilter filters out the running binary and copies of it in
+// `dir`, whether linked to it or with the same content. Symlinks are
+// followed.
+func sameFileFilter(dir string) filterPredicate {
+	exe, hash, err := runningBinary()
+	if err != nil {
+		zap.L().Error("read running binary; not filtering out copies of it", zap.Error(err))
+		return func(os.FileInfo) bool { return true }
+	}
+
+	return func(f os.FileInfo) bool {
+		path := filepath.Join(dir, f.Name())
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return true
+		}
+		if os.SameFile(exe, fi) {
+			return false
+		}
+		if fi.IsDir() || fi.Size() != exe.Size() {
+			return true
+		}
+
+		h, err := hashFile(path)
+		if err != nil {
+			zap.L().Debug("hash upgrade candidate", zap.String("path", path), zap.Error(err))
+			return true
+		}
+
+		return h != hash
+	}
+}
+
+// executableFilter filters out files the process cannot execute and
+// files other users can write to.
 //
-// It is overly simplified as it only checks if any of
+// Whether the process can execute a file is approximated from its mode
+// bits and owner against the effective uid, gid and supplementary groups;
+// ACLs, capabilities and security modules such as SELinux are not taken
+// into account, so the kernel may still refuse to execute it.
 //
-// 1. User
-// 2. Group
-// 3. Others
+// Files writable by others could be replaced by another user with any
+// binary, which would then run with the privileges of the process. A
+// file is writable by other users if it is
 //
-// executable bit is set.
+// 1. World-writable,
+// 2. Group-writable,
+// 3. Owned by a user other than root and the effective one.
 //
-// It should check current proccess' ability to execute the file instead.
+// Files whose owner is unknown, e.g. on Windows, only need an executable
+// bit set.
 func executableFilter(f os.FileInfo) bool {
-	return f.Mode()&0111 != 0
+	return canExecute(f) && !writableByOthers(f)
+}
+
+func canExecute(f os.FileInfo) bool {
+	mode := f.Mode()
+
+	uid, gid, ok := fileOwner(f)
+	if !ok {
+		return mode&0111 != 0
+	}
+
+	// Root may execute files with any executable bit set.
+	switch euid := os.Geteuid(); {
+	case euid == 0:
+		return mode&0111 != 0
+	case uint32(euid) == uid:
+		return mode&0100 != 0
+	case inGroup(gid):
+		return mode&0010 != 0
+	}
+
+	return mode&0001 != 0
+}
+
+func writableByOthers(f os.FileInfo) bool {
+	if f.Mode()&0022 != 0 {
+		return true
+	}
+
+	uid, _, ok := fileOwner(f)
+	return ok && uid != 0 && uid != uint32(os.Geteuid())
+}
+
+// writableDirFilter filters out all files of `dir` if other users can
+// write to it without the sticky bit set, as they could then rename any
+// of them and put another binary in its place. The directory is checked
+// with Lstat; if it is a symlink, the directory it points to is.
+func writableDirFilter(dir string) filterPredicate {
+	fi, err := os.Lstat(dir)
+	if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		var target string
+		if target, err = filepath.EvalSymlinks(dir); err == nil {
+			fi, err = os.Lstat(target)
+		}
+	}
+	if err != nil {
+		zap.L().Error("check upgrade directory", zap.String("dir", dir), zap.Error(err))
+		return func(os.FileInfo) bool { return false }
+	}
+
+	writable := fi.Mode()&0022 != 0 && fi.Mode()&os.ModeSticky == 0
+	if uid, _, ok := fileOwner(fi); ok && uid != 0 && uid != uint32(os.Geteuid()) {
+		writable = true
+	}
+
+	return func(f os.FileInfo) bool {
+		if writable {
+			zap.L().Warn("reject upgrade candidate in a directory writable by others",
+				zap.String("path", filepath.Join(dir, f.Name())), zap.Stringer("mode", fi.Mode()))
+		}
+
+		return !writable
+	}
+}
+
+// inGroup reports whether `gid` is the effective or a supplementary
+// group of the process.
+func inGroup(gid uint32) bool {
+	if uint32(os.Getegid()) == gid {
+		return true
+	}
+
+	groups, err := os.Getgroups()
+	if err != nil {
+		zap.L().Error("get supplementary groups", zap.Error(err))
+		return false
+	}
+
+	for _, g := range groups {
+		if uint32(g) == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// noexecFilter filters out files of `dir` on filesystems mounted with
+// noexec, which cannot be executed whatever their mode.
+func noexecFilter(dir string) filterPredicate {
+	return func(f os.FileInfo) bool {
+		path := filepath.Join(dir, f.Name())
+
+		noexec, err := mountedNoexec(path)
+		if err != nil {
+			zap.L().Debug("check mount options", zap.String("path", path), zap.Error(err))
+			return true
+		}
+		if noexec {
+			zap.L().Warn("reject upgrade candidate on a noexec mount", zap.String("path", path))
+		}
+
+		return !noexec
+	}
 }
 
 // filter filters out `fs` with `pred`.
@@ -1,7 +1,9 @@
 package check
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -100,7 +102,16 @@ func Test_executableFilter(t *testing.T) {
 					mode: 0777,
 				},
 			},
-			want: true,
+			want: false,
+		},
+		{
+			name: "world-writable",
+			args: args{
+				f: fileInfo{
+					mode: 0757,
+				},
+			},
+			want: false,
 		},
 		{
 			name: "666",
@@ -122,36 +133,75 @@ func Test_executableFilter(t *testing.T) {
 }
 
 func Test_sameFileFilter(t *testing.T) {
-	type args struct {
-		f os.FileInfo
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "samefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "copy"), b, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	b[len(b)-1]++
+	if err := ioutil.WriteFile(filepath.Join(dir, "modified"), b, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(exe, filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlink: %v", err)
 	}
+
 	tests := []struct {
 		name string
-		args args
+		dir  string
+		file string
 		want bool
 	}{
 		{
-			name: "same",
-			args: args{
-				f: fileInfo{
-					name: os.Args[0],
-				},
-			},
+			name: "running",
+			dir:  filepath.Dir(exe),
+			file: filepath.Base(exe),
 			want: false,
 		},
 		{
-			name: "different",
-			args: args{
-				f: fileInfo{
-					name: "whatever",
-				},
-			},
+			name: "symlink",
+			dir:  dir,
+			file: "link",
+			want: false,
+		},
+		{
+			name: "copy",
+			dir:  dir,
+			file: "copy",
+			want: false,
+		},
+		{
+			name: "same size",
+			dir:  dir,
+			file: "modified",
+			want: true,
+		},
+		{
+			name: "named like the running one",
+			dir:  dir,
+			file: filepath.Base(exe),
 			want: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := sameFileFilter(tt.args.f); got != tt.want {
+			if got := sameFileFilter(tt.dir)(fileInfo{name: tt.file}); got != tt.want {
 				t.Errorf("sameFileFilter() = %v, want %v", got, tt.want)
 			}
 		})
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package check
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the owner and the group of `f`.
+func fileOwner(f os.FileInfo) (uid, gid uint32, ok bool) {
+	st, ok := f.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return st.Uid, st.Gid, true
+}
@@ -0,0 +1,136 @@
+//go:build !windows
+// +build !windows
+
+package check
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func Test_executableFilter_owner(t *testing.T) {
+	euid, egid := uint32(os.Geteuid()), uint32(os.Getegid())
+	root, other := euid == 0, euid+1
+
+	tests := []struct {
+		name string
+		mode os.FileMode
+		uid  uint32
+		gid  uint32
+		want bool
+	}{
+		{
+			name: "owner executable",
+			mode: 0700,
+			uid:  euid,
+			gid:  egid,
+			want: true,
+		},
+		{
+			name: "others executable only",
+			mode: 0011,
+			uid:  euid,
+			gid:  egid,
+			want: root,
+		},
+		{
+			name: "owned by root",
+			mode: 0755,
+			uid:  0,
+			gid:  0,
+			want: true,
+		},
+		{
+			name: "owned by another user",
+			mode: 0755,
+			uid:  other,
+			gid:  egid,
+			want: false,
+		},
+		{
+			name: "writable by own group",
+			mode: 0775,
+			uid:  euid,
+			gid:  egid,
+			want: false,
+		},
+		{
+			name: "writable by another group",
+			mode: 0775,
+			uid:  euid,
+			gid:  egid + 1,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := fileInfo{mode: tt.mode, sys: &syscall.Stat_t{Uid: tt.uid, Gid: tt.gid}}
+			if got := executableFilter(f); got != tt.want {
+				t.Errorf("executableFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writableDirFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		mode os.FileMode
+		want bool
+	}{
+		{name: "owner writable", mode: 0755, want: true},
+		{name: "group writable", mode: 0775, want: false},
+		{name: "world writable", mode: 0777, want: false},
+		{name: "sticky", mode: 0777 | os.ModeSticky, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "writable")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			if err := os.Chmod(dir, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := writableDirFilter(dir)(fileInfo{name: "app"}); got != tt.want {
+				t.Errorf("writableDirFilter() = %v, want %v", got, tt.want)
+			}
+
+			link := dir + ".link"
+			if err := os.Symlink(dir, link); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(link)
+
+			if got := writableDirFilter(link)(fileInfo{name: "app"}); got != tt.want {
+				t.Errorf("writableDirFilter() of a symlink = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_noexecFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "noexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(dir+"/app", []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	noexec, err := mountedNoexec(dir)
+	if err != nil {
+		t.Fatalf("mountedNoexec() error = %v", err)
+	}
+
+	if got := noexecFilter(dir)(fileInfo{name: "app"}); got == noexec {
+		t.Errorf("noexecFilter() = %v on a mount with noexec %v", got, noexec)
+	}
+}
@@ -0,0 +1,15 @@
+package check
+
+import (
+	"os"
+)
+
+// fileOwner returns false; Windows has ACLs instead.
+func fileOwner(f os.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
+
+// mountedNoexec returns false; Windows has no noexec mounts.
+func mountedNoexec(path string) (bool, error) {
+	return false, nil
+}
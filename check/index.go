@@ -0,0 +1,651 @@
+package check
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/fsnotify/fsnotify"
+	"github.com/xaxes/self-update/tracing"
+	"github.com/xaxes/self-update/upgrade"
+	"go.uber.org/zap"
+)
+
+// probeKey identifies the content of a binary the version was probed of.
+type probeKey struct {
+	path    string
+	size    int64
+	modTime int64
+	hash    string
+}
+
+// probeResult is the outcome of `<binary> -version` or of staging
+// an archive.
+type probeResult struct {
+	candidate Candidate
+	err       error
+}
+
+// Index keeps the versions of the binaries in a directory up to date
+// with filesystem events, so candidates are not executed on every lookup.
+//
+// Probe results are cached by path, size, modification time and hash.
+// Binaries are probed once no events arrived for them within the debounce
+// period, so partially written files are not executed.
+//
+// Archives are extracted into the staging directory and their binaries
+// are the candidates. Staged archives which are not in the directory
+// anymore are removed, so the staging directory must not be shared with
+// another index.
+//
+// The binaries of the running platform may be kept in the subdirectory
+// PlatformDir, e.g. linux-amd64, which is indexed too. Binaries built
+// for other platforms are not candidates.
+//
+// If a directory has a SHA256SUMS file, only the files listed in it
+// with a matching SHA-256 are candidates. With a verifier, only signed
+// files are; with a policy, only the ones complying with it are.
+type Index struct {
+	dir      string
+	platform string // PlatformDir in `dir`
+	staging  string
+	exe      string // base of patches
+	verifier *Verifier
+	policy   *Policy
+	debounce time.Duration
+	watcher  *fsnotify.Watcher
+
+	// staged is held for reading while archives are staged and for
+	// writing while the staging directory is pruned, so trees are not
+	// removed before their results are cached.
+	staged sync.RWMutex
+
+	mu      sync.Mutex
+	entries map[string]Candidate // probed candidates by the path in `dir`
+	cache   map[probeKey]probeResult
+	pending map[string]*time.Timer
+	// gens counts the events by path, so results of outdated probes
+	// are discarded.
+	gens map[string]int
+
+	// sums are the checksums from SHA256SUMS by directory and name, nil
+	// for directories without; sumsErrs are the errors reading them.
+	sums         map[string]map[string]string
+	sumsErrs     map[string]error
+	mismatches   map[string]*Mismatch
+	incompatible map[string]*Incompatible
+}
+
+// NewIndex indexes the binaries and archives in `dir` and starts
+// watching it. Archives are extracted into `staging`. Signatures are
+// verified by `v` and candidates checked against `p`, unless nil.
+//
+// If the directory cannot be watched, the index is rebuilt on every
+// lookup instead.
+func NewIndex(dir, staging string, v *Verifier, p *Policy, debounce time.Duration) (*Index, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	staging, err = filepath.Abs(staging)
+	if err != nil {
+		return nil, err
+	}
+
+	exe, err := upgrade.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Index{
+		dir:          abs,
+		platform:     filepath.Join(abs, PlatformDir),
+		staging:      staging,
+		exe:          exe,
+		verifier:     v,
+		policy:       p,
+		debounce:     debounce,
+		entries:      make(map[string]Candidate),
+		cache:        make(map[probeKey]probeResult),
+		pending:      make(map[string]*time.Timer),
+		gens:         make(map[string]int),
+		mismatches:   make(map[string]*Mismatch),
+		incompatible: make(map[string]*Incompatible),
+	}
+
+	i.watcher, err = fsnotify.NewWatcher()
+	if err == nil {
+		err = i.watcher.Add(abs)
+	}
+
+	if err != nil {
+		zap.L().Error("watch upgrade directory; rescanning on every lookup", zap.String("dir", abs), zap.Error(err))
+
+		if i.watcher != nil {
+			i.watcher.Close()
+			i.watcher = nil
+		}
+	} else {
+		go i.watch()
+	}
+
+	if err := i.Rescan(); err != nil {
+		i.Close()
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Close stops watching the directory.
+func (i *Index) Close() error {
+	if i.watcher == nil {
+		return nil
+	}
+
+	return i.watcher.Close()
+}
+
+func (i *Index) watch() {
+	for {
+		select {
+		case e, ok := <-i.watcher.Events:
+			if !ok {
+				return
+			}
+
+			zap.L().Debug("upgrade directory event", zap.String("event", e.String()))
+			i.schedule(e.Name)
+		case err, ok := <-i.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			zap.L().Error("watch upgrade directory", zap.Error(err))
+
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				if err := i.Rescan(); err != nil {
+					zap.L().Error("rescan upgrade directory", zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
+// schedule probes `path` once no events arrive for it within the
+// debounce period.
+func (i *Index) schedule(path string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.gens[path]++
+
+	if t, ok := i.pending[path]; ok {
+		t.Reset(i.debounce)
+		return
+	}
+
+	i.pending[path] = time.AfterFunc(i.debounce, func() {
+		i.mu.Lock()
+		delete(i.pending, path)
+		gen := i.gens[path]
+		i.mu.Unlock()
+
+		i.update(path, gen)
+	})
+}
+
+// update probes `path` and records the result unless another event
+// arrived for it in the meantime.
+func (i *Index) update(path string, gen int) {
+	// Changed checksums affect all candidates; the platform directory
+	// has its own.
+	if filepath.Base(path) == SumsName || path == i.platform {
+		if err := i.Rescan(); err != nil {
+			zap.L().Error("rescan upgrade directory", zap.Error(err))
+		}
+		return
+	}
+
+	// Changed signatures and attestations affect their subject.
+	if subject, ok := subjectOf(path); ok && (i.verifier != nil || i.policy != nil) {
+		i.schedule(subject)
+		return
+	}
+
+	c, err := i.probe(path)
+
+	// Staged copies of the previous contents are of no use anymore.
+	if isArchive(filepath.Base(path)) {
+		defer i.pruneStaging()
+	}
+
+	// The binary is still being written to.
+	if errors.Is(err, syscall.ETXTBSY) {
+		i.schedule(path)
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.gens[path] != gen {
+		return
+	}
+
+	if err != nil {
+		delete(i.entries, path)
+
+		if os.IsNotExist(err) {
+			i.forget(path)
+		}
+
+		var m *Mismatch
+		if !errors.As(err, &m) {
+			delete(i.mismatches, path)
+		}
+		var inc *Incompatible
+		if !errors.As(err, &inc) {
+			delete(i.incompatible, path)
+		}
+		return
+	}
+
+	i.entries[path] = c
+	zap.L().Info("index upgrade candidate", zap.String("path", path), zap.String("bin", c.Path), zap.Stringer("version", c.Version))
+}
+
+// probe returns the candidate at `path`.
+//
+// It returns an error if the file is not a candidate.
+func (i *Index) probe(path string) (Candidate, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Candidate{}, err
+	}
+
+	archive := !fi.IsDir() && isArchive(fi.Name())
+	if fi.Name() == SumsName || !archive && len(updateCandidates(filepath.Dir(path), []os.FileInfo{fi})) == 0 {
+		return Candidate{}, ErrNoCandidate
+	}
+
+	hash, err := hashFile(path)
+	if err != nil {
+		return Candidate{}, err
+	}
+
+	if err := i.verify(path, hash); err != nil {
+		return Candidate{}, err
+	}
+
+	if i.verifier != nil {
+		if err := i.verifier.Verify(path, hash); err != nil {
+			zap.L().Warn("reject unsigned upgrade candidate", zap.String("path", path), zap.Error(err))
+			return Candidate{}, err
+		}
+	}
+
+	key := probeKey{path, fi.Size(), fi.ModTime().UnixNano(), hash}
+
+	i.mu.Lock()
+	res, ok := i.cache[key]
+	i.mu.Unlock()
+
+	if ok {
+		i.noteIncompatible(path, res.err)
+		return i.checkPolicy(path, hash, res.candidate, res.err)
+	}
+
+	var c Candidate
+	if archive {
+		i.staged.RLock()
+		defer i.staged.RUnlock()
+
+		c, err = stage(path, hash, i.staging, i.exe)
+	} else if err = checkPlatform(path); err == nil {
+		zap.L().Debug("check version", zap.String("bin", path))
+		c.Path = path
+		c.Version, err = versionFromBin(path)
+	}
+	if err != nil {
+		if errors.Is(err, syscall.ETXTBSY) {
+			return Candidate{}, err
+		}
+
+		var inc *Incompatible
+		if errors.As(err, &inc) {
+			zap.L().Warn("reject upgrade candidate", zap.String("path", path), zap.Error(err))
+		} else if errors.Is(err, ErrPatchBase) {
+			zap.L().Info("skip patch of another binary", zap.String("path", path), zap.Error(err))
+		} else {
+			zap.L().Debug("check version", zap.String("bin", path), zap.Error(err))
+			probeFailures.With(filepath.Base(path), probeFailure(err)).Inc()
+		}
+		c = Candidate{}
+	}
+
+	i.mu.Lock()
+	// Results of the previous contents are of no use anymore.
+	i.forget(path)
+	i.cache[key] = probeResult{c, err}
+	i.mu.Unlock()
+
+	i.noteIncompatible(path, err)
+	return i.checkPolicy(path, hash, c, err)
+}
+
+// forget drops the cached probe results of `path`. It must be called
+// with `mu` held.
+func (i *Index) forget(path string) {
+	for k := range i.cache {
+		if k.path == path {
+			delete(i.cache, k)
+		}
+	}
+}
+
+// pruneStaging removes the staged archives no cached result refers to,
+// except for the one of the running binary.
+func (i *Index) pruneStaging() {
+	i.staged.Lock()
+	defer i.staged.Unlock()
+
+	keep := map[string]bool{stagedDir(i.staging, i.exe): true}
+
+	i.mu.Lock()
+	for _, res := range i.cache {
+		if res.err == nil {
+			keep[stagedDir(i.staging, res.candidate.Path)] = true
+		}
+	}
+	i.mu.Unlock()
+
+	fs, err := ioutil.ReadDir(i.staging)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			zap.L().Error("prune staging directory", zap.String("dir", i.staging), zap.Error(err))
+		}
+		return
+	}
+
+	for _, f := range fs {
+		// Archives being extracted are hidden.
+		if !f.IsDir() || strings.HasPrefix(f.Name(), ".") || keep[f.Name()] {
+			continue
+		}
+
+		path := filepath.Join(i.staging, f.Name())
+		if err := os.RemoveAll(path); err != nil {
+			zap.L().Error("remove staged archive", zap.String("dir", path), zap.Error(err))
+			continue
+		}
+		zap.L().Info("remove staged archive", zap.String("dir", path))
+	}
+}
+
+// stagedDir returns the name of the directory in `staging` that `path`
+// is in, or an empty string if it is not in one.
+func stagedDir(staging, path string) string {
+	rel, err := filepath.Rel(staging, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+
+	return strings.SplitN(rel, string(filepath.Separator), 2)[0]
+}
+
+// noteIncompatible records whether the probe of `path` failed as it is
+// built for another platform.
+func (i *Index) noteIncompatible(path string, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var inc *Incompatible
+	if errors.As(err, &inc) {
+		i.incompatible[path] = inc
+	} else {
+		delete(i.incompatible, path)
+	}
+}
+
+// checkPolicy checks the probed candidate `c` at `path` against the
+// policy.
+func (i *Index) checkPolicy(path, hash string, c Candidate, err error) (Candidate, error) {
+	if err != nil || i.policy == nil {
+		return c, err
+	}
+
+	if err := i.policy.Check(path, hash, c.Path); err != nil {
+		zap.L().Warn("reject upgrade candidate", zap.String("path", path), zap.Error(err))
+		return Candidate{}, err
+	}
+
+	return c, nil
+}
+
+// subjectOf returns the file the signature or attestation at `path` is
+// about.
+func subjectOf(path string) (string, bool) {
+	for _, ext := range []string{bundleExt, sigExt, certExt, provenanceExt} {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext), true
+		}
+	}
+
+	return "", false
+}
+
+// verify checks `hash` of the file at `path` against the SHA256SUMS of
+// its directory.
+func (i *Index) verify(path, hash string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.mismatches, path)
+
+	dir := filepath.Dir(path)
+	sums := i.sums[dir]
+
+	switch {
+	case i.sumsErrs[dir] != nil:
+		return i.sumsErrs[dir]
+	case sums == nil && RequireSums:
+		return errNoSums
+	case sums == nil:
+		return nil
+	}
+
+	want, ok := sums[filepath.Base(path)]
+	if !ok {
+		return errNotListed
+	}
+
+	if want != hash {
+		m := &Mismatch{Path: path, Want: want, Got: hash}
+		i.mismatches[path] = m
+		zap.L().Warn("reject upgrade candidate", zap.Error(m))
+		return m
+	}
+
+	return nil
+}
+
+// Mismatches returns the files rejected as their SHA-256 differs from
+// SHA256SUMS, ordered by path.
+func (i *Index) Mismatches() []Mismatch {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	ms := make([]Mismatch, 0, len(i.mismatches))
+	for _, m := range i.mismatches {
+		ms = append(ms, *m)
+	}
+	sort.Slice(ms, func(a, b int) bool { return ms[a].Path < ms[b].Path })
+
+	return ms
+}
+
+// Incompatibles returns the binaries rejected as they are built for
+// another platform, ordered by path.
+func (i *Index) Incompatibles() []Incompatible {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	is := make([]Incompatible, 0, len(i.incompatible))
+	for _, inc := range i.incompatible {
+		is = append(is, *inc)
+	}
+	sort.Slice(is, func(a, b int) bool { return is[a].Path < is[b].Path })
+
+	return is
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Rescan rebuilds the index from the directory listings and SHA256SUMS.
+//
+// Unchanged binaries are not executed again.
+func (i *Index) Rescan() error {
+	fs, err := ioutil.ReadDir(i.dir)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, f := range filter(fs, dirFilter) {
+		paths = append(paths, filepath.Join(i.dir, f.Name()))
+	}
+
+	dirs := []string{i.dir}
+	if fs, err := ioutil.ReadDir(i.platform); err == nil {
+		dirs = append(dirs, i.platform)
+		for _, f := range filter(fs, dirFilter) {
+			paths = append(paths, filepath.Join(i.platform, f.Name()))
+		}
+
+		// Created after the index, or recreated.
+		if i.watcher != nil {
+			if err := i.watcher.Add(i.platform); err != nil {
+				zap.L().Error("watch platform directory", zap.String("dir", i.platform), zap.Error(err))
+			}
+		}
+	}
+
+	sums := make(map[string]map[string]string)
+	sumsErrs := make(map[string]error)
+	for _, dir := range dirs {
+		sums[dir], sumsErrs[dir] = readSums(dir)
+		if sumsErrs[dir] != nil {
+			zap.L().Error("read checksums; rejecting all candidates", zap.String("dir", dir), zap.Error(sumsErrs[dir]))
+		}
+	}
+
+	i.mu.Lock()
+	i.sums, i.sumsErrs = sums, sumsErrs
+	i.mismatches = make(map[string]*Mismatch)
+	i.incompatible = make(map[string]*Incompatible)
+	i.mu.Unlock()
+
+	entries := make(map[string]Candidate)
+	listed := make(map[string]bool)
+	for _, path := range paths {
+		listed[path] = true
+
+		c, err := i.probe(path)
+		if err != nil {
+			continue
+		}
+
+		entries[path] = c
+	}
+
+	i.mu.Lock()
+	i.entries = entries
+	for k := range i.cache {
+		if !listed[k.path] {
+			delete(i.cache, k)
+		}
+	}
+	i.mu.Unlock()
+
+	i.pruneStaging()
+
+	return nil
+}
+
+// Newest returns the candidate with the newest version greater than
+// `currVersion`.
+func (i *Index) Newest(ctx context.Context, currVersion string) (Candidate, error) {
+	c, _, err := i.newest(ctx, currVersion)
+	return c, err
+}
+
+// newest is Newest also returning the number of indexed candidates.
+func (i *Index) newest(ctx context.Context, currVersion string) (Candidate, int, error) {
+	_, span := tracing.Start(ctx, "check.Index.Newest", tracing.KindInternal, tracing.String("dir", i.dir))
+	defer span.End()
+
+	curr, err := semver.NewVersion(currVersion)
+	if err != nil {
+		span.RecordError(err)
+		return Candidate{}, 0, err
+	}
+
+	if i.watcher == nil {
+		if err := i.Rescan(); err != nil {
+			span.RecordError(err)
+			return Candidate{}, 0, err
+		}
+	}
+
+	i.mu.Lock()
+	var newer []Candidate
+	for _, c := range i.entries {
+		if isNewer(c.Version, curr) {
+			newer = append(newer, c)
+		}
+	}
+	n := len(i.entries)
+	span.SetAttributes(tracing.String("candidates", strconv.Itoa(n)))
+	i.mu.Unlock()
+
+	if len(newer) == 0 {
+		span.RecordError(ErrNoCandidate)
+		return Candidate{}, n, ErrNoCandidate
+	}
+
+	// Binaries of the same version are ordered by path, as in the listing.
+	sort.Slice(newer, func(a, b int) bool { return newer[a].Path < newer[b].Path })
+	sort.Stable(byVersion(newer))
+	new := newer[len(newer)-1]
+
+	span.SetAttributes(tracing.String("candidate.path", new.Path), tracing.String("candidate.version", new.Version.String()))
+
+	return new, n, nil
+}
@@ -0,0 +1,432 @@
+package check
+
+import (
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDebounce = 100 * time.Millisecond
+
+// writeCandidate writes a script printing `version` and recording its
+// calls in `<path>.calls`.
+func writeCandidate(t *testing.T, path, version string) {
+	t.Helper()
+
+	script := fmt.Sprintf("#!/bin/sh\necho x >> %s.calls\necho %s\n", path, version)
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func calls(t *testing.T, path string) int {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path + ".calls")
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Count(string(b), "\n")
+}
+
+// waitNewest polls `i` until its newest candidate is `want` or there is
+// none if `want` is empty.
+func waitNewest(t *testing.T, i *Index, want string) {
+	t.Helper()
+
+	var got string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		c, err := i.Newest(context.Background(), "1.0.0")
+		switch {
+		case err == ErrNoCandidate:
+			got = ""
+		case err != nil:
+			t.Fatalf("Newest() error = %v", err)
+		default:
+			got = c.Version.String()
+		}
+
+		if got == want {
+			return
+		}
+	}
+
+	t.Fatalf("Newest() = %q, want %q", got, want)
+}
+
+// waitIncompatibles polls `i` until the paths of its incompatible
+// binaries are `want`.
+func waitIncompatibles(t *testing.T, i *Index, want ...string) {
+	t.Helper()
+
+	var got []string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		got = nil
+		for _, inc := range i.Incompatibles() {
+			got = append(got, inc.Path)
+		}
+
+		if strings.Join(got, " ") == strings.Join(want, " ") {
+			return
+		}
+	}
+
+	t.Fatalf("Incompatibles() = %v, want %v", got, want)
+}
+
+// waitStaged polls the staging directory of `i` until it holds one
+// directory with each prefix of `want`.
+func waitStaged(t *testing.T, i *Index, want ...string) {
+	t.Helper()
+
+	var got []string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		fs, err := ioutil.ReadDir(i.staging)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+
+		got = nil
+		for _, f := range fs {
+			got = append(got, f.Name())
+		}
+
+		if len(got) == len(want) {
+			matched := true
+			for n, prefix := range want {
+				matched = matched && strings.HasPrefix(got[n], prefix)
+			}
+			if matched {
+				return
+			}
+		}
+	}
+
+	t.Fatalf("staged %v, want %v", got, want)
+}
+
+func TestIndex(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("candidates are shell scripts")
+	}
+
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stable := filepath.Join(dir, "stable")
+	writeCandidate(t, stable, "1.1.0")
+
+	i, err := NewIndex(dir, filepath.Join(dir, ".staging"), nil, nil, testDebounce)
+	if err != nil {
+		t.Fatalf("NewIndex() error = %v", err)
+	}
+	defer i.Close()
+
+	waitNewest(t, i, "1.1.0")
+
+	t.Run("added", func(t *testing.T) {
+		writeCandidate(t, filepath.Join(dir, "next"), "1.2.0")
+		waitNewest(t, i, "1.2.0")
+	})
+
+	t.Run("partially written", func(t *testing.T) {
+		path := filepath.Join(dir, "partial")
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		// Writes keep postponing the probe.
+		parts := []string{"#!/bin/sh\n", "echo x >> " + path + ".calls\n", "echo ", "1.3.0\n"}
+		for _, part := range parts {
+			if _, err := f.WriteString(part); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(testDebounce / 3)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		waitNewest(t, i, "1.3.0")
+
+		if got := calls(t, path); got != 1 {
+			t.Errorf("partially written candidate executed %d times, want 1", got)
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		for _, name := range []string{"next", "partial"} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		waitNewest(t, i, "1.1.0")
+	})
+
+	t.Run("probe failure", func(t *testing.T) {
+		path := filepath.Join(dir, "broken")
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		// Counted by the file name, which stays bounded by the contents
+		// of the directory.
+		series := `self_update_candidate_probe_failures_total{candidate="broken",reason="exit"}`
+		for deadline := time.Now().Add(2 * time.Second); metricValue(t, series) == 0; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s not counted", series)
+			}
+		}
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		path := filepath.Join(dir, "release.tar.gz")
+		writeTarGz(t, path, []archiveEntry{
+			{name: "service", body: "#!/bin/sh\necho 1.4.0\n", mode: 0755},
+		})
+
+		waitNewest(t, i, "1.4.0")
+		waitStaged(t, i, "1.4.0-")
+
+		// The staged copy of the previous contents is removed.
+		writeTarGz(t, path, []archiveEntry{
+			{name: "service", body: "#!/bin/sh\necho 1.5.0\n", mode: 0755},
+		})
+		waitNewest(t, i, "1.5.0")
+		waitStaged(t, i, "1.5.0-")
+
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+
+		waitNewest(t, i, "1.1.0")
+		waitStaged(t, i)
+
+		// So are trees of archives removed while the service was down.
+		if err := os.MkdirAll(filepath.Join(i.staging, "1.3.0-stale", "service"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := i.Rescan(); err != nil {
+			t.Fatalf("Rescan() error = %v", err)
+		}
+		waitStaged(t, i)
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		before := calls(t, stable)
+
+		if err := i.Rescan(); err != nil {
+			t.Fatalf("Rescan() error = %v", err)
+		}
+		waitNewest(t, i, "1.1.0")
+
+		if got := calls(t, stable); got != before {
+			t.Errorf("Rescan() executed unchanged candidate %d times", got-before)
+		}
+	})
+
+	t.Run("incompatible", func(t *testing.T) {
+		path := filepath.Join(dir, "sparc")
+		if err := ioutil.WriteFile(path, elfHeader(t, binary.BigEndian, elf.ELFOSABI_HPUX, elf.EM_SPARCV9), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		waitIncompatibles(t, i, path)
+		waitNewest(t, i, "1.1.0")
+
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+
+		waitIncompatibles(t, i)
+	})
+
+	t.Run("platform directory", func(t *testing.T) {
+		sub := filepath.Join(dir, PlatformDir)
+		if err := os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeCandidate(t, filepath.Join(sub, "next"), "1.5.0")
+
+		waitNewest(t, i, "1.5.0")
+
+		// The directory has its own checksums.
+		sums := filepath.Join(sub, SumsName)
+		if err := ioutil.WriteFile(sums, []byte(strings.Repeat("0", 64)+"  next\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		waitNewest(t, i, "1.1.0")
+
+		if err := os.Remove(sums); err != nil {
+			t.Fatal(err)
+		}
+		waitNewest(t, i, "1.5.0")
+
+		if err := os.RemoveAll(sub); err != nil {
+			t.Fatal(err)
+		}
+		waitNewest(t, i, "1.1.0")
+	})
+
+	t.Run("checksums", func(t *testing.T) {
+		sums := filepath.Join(dir, SumsName)
+		hash, err := hashFile(stable)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(sums, []byte(hash+"  stable\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * testDebounce)
+		waitNewest(t, i, "1.1.0")
+
+		other := strings.Repeat("0", 64)
+		if err := ioutil.WriteFile(sums, []byte(other+"  stable\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		waitNewest(t, i, "")
+
+		want := []Mismatch{{Path: stable, Want: other, Got: hash}}
+		if got := i.Mismatches(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Mismatches() = %v, want %v", got, want)
+		}
+
+		if err := os.Remove(sums); err != nil {
+			t.Fatal(err)
+		}
+		waitNewest(t, i, "1.1.0")
+
+		if got := i.Mismatches(); len(got) != 0 {
+			t.Errorf("Mismatches() = %v, want none", got)
+		}
+
+		RequireSums = true
+		defer func() { RequireSums = false }()
+
+		if err := i.Rescan(); err != nil {
+			t.Fatalf("Rescan() error = %v", err)
+		}
+		waitNewest(t, i, "")
+	})
+
+	t.Run("not executable", func(t *testing.T) {
+		if err := os.Chmod(stable, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		waitNewest(t, i, "")
+	})
+}
+
+func TestIndex_dir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("candidates are shell scripts")
+	}
+
+	root, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// The working directory, the parent of the upgrade directory and a
+	// subdirectory other than the platform one have newer candidates;
+	// they must not be run.
+	releases, cwd := filepath.Join(root, "releases"), filepath.Join(root, "cwd")
+	nested := filepath.Join(releases, "nested")
+	for _, dir := range []string{releases, cwd, nested} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCandidate(t, filepath.Join(releases, "app-a"), "1.1.0")
+	writeCandidate(t, filepath.Join(releases, "app-b"), "1.2.0")
+	outside := []string{filepath.Join(cwd, "app-a"), filepath.Join(cwd, "app-b"), filepath.Join(root, "app-b"), filepath.Join(nested, "app-b")}
+	for _, path := range outside {
+		writeCandidate(t, path, "9.0.0")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err := os.Chdir(cwd); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		dir  string
+	}{
+		{name: "absolute", dir: releases},
+		{name: "relative", dir: filepath.Join("..", "releases")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, err := NewIndex(tt.dir, filepath.Join(root, ".staging"), nil, nil, testDebounce)
+			if err != nil {
+				t.Fatalf("NewIndex() error = %v", err)
+			}
+			defer i.Close()
+
+			got, err := i.Newest(context.Background(), "1.0.0")
+			if err != nil {
+				t.Fatalf("Newest() error = %v", err)
+			}
+			if want := filepath.Join(releases, "app-b"); got.Path != want || got.Version.String() != "1.2.0" {
+				t.Errorf("Newest() = %s %s, want %s 1.2.0", got.Path, got.Version, want)
+			}
+		})
+	}
+
+	for _, path := range outside {
+		if n := calls(t, path); n != 0 {
+			t.Errorf("ran %s %d times", path, n)
+		}
+	}
+}
+
+func Test_subjectOf(t *testing.T) {
+	tests := []struct {
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{"/dir/app.tar.gz.bundle", "/dir/app.tar.gz", true},
+		{"/dir/app.sig", "/dir/app", true},
+		{"/dir/app.intoto.jsonl", "/dir/app", true},
+		{"/dir/app", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := subjectOf(tt.path)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("subjectOf() = %s, %v, want %s, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
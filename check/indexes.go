@@ -0,0 +1,91 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Indexes combines the candidates of several directories.
+type Indexes []*Index
+
+// Newest returns the candidate with the newest version greater than
+// `currVersion` of all indexes. Of the same versions, the one of the
+// first index is returned.
+func (is Indexes) Newest(ctx context.Context, currVersion string) (Candidate, error) {
+	start := time.Now()
+	defer func() {
+		scanDuration.ObserveDuration(time.Since(start))
+	}()
+
+	var (
+		newest Candidate
+		total  int
+	)
+	for _, i := range is {
+		c, n, err := i.newest(ctx, currVersion)
+		total += n
+		if errors.Is(err, ErrNoCandidate) {
+			continue
+		}
+		if err != nil {
+			return Candidate{}, err
+		}
+
+		if newest.Version == nil || c.Version.GreaterThan(newest.Version) {
+			newest = c
+		}
+	}
+
+	scanCandidates.Set(float64(total))
+
+	if newest.Version == nil {
+		return Candidate{}, ErrNoCandidate
+	}
+
+	return newest, nil
+}
+
+// Rescan rescans all indexes.
+func (is Indexes) Rescan() error {
+	for _, i := range is {
+		if err := i.Rescan(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Mismatches returns the mismatches of all indexes.
+func (is Indexes) Mismatches() []Mismatch {
+	var ms []Mismatch
+	for _, i := range is {
+		ms = append(ms, i.Mismatches()...)
+	}
+
+	return ms
+}
+
+// Incompatibles returns the binaries of all indexes built for another
+// platform.
+func (is Indexes) Incompatibles() []Incompatible {
+	var incs []Incompatible
+	for _, i := range is {
+		incs = append(incs, i.Incompatibles()...)
+	}
+
+	return incs
+}
+
+// Close stops watching all directories.
+func (is Indexes) Close() error {
+	var err error
+	for _, i := range is {
+		if cerr := i.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
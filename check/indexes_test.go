@@ -0,0 +1,86 @@
+package check
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/xaxes/self-update/metrics"
+)
+
+// metricValue returns the value of the series `name` of the default
+// registry, or 0 if it has none.
+func metricValue(t *testing.T, name string) float64 {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := metrics.Default.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.HasPrefix(line, name+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, name+" "), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+
+	return 0
+}
+
+func TestIndexes_Newest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("candidates are shell scripts")
+	}
+
+	root, err := ioutil.TempDir("", "indexes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	var is Indexes
+	for dir, versions := range map[string][]string{"local": {"1.1.0"}, "downloads": {"1.2.0", "0.9.0"}} {
+		dir = filepath.Join(root, dir)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range versions {
+			writeCandidate(t, filepath.Join(dir, "app-"+v), v)
+		}
+
+		i, err := NewIndex(dir, filepath.Join(dir, ".staging"), nil, nil, testDebounce)
+		if err != nil {
+			t.Fatalf("NewIndex() error = %v", err)
+		}
+		defer i.Close()
+		is = append(is, i)
+	}
+
+	before := metricValue(t, "self_update_candidate_scan_duration_seconds_count")
+
+	c, err := is.Newest(context.Background(), "1.0.0")
+	if err != nil {
+		t.Fatalf("Newest() error = %v", err)
+	}
+	if c.Version.String() != "1.2.0" {
+		t.Errorf("Newest() = %s, want 1.2.0", c.Version)
+	}
+
+	// The gauge counts the candidates of all indexes, and the lookup
+	// is observed once.
+	if got := metricValue(t, "self_update_candidates"); got != 3 {
+		t.Errorf("self_update_candidates = %v, want 3", got)
+	}
+	if got := metricValue(t, "self_update_candidate_scan_duration_seconds_count") - before; got != 1 {
+		t.Errorf("observed %v scan durations, want 1", got)
+	}
+}
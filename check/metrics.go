@@ -0,0 +1,40 @@
+package check
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/xaxes/self-update/metrics"
+)
+
+var (
+	scanDuration = metrics.Default.NewHistogram(
+		"self_update_candidate_scan_duration_seconds",
+		"Time spent looking for the newest upgrade candidate.",
+		nil,
+	)
+	scanCandidates = metrics.Default.NewGauge(
+		"self_update_candidates",
+		"Number of upgrade candidates found by the last scan of all directories.",
+	)
+	probeFailures = metrics.Default.NewCounterVec(
+		"self_update_candidate_probe_failures_total",
+		"Number of failed version probes by candidate file name and reason.",
+		"candidate", "reason",
+	)
+)
+
+// probeFailure returns the reason `err` of versionFromBin is counted
+// under, one of exec, exit and version.
+func probeFailure(err error) string {
+	var exitErr *exec.ExitError
+
+	switch {
+	case errors.Is(err, errVersionOutput):
+		return "version"
+	case errors.As(err, &exitErr):
+		return "exit"
+	default:
+		return "exec"
+	}
+}
@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd
+// +build darwin dragonfly freebsd
+
+package check
+
+import (
+	"syscall"
+)
+
+// mntNoexec is MNT_NOEXEC of statfs(2).
+const mntNoexec = 0x4
+
+// mountedNoexec reports whether `path` is on a filesystem mounted with
+// noexec.
+func mountedNoexec(path string) (bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false, err
+	}
+
+	return uint64(st.Flags)&mntNoexec != 0, nil
+}
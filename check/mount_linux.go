@@ -0,0 +1,19 @@
+package check
+
+import (
+	"syscall"
+)
+
+// stNoexec is ST_NOEXEC of statfs(2).
+const stNoexec = 0x8
+
+// mountedNoexec reports whether `path` is on a filesystem mounted with
+// noexec.
+func mountedNoexec(path string) (bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false, err
+	}
+
+	return st.Flags&stNoexec != 0, nil
+}
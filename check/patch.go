@@ -0,0 +1,122 @@
+package check
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xaxes/self-update/upgrade"
+)
+
+// ErrPatchBase is returned for patches of another binary than the
+// running one; a full candidate has to be provided instead.
+var ErrPatchBase = errors.New("patch does not apply to the running binary")
+
+// IsPatch reports whether `name` is of an archive with a patch. Sources
+// offer them next to the full release under the same name with .patch
+// before the extension, e.g. app-1.2.0.patch.tar.gz.
+func IsPatch(name string) bool {
+	return isArchive(name) && strings.Contains(name, ".patch.")
+}
+
+// CheckPatch returns ErrPatchBase if the archive at `path` has a patch
+// of another binary than the running one. Archives without a patch
+// apply to any.
+func CheckPatch(path string) error {
+	exe, err := upgrade.Executable()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir("", "patch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := extract(path, tmp); err != nil {
+		return fmt.Errorf("extract %s: %w", path, err)
+	}
+
+	_, m, err := archiveContents(tmp)
+	if err != nil || m.Patch == "" {
+		return err
+	}
+
+	hash, err := hashFile(exe)
+	if err != nil {
+		return err
+	}
+	if hash != m.BaseSHA256 {
+		return fmt.Errorf("%w: %s has SHA-256 %s, want %s", ErrPatchBase, exe, hash, m.BaseSHA256)
+	}
+
+	return nil
+}
+
+// applyPatch applies the patch named in `m` to `base` and writes the
+// result to the binary named in `m`, or next to the patch under the
+// name of `base`.
+func applyPatch(root string, m manifest, base string) error {
+	if m.BaseSHA256 == "" || m.SHA256 == "" {
+		return fmt.Errorf("%s: base_sha256 and sha256 are required with patch", manifestName)
+	}
+
+	patchPath, err := safeJoin(root, m.Patch)
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(root, filepath.Base(base))
+	if m.Binary != "" {
+		if out, err = safeJoin(root, m.Binary); err != nil {
+			return err
+		}
+	}
+
+	old, err := ioutil.ReadFile(base)
+	if err != nil {
+		return err
+	}
+
+	if sum := sha256.Sum256(old); hex.EncodeToString(sum[:]) != m.BaseSHA256 {
+		return fmt.Errorf("%w: %s has SHA-256 %x, want %s", ErrPatchBase, base, sum, m.BaseSHA256)
+	}
+
+	patch, err := ioutil.ReadFile(patchPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(f, h))
+	err = bspatch(old, patch, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("apply %s: %w", m.Patch, err)
+	}
+
+	if sum := h.Sum(nil); hex.EncodeToString(sum) != m.SHA256 {
+		os.Remove(out)
+		return fmt.Errorf("patched binary has SHA-256 %x, want %s", sum, m.SHA256)
+	}
+
+	return os.Remove(patchPath)
+}
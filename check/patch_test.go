@@ -0,0 +1,102 @@
+package check
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	baseSHA256    = "f2b6f19d3f9dbb6f006aa03cd0167d796e2367c9a53f4b50f59355a922576c2c"
+	patchedSHA256 = "a756fcd46c2f956a4cf97f81dcf209d328f42a8e3a51b2516861377340c45cef"
+)
+
+func Test_stage_patch(t *testing.T) {
+	patch := string(readTestdata(t, "base-patched.bsdiff"))
+	patched := string(readTestdata(t, "patched"))
+
+	tests := []struct {
+		name    string
+		base    string
+		sha256  string
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name:   "patch",
+			base:   "testdata/base",
+			sha256: patchedSHA256,
+		},
+		{
+			name:    "another base",
+			base:    "testdata/patched",
+			sha256:  patchedSHA256,
+			wantErr: ErrPatchBase,
+		},
+		{
+			name:    "hash mismatch",
+			base:    "testdata/base",
+			sha256:  baseSHA256,
+			wantMsg: "patched binary has SHA-256",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "patch")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "release.tar.gz")
+			writeTarGz(t, path, []archiveEntry{
+				{
+					name: "manifest.json",
+					body: fmt.Sprintf(`{"version": "1.1.0", "patch": "service.bsdiff", "base_sha256": %q, "sha256": %q}`, baseSHA256, tt.sha256),
+					mode: 0644,
+				},
+				{name: "service.bsdiff", body: patch, mode: 0644},
+			})
+
+			hash, err := hashFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := stage(path, hash, filepath.Join(dir, "staging"), tt.base)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("stage() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.wantMsg != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantMsg) {
+					t.Fatalf("stage() error = %v, want %q", err, tt.wantMsg)
+				}
+				return
+			case err != nil:
+				t.Fatalf("stage() error = %v", err)
+			}
+
+			if got.Version.String() != "1.1.0" {
+				t.Errorf("stage() version = %v, want 1.1.0", got.Version)
+			}
+			if filepath.Base(got.Path) != filepath.Base(tt.base) {
+				t.Errorf("stage() path = %v, want the name of the base", got.Path)
+			}
+
+			b, err := ioutil.ReadFile(got.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != patched {
+				t.Errorf("staged binary = %q, want %q", b, patched)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package check
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// PlatformDir is the subdirectory of an upgrade directory with the
+// binaries of the running platform, e.g. linux-amd64.
+var PlatformDir = runtime.GOOS + "-" + runtime.GOARCH
+
+// errUnknownFormat is returned for files other than ELF, PE and Mach-O
+// executables, such as scripts.
+var errUnknownFormat = errors.New("unknown executable format")
+
+// Platform is the OS and the architecture a binary is built for, named
+// like GOOS and GOARCH. Ones Go does not support are named after the
+// header values, e.g. EM_SPARC.
+type Platform struct {
+	OS   string
+	Arch string
+}
+
+func (p Platform) String() string {
+	return p.OS + "/" + p.Arch
+}
+
+// Incompatible is a candidate built for another platform than the
+// running one.
+type Incompatible struct {
+	Path      string
+	Platforms []Platform // Universal binaries have several
+}
+
+func (e *Incompatible) Error() string {
+	ps := make([]string, len(e.Platforms))
+	for i, p := range e.Platforms {
+		ps[i] = p.String()
+	}
+
+	return fmt.Sprintf("incompatible platform: %s is built for %s, not %s/%s", e.Path, strings.Join(ps, ", "), runtime.GOOS, runtime.GOARCH)
+}
+
+// checkPlatform returns an *Incompatible error if the executable at
+// `path` is built for another platform. Files of unknown formats are
+// assumed to run anywhere.
+func checkPlatform(path string) error {
+	ps, err := platformsOf(path)
+	if errors.Is(err, errUnknownFormat) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read platform of %s: %w", path, err)
+	}
+
+	for _, p := range ps {
+		// Emulation, such as of amd64 binaries on arm64 macOS, is not
+		// taken into account.
+		if p.OS == runtime.GOOS && p.Arch == runtime.GOARCH {
+			return nil
+		}
+	}
+
+	return &Incompatible{Path: path, Platforms: ps}
+}
+
+// platformsOf returns the platforms of the executable at `path` from its
+// headers.
+func platformsOf(path string) ([]Platform, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return nil, errUnknownFormat
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte(elf.ELFMAG)):
+		return elfPlatform(f)
+	case bytes.HasPrefix(magic, []byte("MZ")):
+		return pePlatform(f)
+	}
+
+	switch be, le := binary.BigEndian.Uint32(magic), binary.LittleEndian.Uint32(magic); {
+	case be == macho.MagicFat:
+		return machoFatPlatforms(f)
+	case be == macho.Magic32 || be == macho.Magic64 || le == macho.Magic32 || le == macho.Magic64:
+		return machoPlatform(f)
+	}
+
+	return nil, errUnknownFormat
+}
+
+// emLoongArch is EM_LOONGARCH, unknown to debug/elf of older Go.
+const emLoongArch elf.Machine = 258
+
+func elfPlatform(r io.ReaderAt) ([]Platform, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Platform
+
+	switch f.OSABI {
+	case elf.ELFOSABI_FREEBSD:
+		p.OS = "freebsd"
+	case elf.ELFOSABI_NETBSD:
+		p.OS = "netbsd"
+	case elf.ELFOSABI_OPENBSD:
+		p.OS = "openbsd"
+	case elf.ELFOSABI_SOLARIS:
+		p.OS = "solaris"
+	case elf.ELFOSABI_LINUX:
+		p.OS = "linux"
+	case elf.ELFOSABI_NONE:
+		// Go sets no ABI but for FreeBSD; the BSDs have notes.
+		p.OS = "linux"
+		for _, s := range f.Sections {
+			switch s.Name {
+			case ".note.netbsd.ident":
+				p.OS = "netbsd"
+			case ".note.openbsd.ident":
+				p.OS = "openbsd"
+			case ".note.android.ident":
+				p.OS = "android"
+			}
+		}
+	default:
+		p.OS = f.OSABI.String()
+	}
+
+	is64 := f.Class == elf.ELFCLASS64
+	le := f.Data == elf.ELFDATA2LSB
+
+	switch f.Machine {
+	case elf.EM_X86_64:
+		p.Arch = "amd64"
+	case elf.EM_386:
+		p.Arch = "386"
+	case elf.EM_AARCH64:
+		p.Arch = "arm64"
+	case elf.EM_ARM:
+		p.Arch = "arm"
+	case elf.EM_PPC64:
+		p.Arch = "ppc64"
+		if le {
+			p.Arch = "ppc64le"
+		}
+	case elf.EM_S390:
+		p.Arch = "s390x"
+	case elf.EM_RISCV:
+		if is64 {
+			p.Arch = "riscv64"
+		}
+	case elf.EM_MIPS:
+		p.Arch = "mips"
+		if is64 {
+			p.Arch = "mips64"
+		}
+		if le {
+			p.Arch += "le"
+		}
+	case emLoongArch:
+		p.Arch = "loong64"
+	}
+	if p.Arch == "" {
+		p.Arch = f.Machine.String()
+	}
+
+	return []Platform{p}, nil
+}
+
+func pePlatform(r io.ReaderAt) ([]Platform, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := Platform{OS: "windows"}
+
+	switch f.Machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		p.Arch = "amd64"
+	case pe.IMAGE_FILE_MACHINE_I386:
+		p.Arch = "386"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		p.Arch = "arm64"
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		p.Arch = "arm"
+	default:
+		p.Arch = fmt.Sprintf("IMAGE_FILE_MACHINE_%#x", f.Machine)
+	}
+
+	return []Platform{p}, nil
+}
+
+func machoPlatform(r io.ReaderAt) ([]Platform, error) {
+	f, err := macho.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Platform{{OS: "darwin", Arch: machoArch(f.Cpu)}}, nil
+}
+
+// machoFatPlatforms returns the platforms of a universal binary.
+func machoFatPlatforms(r io.ReaderAt) ([]Platform, error) {
+	f, err := macho.NewFatFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var ps []Platform
+	for _, a := range f.Arches {
+		ps = append(ps, Platform{OS: "darwin", Arch: machoArch(a.Cpu)})
+	}
+
+	return ps, nil
+}
+
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuAmd64:
+		return "amd64"
+	case macho.Cpu386:
+		return "386"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.CpuArm:
+		return "arm"
+	}
+
+	return cpu.String()
+}
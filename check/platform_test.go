@@ -0,0 +1,200 @@
+package check
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// elfHeader returns the header of a 64-bit ELF executable.
+func elfHeader(t *testing.T, order binary.ByteOrder, osabi elf.OSABI, machine elf.Machine) []byte {
+	h := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Ehsize:    64,
+		Phentsize: 56,
+		Shentsize: 64,
+	}
+	copy(h.Ident[:], elf.ELFMAG)
+	h.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	h.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	if order == binary.BigEndian {
+		h.Ident[elf.EI_DATA] = byte(elf.ELFDATA2MSB)
+	}
+	h.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	h.Ident[elf.EI_OSABI] = byte(osabi)
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, order, h); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+// peHeader returns the headers of a PE executable without sections.
+func peHeader(t *testing.T, machine uint16) []byte {
+	// The DOS header with its stub.
+	b := make([]byte, 0x80)
+	copy(b, "MZ")
+	binary.LittleEndian.PutUint32(b[0x3c:], 0x80)
+
+	buf := bytes.NewBuffer(b)
+	buf.WriteString("PE\x00\x00")
+	if err := binary.Write(buf, binary.LittleEndian, pe.FileHeader{Machine: machine}); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// machoHeader returns the header of a 64-bit Mach-O executable.
+func machoHeader(t *testing.T, cpu macho.Cpu) []byte {
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, macho.FileHeader{Magic: macho.Magic64, Cpu: cpu, Type: macho.TypeExec}); err != nil {
+		t.Fatal(err)
+	}
+	b.Write(make([]byte, 4)) // reserved
+
+	return b.Bytes()
+}
+
+// fatHeader returns a universal binary of Mach-O executables.
+func fatHeader(t *testing.T, cpus ...macho.Cpu) []byte {
+	const align = 64
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, []uint32{macho.MagicFat, uint32(len(cpus))})
+	for i, cpu := range cpus {
+		binary.Write(&b, binary.BigEndian, macho.FatArchHeader{
+			Cpu:    cpu,
+			Offset: uint32(align * (i + 1)),
+			Size:   32,
+		})
+	}
+	for _, cpu := range cpus {
+		b.Write(make([]byte, align-b.Len()%align))
+		b.Write(machoHeader(t, cpu))
+	}
+
+	return b.Bytes()
+}
+
+func Test_platformsOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    []Platform
+		wantErr error
+	}{
+		{
+			name:    "linux arm64",
+			content: elfHeader(t, binary.LittleEndian, elf.ELFOSABI_NONE, elf.EM_AARCH64),
+			want:    []Platform{{"linux", "arm64"}},
+		},
+		{
+			name:    "freebsd amd64",
+			content: elfHeader(t, binary.LittleEndian, elf.ELFOSABI_FREEBSD, elf.EM_X86_64),
+			want:    []Platform{{"freebsd", "amd64"}},
+		},
+		{
+			name:    "linux ppc64",
+			content: elfHeader(t, binary.BigEndian, elf.ELFOSABI_LINUX, elf.EM_PPC64),
+			want:    []Platform{{"linux", "ppc64"}},
+		},
+		{
+			name:    "unsupported",
+			content: elfHeader(t, binary.BigEndian, elf.ELFOSABI_HPUX, elf.EM_SPARCV9),
+			want:    []Platform{{"ELFOSABI_HPUX", "EM_SPARCV9"}},
+		},
+		{
+			name:    "windows amd64",
+			content: peHeader(t, pe.IMAGE_FILE_MACHINE_AMD64),
+			want:    []Platform{{"windows", "amd64"}},
+		},
+		{
+			name:    "windows arm64",
+			content: peHeader(t, pe.IMAGE_FILE_MACHINE_ARM64),
+			want:    []Platform{{"windows", "arm64"}},
+		},
+		{
+			name:    "darwin arm64",
+			content: machoHeader(t, macho.CpuArm64),
+			want:    []Platform{{"darwin", "arm64"}},
+		},
+		{
+			name:    "darwin universal",
+			content: fatHeader(t, macho.CpuAmd64, macho.CpuArm64),
+			want:    []Platform{{"darwin", "amd64"}, {"darwin", "arm64"}},
+		},
+		{
+			name:    "script",
+			content: []byte("#!/bin/sh\necho 1.0.0\n"),
+			wantErr: errUnknownFormat,
+		},
+		{
+			name:    "empty",
+			wantErr: errUnknownFormat,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "platform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "bin")
+			if err := ioutil.WriteFile(path, tt.content, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := platformsOf(path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("platformsOf() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("platformsOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkPlatform(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkPlatform(exe); err != nil {
+		t.Errorf("checkPlatform() of the test binary error = %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "platform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bin")
+	if err := ioutil.WriteFile(path, elfHeader(t, binary.BigEndian, elf.ELFOSABI_HPUX, elf.EM_SPARCV9), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var inc *Incompatible
+	err = checkPlatform(path)
+	if !errors.As(err, &inc) || inc.Path != path || !reflect.DeepEqual(inc.Platforms, []Platform{{"ELFOSABI_HPUX", "EM_SPARCV9"}}) {
+		t.Errorf("checkPlatform() error = %v, want an incompatible ELFOSABI_HPUX/EM_SPARCV9", err)
+	}
+}
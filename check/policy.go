@@ -0,0 +1,186 @@
+package check
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+var errPolicy = errors.New("violates the provenance policy")
+
+// Policy restricts candidates to the ones built from the expected source
+// by the expected builder, according to their SLSA provenance and the Go
+// build info of their binary.
+//
+// Empty fields are not checked.
+type Policy struct {
+	// SourceRepository is the repository the candidate has to be built
+	// from, e.g. https://github.com/xaxes/self-update.
+	SourceRepository string `json:"source_repository"`
+	// Refs are patterns of the refs it has to be built from, see
+	// path.Match, e.g. refs/tags/v*.
+	Refs []string `json:"refs"`
+	// BuilderIDs are patterns of the builders it has to be built by.
+	BuilderIDs []string `json:"builder_ids"`
+	// MinGoVersion is the oldest Go release it may be built with, e.g. 1.21.
+	MinGoVersion string `json:"min_go_version"`
+	// ForbidReplace rejects binaries built with replace directives.
+	ForbidReplace bool `json:"forbid_replace"`
+
+	minGo    *semver.Version
+	verifier *Verifier
+}
+
+// LoadPolicy reads the policy from the JSON file at `path`. The
+// provenance has to be signed by a certificate `v` accepts; policies
+// checking it need one.
+func LoadPolicy(path string, v *Verifier) (*Policy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if err := p.init(); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if p.needsProvenance() && v == nil {
+		return nil, fmt.Errorf("%s: the provenance is checked, but there is no verifier of its signature", path)
+	}
+	p.verifier = v
+
+	return &p, nil
+}
+
+func (p *Policy) init() error {
+	for _, pattern := range append(append([]string{}, p.Refs...), p.BuilderIDs...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+
+	if p.MinGoVersion != "" {
+		v, err := parseGoVersion(p.MinGoVersion)
+		if err != nil {
+			return fmt.Errorf("min_go_version: %w", err)
+		}
+		p.minGo = v
+	}
+
+	return nil
+}
+
+// needsProvenance reports whether the policy checks the provenance.
+func (p *Policy) needsProvenance() bool {
+	return p.SourceRepository != "" || len(p.Refs) > 0 || len(p.BuilderIDs) > 0
+}
+
+// Check checks the candidate file at `path` with the SHA-256 `hash`,
+// whose binary is `bin`; `bin` is `path` unless it is an archive.
+func (p *Policy) Check(path, hash, bin string) error {
+	var prov *provenance
+	if p.needsProvenance() {
+		var err error
+		if prov, err = readProvenance(path, hash, p.verifier); err != nil {
+			return fmt.Errorf("%w: %v", errPolicy, err)
+		}
+
+		if err := p.checkProvenance(prov); err != nil {
+			return fmt.Errorf("%w: %v", errPolicy, err)
+		}
+	}
+
+	if p.minGo == nil && !p.ForbidReplace && prov == nil {
+		return nil
+	}
+
+	info, err := readBuildInfo(bin)
+	if err != nil {
+		// The build info only confirms the commit of the provenance.
+		if p.minGo == nil && !p.ForbidReplace {
+			return nil
+		}
+		return fmt.Errorf("%w: %v", errPolicy, err)
+	}
+
+	if err := p.checkBuildInfo(info, prov); err != nil {
+		return fmt.Errorf("%w: %v", errPolicy, err)
+	}
+
+	return nil
+}
+
+func (p *Policy) checkProvenance(prov *provenance) error {
+	if p.SourceRepository != "" && prov.Repository != normalizeRepo(p.SourceRepository) {
+		return fmt.Errorf("built from %q, want %s", prov.Repository, p.SourceRepository)
+	}
+
+	if len(p.Refs) > 0 && !matchAny(p.Refs, prov.Ref) {
+		return fmt.Errorf("built from ref %q, want %v", prov.Ref, p.Refs)
+	}
+
+	if len(p.BuilderIDs) > 0 && !matchAny(p.BuilderIDs, prov.BuilderID) {
+		return fmt.Errorf("built by %q, want %v", prov.BuilderID, p.BuilderIDs)
+	}
+
+	return nil
+}
+
+func (p *Policy) checkBuildInfo(info *buildInfo, prov *provenance) error {
+	if rev := info.Settings["vcs.revision"]; prov != nil && prov.Commit != "" && rev != "" && rev != prov.Commit {
+		return fmt.Errorf("binary built from commit %s, provenance lists %s", rev, prov.Commit)
+	}
+
+	if p.minGo != nil {
+		v, err := parseGoVersion(info.GoVersion)
+		if err != nil {
+			return fmt.Errorf("Go version: %w", err)
+		}
+
+		if v.LessThan(p.minGo) {
+			return fmt.Errorf("built with %s, want %s or newer", info.GoVersion, p.MinGoVersion)
+		}
+	}
+
+	if rs := info.replaced(); p.ForbidReplace && len(rs) > 0 {
+		return fmt.Errorf("built with replace directives %v", rs)
+	}
+
+	return nil
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseGoVersion parses Go versions such as `go1.21.3`, `1.21` or
+// `go1.22rc1 X:boringcrypto`.
+func parseGoVersion(s string) (*semver.Version, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, errors.New("empty Go version")
+	}
+
+	v := strings.TrimPrefix(fields[0], "go")
+	if i := strings.IndexFunc(v, func(r rune) bool { return (r < '0' || r > '9') && r != '.' }); i > 0 {
+		v = v[:i] + "-" + v[i:]
+	}
+
+	return semver.NewVersion(v)
+}
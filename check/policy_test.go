@@ -0,0 +1,158 @@
+package check
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+
+	p := newTestPKI(t)
+	v := p.verifier(false)
+	signed := func(statement string) string {
+		return jsonLine(t, p.signedEnvelope(statement, testIdentity))
+	}
+
+	// The test binary is built from this module without replacements.
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		policy      Policy
+		attestation string
+		wantErr     bool
+	}{
+		{
+			name:   "empty",
+			policy: Policy{},
+		},
+		{
+			name: "complies",
+			policy: Policy{
+				SourceRepository: "https://github.com/xaxes/self-update.git",
+				Refs:             []string{"refs/heads/main", "refs/tags/v*"},
+				BuilderIDs:       []string{"https://github.com/slsa-framework/slsa-github-generator/.github/workflows/builder_go_slsa3.yml@refs/tags/v*"},
+				MinGoVersion:     "1.18",
+				ForbidReplace:    true,
+				verifier:         v,
+			},
+			attestation: signed(provenanceV02(hash, "refs/tags/v1.2.0")),
+		},
+		{
+			name:    "no provenance",
+			policy:  Policy{SourceRepository: "https://github.com/xaxes/self-update", verifier: v},
+			wantErr: true,
+		},
+		{
+			name:        "unsigned provenance",
+			policy:      Policy{SourceRepository: "https://github.com/xaxes/self-update", verifier: v},
+			attestation: unsignedEnvelope(t, provenanceV02(hash, "refs/tags/v1.2.0")),
+			wantErr:     true,
+		},
+		{
+			name:        "another repository",
+			policy:      Policy{SourceRepository: "https://github.com/xaxes/fork", verifier: v},
+			attestation: signed(provenanceV02(hash, "refs/heads/main")),
+			wantErr:     true,
+		},
+		{
+			name:        "another branch",
+			policy:      Policy{Refs: []string{"refs/heads/main"}, verifier: v},
+			attestation: signed(provenanceV02(hash, "refs/heads/feature")),
+			wantErr:     true,
+		},
+		{
+			name:        "another builder",
+			policy:      Policy{BuilderIDs: []string{testBuilderV02}, verifier: v},
+			attestation: signed(provenanceV1(hash, "refs/heads/main")),
+			wantErr:     true,
+		},
+		{
+			name:    "old Go",
+			policy:  Policy{MinGoVersion: "99.0"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "policy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "self-update-1.2.0")
+			if tt.attestation != "" {
+				if err := ioutil.WriteFile(path+provenanceExt, []byte(tt.attestation+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := tt.policy.init(); err != nil {
+				t.Fatalf("init() error = %v", err)
+			}
+
+			if err := tt.policy.Check(path, hash, exe); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.json")
+	if err := ioutil.WriteFile(path, []byte(`{"source_repository": "https://github.com/xaxes/self-update"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadPolicy(path, nil); err == nil {
+		t.Error("LoadPolicy() without a verifier error = nil, want an error")
+	}
+
+	p, err := LoadPolicy(path, newTestPKI(t).verifier(false))
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+	if p.SourceRepository != "https://github.com/xaxes/self-update" {
+		t.Errorf("LoadPolicy() = %+v", p)
+	}
+}
+
+func Test_parseGoVersion(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"go1.21.3", "1.21.3"},
+		{"1.21", "1.21.0"},
+		{"go1.22rc1 X:boringcrypto", "1.22.0-rc1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseGoVersion(tt.s)
+			if err != nil {
+				t.Fatalf("parseGoVersion() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("parseGoVersion() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseGoVersion("devel go1.23-abcdef"); err == nil {
+		t.Error("parseGoVersion() of a development version error = nil, want an error")
+	}
+}
@@ -0,0 +1,302 @@
+package check
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// provenanceExt is the extension of the in-toto attestations of a
+// candidate, one DSSE envelope or Sigstore bundle of one per line, e.g.
+// `self-update-1.2.0.intoto.jsonl`.
+const provenanceExt = ".intoto.jsonl"
+
+// payloadTypeInToto is the DSSE payload type of in-toto statements.
+const payloadTypeInToto = "application/vnd.in-toto+json"
+
+// Predicate types of SLSA provenance.
+const (
+	slsaProvenanceV02 = "https://slsa.dev/provenance/v0.2"
+	slsaProvenanceV1  = "https://slsa.dev/provenance/v1"
+)
+
+var errNoProvenance = errors.New("no provenance")
+
+// provenance is where and how a candidate was built.
+type provenance struct {
+	BuilderID  string
+	Repository string // e.g. https://github.com/xaxes/self-update
+	Ref        string // e.g. refs/heads/main
+	Commit     string
+}
+
+// statement is an in-toto statement.
+type statement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// envelope is a DSSE envelope.
+type envelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     []byte          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+// dsseSignature is a signature of an envelope, which may come with the
+// PEM certificate of the signer.
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+	Cert  string `json:"cert,omitempty"`
+}
+
+// pae returns the pre-authentication encoding of `env`, which is what
+// its signatures sign.
+func (env *envelope) pae() []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(env.PayloadType), env.PayloadType, len(env.Payload), env.Payload))
+}
+
+// readProvenance reads the SLSA provenance of the file at `path` with
+// the SHA-256 `hash` from `<path>.intoto.jsonl`.
+//
+// The statement has to be signed by a certificate `v` accepts, like the
+// candidate itself; unsigned statements are rejected.
+func readProvenance(path, hash string, v *Verifier) (*provenance, error) {
+	b, err := ioutil.ReadFile(path + provenanceExt)
+	if os.IsNotExist(err) {
+		return nil, errNoProvenance
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(nil, len(b)+1)
+	for n := 1; s.Scan(); n++ {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		st, b, err := parseAttestation(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path+provenanceExt, n, err)
+		}
+
+		if !st.about(hash) || (st.PredicateType != slsaProvenanceV02 && st.PredicateType != slsaProvenanceV1) {
+			continue
+		}
+
+		if err := v.verifyAttestation(b); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path+provenanceExt, n, err)
+		}
+
+		if st.PredicateType == slsaProvenanceV02 {
+			return parseProvenanceV02(st.Predicate)
+		}
+		return parseProvenanceV1(st.Predicate)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, errNoProvenance
+}
+
+// parseAttestation parses an in-toto statement, a DSSE envelope of one or
+// a Sigstore bundle of the envelope, and returns the statement and its
+// signature. The signature is nil for statements and for envelopes
+// without a certificate to verify it.
+func parseAttestation(b []byte) (*statement, *sigBundle, error) {
+	var probe struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		bu  *sigBundle
+		env envelope
+	)
+	if strings.HasPrefix(probe.MediaType, "application/vnd.dev.sigstore.bundle") {
+		var err error
+		if bu, err = parseSigstoreBundle(b); err != nil {
+			return nil, nil, err
+		}
+		if bu.envelope == nil {
+			return nil, nil, errors.New("bundle of a message signature")
+		}
+		env = *bu.envelope
+	} else if err := json.Unmarshal(b, &env); err != nil {
+		return nil, nil, err
+	}
+
+	switch env.PayloadType {
+	case payloadTypeInToto:
+	case "":
+		env.Payload = b
+	default:
+		return nil, nil, fmt.Errorf("unsupported payload type %q", env.PayloadType)
+	}
+
+	if bu == nil && len(env.Signatures) > 0 && env.Signatures[0].Cert != "" {
+		certs, err := parseCerts([]byte(env.Signatures[0].Cert))
+		if err != nil {
+			return nil, nil, fmt.Errorf("cert: %w", err)
+		}
+		bu = &sigBundle{sig: env.Signatures[0].Sig, certs: certs, envelope: &env}
+	}
+
+	var st statement
+	if err := json.Unmarshal(env.Payload, &st); err != nil {
+		return nil, nil, err
+	}
+
+	if !strings.HasPrefix(st.Type, "https://in-toto.io/Statement/") {
+		return nil, nil, fmt.Errorf("unsupported statement type %q", st.Type)
+	}
+
+	return &st, bu, nil
+}
+
+// verifyAttestation checks the signature of the DSSE envelope in `b`,
+// which is nil for unsigned ones, over its pre-authentication encoding.
+func (v *Verifier) verifyAttestation(b *sigBundle) error {
+	if b == nil || v == nil {
+		return errUnsigned
+	}
+
+	if err := v.verify(b, sha256Sum(b.envelope.pae())); err != nil {
+		return fmt.Errorf("cosign: %w", err)
+	}
+
+	return nil
+}
+
+// about reports whether `s` is about the file with the SHA-256 `hash`.
+func (s *statement) about(hash string) bool {
+	for _, sub := range s.Subject {
+		if strings.EqualFold(sub.Digest["sha256"], hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseProvenanceV02(b []byte) (*provenance, error) {
+	var p struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Invocation struct {
+			ConfigSource struct {
+				URI    string            `json:"uri"`
+				Digest map[string]string `json:"digest"`
+			} `json:"configSource"`
+		} `json:"invocation"`
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+
+	cs := p.Invocation.ConfigSource
+	repo, ref := splitGitURI(cs.URI)
+
+	return &provenance{
+		BuilderID:  p.Builder.ID,
+		Repository: repo,
+		Ref:        ref,
+		Commit:     cs.Digest["sha1"],
+	}, nil
+}
+
+func parseProvenanceV1(b []byte) (*provenance, error) {
+	var p struct {
+		BuildDefinition struct {
+			ExternalParameters struct {
+				Workflow struct {
+					Repository string `json:"repository"`
+					Ref        string `json:"ref"`
+				} `json:"workflow"`
+			} `json:"externalParameters"`
+			ResolvedDependencies []struct {
+				URI    string            `json:"uri"`
+				Digest map[string]string `json:"digest"`
+			} `json:"resolvedDependencies"`
+		} `json:"buildDefinition"`
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+		} `json:"runDetails"`
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+
+	w := p.BuildDefinition.ExternalParameters.Workflow
+	prov := &provenance{
+		BuilderID:  p.RunDetails.Builder.ID,
+		Repository: normalizeRepo(w.Repository),
+		Ref:        w.Ref,
+	}
+
+	// The source is the first git dependency.
+	for _, d := range p.BuildDefinition.ResolvedDependencies {
+		if !strings.HasPrefix(d.URI, "git+") {
+			continue
+		}
+
+		repo, ref := splitGitURI(d.URI)
+		if prov.Repository == "" {
+			prov.Repository = repo
+		}
+		if prov.Ref == "" {
+			prov.Ref = ref
+		}
+		if repo == prov.Repository {
+			prov.Commit = d.Digest["gitCommit"]
+			if prov.Commit == "" {
+				prov.Commit = d.Digest["sha1"]
+			}
+		}
+		break
+	}
+
+	return prov, nil
+}
+
+// splitGitURI splits `git+https://github.com/org/repo@refs/heads/main`
+// into the repository and the ref.
+func splitGitURI(uri string) (string, string) {
+	// The ref is a full ref or a commit; `@` may also precede the host.
+	i := strings.LastIndex(uri, "@")
+	if i < 0 {
+		return normalizeRepo(uri), ""
+	}
+
+	ref := uri[i+1:]
+	if !strings.HasPrefix(ref, "refs/") && strings.Contains(ref, "/") {
+		return normalizeRepo(uri), ""
+	}
+
+	return normalizeRepo(uri[:i]), ref
+}
+
+// normalizeRepo strips the `git+` scheme prefix and the `.git` suffix.
+func normalizeRepo(repo string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(repo, "git+"), ".git")
+}
@@ -0,0 +1,265 @@
+package check
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testBuilderV02 = "https://github.com/slsa-framework/slsa-github-generator/.github/workflows/builder_go_slsa3.yml@refs/tags/v1.9.0"
+	testBuilderV1  = "https://github.com/slsa-framework/slsa-github-generator/.github/workflows/generator_generic_slsa3.yml@refs/tags/v2.0.0"
+	testCommit     = "9f1c0e0b8d4e5b3c2a1f0e9d8c7b6a5f4e3d2c1b"
+)
+
+// provenanceV02 returns a SLSA v0.2 statement about `hash`.
+func provenanceV02(hash, ref string) string {
+	return `{"_type": "https://in-toto.io/Statement/v0.1",
+		"subject": [{"name": "self-update-1.2.0", "digest": {"sha256": "` + hash + `"}}],
+		"predicateType": "https://slsa.dev/provenance/v0.2",
+		"predicate": {
+			"builder": {"id": "` + testBuilderV02 + `"},
+			"invocation": {"configSource": {
+				"uri": "git+https://github.com/xaxes/self-update@` + ref + `",
+				"digest": {"sha1": "` + testCommit + `"},
+				"entryPoint": ".github/workflows/release.yml"
+			}}
+		}}`
+}
+
+// provenanceV1 returns a SLSA v1 statement about `hash`.
+func provenanceV1(hash, ref string) string {
+	return `{"_type": "https://in-toto.io/Statement/v1",
+		"subject": [{"name": "self-update-1.2.0", "digest": {"sha256": "` + hash + `"}}],
+		"predicateType": "https://slsa.dev/provenance/v1",
+		"predicate": {
+			"buildDefinition": {
+				"externalParameters": {"workflow": {"repository": "https://github.com/xaxes/self-update", "ref": "` + ref + `", "path": ".github/workflows/release.yml"}},
+				"resolvedDependencies": [{"uri": "git+https://github.com/xaxes/self-update@` + ref + `", "digest": {"gitCommit": "` + testCommit + `"}}]
+			},
+			"runDetails": {"builder": {"id": "` + testBuilderV1 + `"}}
+		}}`
+}
+
+// unsignedEnvelope wraps `statement` in a DSSE envelope on a single line,
+// with a signature but no certificate to verify it.
+func unsignedEnvelope(t *testing.T, statement string) string {
+	t.Helper()
+
+	return jsonLine(t, envelope{
+		PayloadType: payloadTypeInToto,
+		Payload:     []byte(statement),
+		Signatures:  []dsseSignature{{Sig: []byte("sig")}},
+	})
+}
+
+func jsonLine(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+// signEnvelope wraps `statement` in a DSSE envelope signed with a
+// certificate issued to `identity` from `notBefore`, and returns the
+// certificate and the signature too.
+func (p *testPKI) signEnvelope(statement, identity string, notBefore time.Time) (*envelope, *x509.Certificate, []byte) {
+	p.t.Helper()
+
+	key := newKey(p.t)
+	cert := p.leaf(key, identity, notBefore)
+
+	env := &envelope{PayloadType: payloadTypeInToto, Payload: []byte(statement)}
+	sig := sign(p.t, key, sha256Sum(env.pae()))
+	env.Signatures = []dsseSignature{{Sig: sig}}
+
+	return env, cert, sig
+}
+
+// signedEnvelope returns `statement` in a DSSE envelope with the
+// certificate of its signature, which is valid now.
+func (p *testPKI) signedEnvelope(statement, identity string) *envelope {
+	p.t.Helper()
+
+	env, cert, _ := p.signEnvelope(statement, identity, time.Now().Add(-time.Minute))
+	env.Signatures[0].Cert = pemCert(cert)
+
+	return env
+}
+
+// dsseBundle returns `statement` in a DSSE envelope in a Sigstore bundle
+// with the log entry of its signature, logged at `integrated`.
+func (p *testPKI) dsseBundle(statement string, integrated time.Time) string {
+	p.t.Helper()
+
+	env, cert, sig := p.signEnvelope(statement, testIdentity, integrated.Add(-time.Minute))
+
+	body, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "dsse",
+		"spec": map[string]interface{}{
+			"payloadHash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(sha256Sum(env.Payload))},
+			"signatures":  []interface{}{map[string][]byte{"signature": sig, "verifier": []byte(pemCert(cert))}},
+		},
+	})
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	s := signedBlob{body: body, integratedTime: integrated.Unix(), logIndex: 42, logID: p.logID()}
+	s.set = p.signEntry(s)
+
+	logID, err := hex.DecodeString(s.logID)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	return jsonLine(p.t, map[string]interface{}{
+		"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json",
+		"verificationMaterial": map[string]interface{}{
+			"certificate": map[string]interface{}{"rawBytes": cert.Raw},
+			"tlogEntries": []interface{}{map[string]interface{}{
+				"logIndex":          strconv.FormatInt(s.logIndex, 10),
+				"logId":             map[string]interface{}{"keyId": logID},
+				"kindVersion":       map[string]string{"kind": "dsse", "version": "0.0.1"},
+				"integratedTime":    strconv.FormatInt(s.integratedTime, 10),
+				"inclusionPromise":  map[string]interface{}{"signedEntryTimestamp": s.set},
+				"canonicalizedBody": s.body,
+			}},
+		},
+		"dsseEnvelope": env,
+	})
+}
+
+func Test_readProvenance(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	other := strings.Repeat("cd", 32)
+
+	p := newTestPKI(t)
+	v := p.verifier(false)
+
+	tampered := p.signedEnvelope(provenanceV02(hash, "refs/heads/main"), testIdentity)
+	tampered.Payload = []byte(provenanceV02(hash, "refs/tags/v1.2.0"))
+
+	tests := []struct {
+		name     string
+		lines    []string
+		verifier *Verifier
+		want     *provenance
+		wantErr  error
+	}{
+		{
+			name: "v0.2 envelope",
+			lines: []string{
+				unsignedEnvelope(t, provenanceV02(other, "refs/heads/main")),
+				jsonLine(t, p.signedEnvelope(provenanceV02(hash, "refs/tags/v1.2.0"), testIdentity)),
+			},
+			verifier: v,
+			want:     &provenance{BuilderID: testBuilderV02, Repository: "https://github.com/xaxes/self-update", Ref: "refs/tags/v1.2.0", Commit: testCommit},
+		},
+		{
+			name:     "v1 Sigstore bundle",
+			lines:    []string{p.dsseBundle(provenanceV1(hash, "refs/heads/main"), time.Now().Add(-time.Hour))},
+			verifier: p.verifier(true),
+			want:     &provenance{BuilderID: testBuilderV1, Repository: "https://github.com/xaxes/self-update", Ref: "refs/heads/main", Commit: testCommit},
+		},
+		{
+			name:     "statement",
+			lines:    []string{strings.Join(strings.Fields(provenanceV1(hash, "refs/heads/main")), " ")},
+			verifier: v,
+			wantErr:  errUnsigned,
+		},
+		{
+			name:     "unsigned envelope",
+			lines:    []string{unsignedEnvelope(t, provenanceV02(hash, "refs/heads/main"))},
+			verifier: v,
+			wantErr:  errUnsigned,
+		},
+		{
+			name:    "no verifier",
+			lines:   []string{jsonLine(t, p.signedEnvelope(provenanceV02(hash, "refs/heads/main"), testIdentity))},
+			wantErr: errUnsigned,
+		},
+		{
+			name:     "tampered",
+			lines:    []string{jsonLine(t, tampered)},
+			verifier: v,
+			wantErr:  errSignature,
+		},
+		{
+			name:     "another identity",
+			lines:    []string{jsonLine(t, p.signedEnvelope(provenanceV02(hash, "refs/heads/main"), "https://github.com/xaxes/fork/.github/workflows/release.yml@refs/heads/main"))},
+			verifier: v,
+			wantErr:  errIdentity,
+		},
+		{
+			name:     "another subject",
+			lines:    []string{jsonLine(t, p.signedEnvelope(provenanceV02(other, "refs/heads/main"), testIdentity))},
+			verifier: v,
+			wantErr:  errNoProvenance,
+		},
+		{
+			name:     "missing",
+			verifier: v,
+			wantErr:  errNoProvenance,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "provenance")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "self-update-1.2.0")
+			if tt.lines != nil {
+				if err := ioutil.WriteFile(path+provenanceExt, []byte(strings.Join(tt.lines, "\n")+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := readProvenance(path, hash, tt.verifier)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readProvenance() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readProvenance() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitGitURI(t *testing.T) {
+	tests := []struct {
+		uri      string
+		wantRepo string
+		wantRef  string
+	}{
+		{"git+https://github.com/xaxes/self-update@refs/heads/main", "https://github.com/xaxes/self-update", "refs/heads/main"},
+		{"git+https://github.com/xaxes/self-update.git@" + testCommit, "https://github.com/xaxes/self-update", testCommit},
+		{"git+ssh://git@github.com/xaxes/self-update", "ssh://git@github.com/xaxes/self-update", ""},
+		{"https://github.com/xaxes/self-update", "https://github.com/xaxes/self-update", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			repo, ref := splitGitURI(tt.uri)
+			if repo != tt.wantRepo || ref != tt.wantRef {
+				t.Errorf("splitGitURI() = %s, %s, want %s, %s", repo, ref, tt.wantRepo, tt.wantRef)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package check
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SumsName is the name of the checksum manifest in the upgrade directory,
+// in the format of `sha256sum`.
+const SumsName = "SHA256SUMS"
+
+// RequireSums rejects all candidates if the upgrade directory has no
+// checksum manifest.
+var RequireSums bool
+
+var (
+	errNotListed = errors.New("not listed in " + SumsName)
+	errNoSums    = errors.New(SumsName + " is required but missing")
+)
+
+// Mismatch is a candidate whose SHA-256 differs from the one listed in
+// the checksum manifest.
+type Mismatch struct {
+	Path string
+	Want string
+	Got  string
+}
+
+func (m *Mismatch) Error() string {
+	return fmt.Sprintf("checksum mismatch: %s has SHA-256 %s, %s lists %s", m.Path, m.Got, SumsName, m.Want)
+}
+
+// ParseSums parses the lines `<SHA-256> <name>` of a checksum manifest,
+// where the name may be prefixed with `*`.
+func ParseSums(b []byte) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want `<SHA-256> <name>`", SumsName, n)
+		}
+
+		sum := strings.ToLower(fields[0])
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("%s:%d: bad SHA-256 %q", SumsName, n, fields[0])
+		}
+
+		name := strings.TrimPrefix(strings.TrimLeft(fields[1], " *"), "./")
+		sums[name] = sum
+	}
+
+	return sums, s.Err()
+}
+
+// readSums reads the checksum manifest of `dir`.
+//
+// It returns nil without an error if there is none.
+func readSums(dir string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, SumsName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSums(b)
+}
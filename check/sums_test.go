@@ -0,0 +1,52 @@
+package check
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSums(t *testing.T) {
+	const (
+		a = "f2b6f19d3f9dbb6f006aa03cd0167d796e2367c9a53f4b50f59355a922576c2c"
+		b = "a756fcd46c2f956a4cf97f81dcf209d328f42a8e3a51b2516861377340c45cef"
+	)
+
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "text and binary mode",
+			in:   a + "  self-update-1.1.0\n" + b + " *self-update-1.2.0.tar.gz\n",
+			want: map[string]string{"self-update-1.1.0": a, "self-update-1.2.0.tar.gz": b},
+		},
+		{
+			name: "upper case and relative names",
+			in:   "\n" + "F2B6F19D3F9DBB6F006AA03CD0167D796E2367C9A53F4B50F59355A922576C2C  ./self-update\n",
+			want: map[string]string{"self-update": a},
+		},
+		{
+			name:    "short digest",
+			in:      "f2b6f19d  self-update\n",
+			wantErr: true,
+		},
+		{
+			name:    "no name",
+			in:      a + "\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSums([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSums() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSums() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
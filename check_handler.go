@@ -9,33 +9,38 @@ import (
 	"go.uber.org/zap"
 )
 
-func checkHandler(w http.ResponseWriter, r *http.Request) {
-	zap.L().Info("handle HTTP request", zap.String("method", r.Method), zap.String("uri", r.RequestURI))
-
-	new, err := check.NewestCandidate(UpgradeDir, Version)
-	if err != nil {
-		if errors.Is(err, check.ErrNoCandidate) {
-			w.WriteHeader(http.StatusNotFound)
-
-			if _, err := w.Write([]byte(err.Error())); err != nil {
-				zap.L().Error("write response", zap.Error(err))
-				return
-			}
+// checkHandler reports the newest candidate newer than `version` and
+// the binaries rejected by SHA256SUMS or built for another platform.
+func checkHandler(version func() string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zap.L().Info("handle HTTP request", zap.String("method", r.Method), zap.String("uri", r.RequestURI))
+
+		status, body := http.StatusOK, ""
+
+		new, err := candidates.Newest(r.Context(), version())
+		switch {
+		case errors.Is(err, check.ErrNoCandidate):
+			status, body = http.StatusNotFound, err.Error()
+		case err != nil:
+			status, body = http.StatusInternalServerError, err.Error()
+		default:
+			body = fmt.Sprintf("candidate: %s (%s)", new.Path, new.Version)
+		}
 
-			return
+		// Rejected binaries are reported, as they are usually copied
+		// partially or tampered with.
+		for _, m := range candidates.Mismatches() {
+			body += "\n" + m.Error()
+		}
+		for _, inc := range candidates.Incompatibles() {
+			body += "\n" + inc.Error()
 		}
 
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(status)
 
-		if _, err := w.Write([]byte(err.Error())); err != nil {
+		if _, err := w.Write([]byte(body)); err != nil {
 			zap.L().Error("write response", zap.Error(err))
 			return
 		}
-		return
-	}
-
-	if _, err := w.Write([]byte(fmt.Sprintf("candidate: %s (%s)", new.Path, new.Version))); err != nil {
-		zap.L().Error("write response", zap.Error(err))
-		return
 	}
 }
@@ -0,0 +1,375 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xaxes/self-update/autoupdate"
+	"github.com/xaxes/self-update/forge"
+	"github.com/xaxes/self-update/oci"
+	"github.com/xaxes/self-update/remote"
+	"github.com/xaxes/self-update/s3"
+	"github.com/xaxes/self-update/tuf"
+	"github.com/xaxes/self-update/upgrade"
+)
+
+var errNonPositiveDuration = errors.New("duration must be positive")
+
+// Duration is a time.Duration expressed in JSON as a string, e.g. "1m30s".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config is the configuration read from the file passed with `-config`.
+type Config struct {
+	Probes        []upgrade.Probe `json:"probes"`
+	ProbeInterval Duration        `json:"probe_interval"`
+	ProbeTimeout  Duration        `json:"probe_timeout"`
+	AutoUpdate    AutoUpdate      `json:"auto_update"`
+	Remote        Remote          `json:"remote"`
+}
+
+// Window is a maintenance window.
+type Window struct {
+	Cron     string   `json:"cron"`     // Times the window opens at
+	Duration Duration `json:"duration"` // Time the window stays open for
+}
+
+// AutoUpdate configures automatic upgrades.
+type AutoUpdate struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval"`
+	Windows  []Window `json:"windows"`
+	Timezone string   `json:"timezone"`
+	Jitter   Duration `json:"jitter"`
+	Soak     Duration `json:"soak"`
+	DryRun   bool     `json:"dry_run"`
+}
+
+// Policy returns the parsed auto-update policy.
+func (a AutoUpdate) Policy() (autoupdate.Policy, error) {
+	p := autoupdate.Policy{
+		Enabled:  a.Enabled,
+		Interval: time.Duration(a.Interval),
+		Jitter:   time.Duration(a.Jitter),
+		Soak:     time.Duration(a.Soak),
+		DryRun:   a.DryRun,
+	}
+
+	if a.Timezone != "" {
+		loc, err := time.LoadLocation(a.Timezone)
+		if err != nil {
+			return autoupdate.Policy{}, fmt.Errorf("timezone: %w", err)
+		}
+
+		p.Location = loc
+	}
+
+	for _, w := range a.Windows {
+		start, err := autoupdate.ParseCron(w.Cron)
+		if err != nil {
+			return autoupdate.Policy{}, err
+		}
+
+		if w.Duration <= 0 {
+			return autoupdate.Policy{}, fmt.Errorf(`window "%s": %w`, w.Cron, errNonPositiveDuration)
+		}
+
+		p.Windows = append(p.Windows, autoupdate.Window{Start: start, Duration: time.Duration(w.Duration)})
+	}
+
+	return p, nil
+}
+
+// Remote configures the source candidates are downloaded from.
+type Remote struct {
+	Interval Duration `json:"interval"`
+	TUF      *TUF     `json:"tuf"`
+	OCI      *OCI     `json:"oci"`
+	S3       *S3      `json:"s3"`
+	Forge    *Forge   `json:"forge"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Unknown keys are rejected,
+// so a misspelt or unsupported source is not silently ignored.
+func (r *Remote) UnmarshalJSON(b []byte) error {
+	type remote Remote
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+
+	return d.Decode((*remote)(r))
+}
+
+// TUF configures a repository of The Update Framework.
+type TUF struct {
+	URL     string `json:"url"`     // Repository with metadata/ and targets/
+	Root    string `json:"root"`    // Trusted root.json shipped out of band
+	Targets string `json:"targets"` // Pattern of target paths to download
+}
+
+// OCI configures a repository of an OCI registry.
+type OCI struct {
+	URL        string `json:"url"`        // Registry, e.g. https://registry.example.com
+	Repository string `json:"repository"` // Repository in the registry
+	Path       string `json:"path"`       // Path of the binary in image layers; artifact if empty
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+}
+
+// Forge configures a repository of a forge with the GitHub releases API.
+type Forge struct {
+	URL        string        `json:"url"`        // API, GitHub by default; e.g. https://gitea.example.com/api/v1
+	Repository string        `json:"repository"` // Repository, e.g. xaxes/self-update
+	Token      string        `json:"token"`      // Required for private repositories and drafts
+	Channel    forge.Channel `json:"channel"`    // Releases considered; stable by default
+}
+
+// S3 configures a bucket of S3-compatible storage.
+//
+// The credentials default to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN environment variables; requests are anonymous
+// without any.
+type S3 struct {
+	Endpoint        string `json:"endpoint"` // Storage other than Amazon S3, e.g. http://localhost:9000
+	Region          string `json:"region"`   // Defaults to us-east-1
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix"`  // Prefix of the keys of releases
+	Pattern         string `json:"pattern"` // Pattern of the base names of releases
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+}
+
+func (c S3) client() (*s3.Client, error) {
+	creds := s3.Credentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+	}
+	if creds.AccessKeyID == "" {
+		creds = s3.Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+
+	return s3.NewClient(s3.Config{
+		Endpoint:    c.Endpoint,
+		Region:      c.Region,
+		Bucket:      c.Bucket,
+		Credentials: creds,
+	})
+}
+
+// Source returns the configured source, or nil if there is none.
+//
+// The trusted metadata is kept in `stateDir`.
+func (r Remote) Source(stateDir string) (remote.Source, error) {
+	if f := r.Forge; f != nil {
+		url := f.URL
+		if url == "" {
+			url = forge.DefaultURL
+		}
+		return forge.NewSource(forge.NewClient(url, f.Repository, f.Token), f.Channel), nil
+	}
+
+	if r.S3 != nil {
+		c, err := r.S3.client()
+		if err != nil {
+			return nil, fmt.Errorf("s3: %w", err)
+		}
+		return s3.NewSource(c, r.S3.Prefix, r.S3.Pattern), nil
+	}
+
+	if r.OCI != nil {
+		c := oci.NewClient(r.OCI.URL, r.OCI.Repository, r.OCI.Username, r.OCI.Password)
+		return oci.NewSource(c, r.OCI.Path), nil
+	}
+
+	if r.TUF == nil {
+		return nil, nil
+	}
+
+	c, err := tuf.New(r.TUF.URL, filepath.Join(stateDir, "tuf"), r.TUF.Root)
+	if err != nil {
+		return nil, fmt.Errorf("tuf: %w", err)
+	}
+
+	return tuf.NewSource(c, r.TUF.Targets), nil
+}
+
+func (r Remote) validate() error {
+	if r.Interval < 0 {
+		return fmt.Errorf("interval: %w", errNonPositiveDuration)
+	}
+
+	n := 0
+	for _, set := range []bool{r.TUF != nil, r.OCI != nil, r.S3 != nil, r.Forge != nil} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return errors.New("only one of tuf, oci, s3 and forge may be set")
+	}
+
+	if f := r.Forge; f != nil {
+		if strings.Count(strings.Trim(f.Repository, "/"), "/") != 1 {
+			return fmt.Errorf("forge: repository %q is not <owner>/<name>", f.Repository)
+		}
+
+		switch f.Channel {
+		case "", forge.Stable, forge.Prerelease, forge.Draft:
+		default:
+			return fmt.Errorf("forge: unknown channel %q", f.Channel)
+		}
+	}
+
+	if c := r.S3; c != nil {
+		if _, err := c.client(); err != nil {
+			return fmt.Errorf("s3: %w", err)
+		}
+		if _, err := path.Match(c.Pattern, ""); err != nil {
+			return fmt.Errorf("s3: pattern: %w", err)
+		}
+	}
+
+	if o := r.OCI; o != nil {
+		if o.URL == "" {
+			return errors.New("oci: url is required")
+		}
+		if o.Repository == "" {
+			return errors.New("oci: repository is required")
+		}
+	}
+
+	if r.TUF == nil {
+		return nil
+	}
+
+	if r.TUF.URL == "" {
+		return errors.New("tuf: url is required")
+	}
+	if r.TUF.Root == "" {
+		return errors.New("tuf: root is required")
+	}
+	if _, err := path.Match(r.TUF.Targets, ""); err != nil {
+		return fmt.Errorf("tuf: targets: %w", err)
+	}
+
+	return nil
+}
+
+// HealthCheck returns the probes run against the upgraded instance.
+func (c Config) HealthCheck() upgrade.HealthCheck {
+	return upgrade.HealthCheck{
+		Probes:   c.Probes,
+		Interval: time.Duration(c.ProbeInterval),
+		Timeout:  time.Duration(c.ProbeTimeout),
+	}
+}
+
+// Load reads the configuration from `path`.
+//
+// An empty path results in the zero configuration.
+func Load(path string) (Config, error) {
+	var c Config
+	if path == "" {
+		return c, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Config{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if err := c.HealthCheck().Validate(); err != nil {
+		return Config{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if _, err := c.AutoUpdate.Policy(); err != nil {
+		return Config{}, fmt.Errorf("parse %s: auto_update: %w", path, err)
+	}
+
+	if err := c.Remote.validate(); err != nil {
+		return Config{}, fmt.Errorf("parse %s: remote: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Reloadable is the configuration of a file which may be read again
+// while the service runs.
+type Reloadable struct {
+	path string
+
+	mu   sync.Mutex
+	conf Config
+}
+
+// NewReloadable reads the configuration from `path`.
+func NewReloadable(path string) (*Reloadable, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reloadable{path: path, conf: c}, nil
+}
+
+// Config returns the most recently read configuration.
+func (r *Reloadable) Config() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.conf
+}
+
+// Reload reads the configuration again. The previous one is kept
+// if the file is invalid.
+func (r *Reloadable) Reload() error {
+	c, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.conf = c
+	r.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xaxes/self-update/forge"
+	"github.com/xaxes/self-update/oci"
+	"github.com/xaxes/self-update/s3"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			json: `{}`,
+		},
+		{
+			name: "valid",
+			json: `{
+				"probes": [{"path": "/", "body": "version \\d+"}],
+				"auto_update": {"windows": [{"cron": "0 2 * * 1-5", "duration": "2h"}], "timezone": "Europe/Warsaw"},
+				"remote": {"interval": "15m", "forge": {"repository": "xaxes/self-update", "channel": "prerelease"}}
+			}`,
+		},
+		{
+			name:    "invalid JSON",
+			json:    `{"probes": [}`,
+			wantErr: true,
+		},
+		{
+			name:    "bad probe regex",
+			json:    `{"probes": [{"path": "/", "body": "version ("}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "bad cron field count",
+			json:    `{"auto_update": {"windows": [{"cron": "0 2 * *", "duration": "2h"}]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "cron value out of range",
+			json:    `{"auto_update": {"windows": [{"cron": "0 24 * * *", "duration": "2h"}]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "window without duration",
+			json:    `{"auto_update": {"windows": [{"cron": "0 2 * * *"}]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown timezone",
+			json:    `{"auto_update": {"timezone": "Mars/Olympus_Mons"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown remote kind",
+			json:    `{"remote": {"ftp": {"url": "ftp://releases.example.com"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "multiple remote kinds",
+			json:    `{"remote": {"oci": {"url": "https://registry.example.com", "repository": "self-update"}, "forge": {"repository": "xaxes/self-update"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "negative remote interval",
+			json:    `{"remote": {"interval": "-1m"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "forge repository without owner",
+			json:    `{"remote": {"forge": {"repository": "self-update"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown forge channel",
+			json:    `{"remote": {"forge": {"repository": "xaxes/self-update", "channel": "nightly"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "oci without repository",
+			json:    `{"remote": {"oci": {"url": "https://registry.example.com"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "tuf without root",
+			json:    `{"remote": {"tuf": {"url": "https://releases.example.com"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "bad tuf targets pattern",
+			json:    `{"remote": {"tuf": {"url": "https://releases.example.com", "root": "root.json", "targets": "["}}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "config.json")
+			if err := ioutil.WriteFile(path, []byte(tt.json), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := Load(path); (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRemote_Source(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		remote   Remote
+		wantType reflect.Type
+		wantErr  bool
+	}{
+		{
+			name:   "none",
+			remote: Remote{},
+		},
+		{
+			name:     "forge",
+			remote:   Remote{Forge: &Forge{Repository: "xaxes/self-update"}},
+			wantType: reflect.TypeOf(&forge.Source{}),
+		},
+		{
+			name:     "s3",
+			remote:   Remote{S3: &S3{Bucket: "updates", Prefix: "releases/"}},
+			wantType: reflect.TypeOf(&s3.Source{}),
+		},
+		{
+			name:     "oci",
+			remote:   Remote{OCI: &OCI{URL: "https://registry.example.com", Repository: "self-update"}},
+			wantType: reflect.TypeOf(&oci.Source{}),
+		},
+		{
+			// The trusted root is read when the source is created.
+			name:    "tuf without root file",
+			remote:  Remote{TUF: &TUF{URL: "https://releases.example.com", Root: filepath.Join(dir, "missing.json")}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.remote.Source(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Source() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if tt.wantType == nil {
+				if got != nil {
+					t.Errorf("Source() = %T, want nil", got)
+				}
+				return
+			}
+			if reflect.TypeOf(got) != tt.wantType {
+				t.Errorf("Source() = %T, want %v", got, tt.wantType)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/xaxes/self-update/upgrade"
+	"go.uber.org/zap"
+)
+
+// retryMillis is the reconnection delay suggested to the clients.
+//
+// The stream breaks when the outgoing instance exits and continues
+// once the client reconnects to the upgraded one.
+const retryMillis = 1000
+
+func writeEvent(w http.ResponseWriter, e upgrade.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, data)
+	return err
+}
+
+// eventsHandler streams the upgrade progress as Server-Sent Events.
+//
+// The events following `Last-Event-ID` are sent first, so a client
+// reconnecting to the upgraded instance does not see them twice.
+func eventsHandler(t *upgrade.Tracker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zap.L().Info("handle HTTP request", zap.String("method", r.Method), zap.String("uri", r.RequestURI))
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			if _, err := w.Write([]byte("streaming unsupported")); err != nil {
+				zap.L().Error("write response", zap.Error(err))
+			}
+
+			return
+		}
+
+		// An invalid or missing header means that the client has not
+		// seen any of the events yet.
+		after, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+
+		backlog, events, cancel := t.Subscribe(after)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", retryMillis); err != nil {
+			zap.L().Error("write response", zap.Error(err))
+			return
+		}
+
+		for _, e := range backlog {
+			if err := writeEvent(w, e); err != nil {
+				zap.L().Error("write response", zap.Error(err))
+				return
+			}
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case e := <-events:
+				if err := writeEvent(w, e); err != nil {
+					zap.L().Error("write response", zap.Error(err))
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
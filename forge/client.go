@@ -0,0 +1,239 @@
+// Package forge downloads binaries attached to the releases of a
+// repository of a forge with the GitHub releases API, such as GitHub
+// or Gitea.
+//
+// See https://docs.github.com/en/rest/releases.
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xaxes/self-update/check"
+	"github.com/xaxes/self-update/remote"
+)
+
+// DefaultURL is the API of GitHub.
+const DefaultURL = "https://api.github.com"
+
+// Size limits of responses other than assets.
+const (
+	maxPageSize = 8 << 20
+	maxSumsSize = 1 << 20
+)
+
+// maxPages limits the pages of releases listed at once; older releases
+// are not listed.
+const maxPages = 10
+
+// ErrRateLimited is returned once the rate limit of the API is exceeded.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// release is a release of a repository.
+type release struct {
+	TagName    string  `json:"tag_name"`
+	Draft      bool    `json:"draft"`
+	Prerelease bool    `json:"prerelease"`
+	Assets     []asset `json:"assets"`
+}
+
+// asset is a file attached to a release.
+type asset struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// URL is the API endpoint of GitHub serving the contents with
+	// `Accept: application/octet-stream`; Gitea has none.
+	URL                string `json:"url"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+	// Digest is e.g. `sha256:…`, if the forge computes one.
+	Digest string `json:"digest"`
+}
+
+// page is a cached response for conditional requests.
+type page struct {
+	etag string
+	body []byte
+	next string
+}
+
+// Client reads the releases of a repository.
+//
+// Listings are requested with the ETag of the previous response, so
+// unchanged ones do not count against the rate limit.
+type Client struct {
+	url   string
+	repo  string
+	token string
+	http  *http.Client
+
+	mu    sync.Mutex
+	pages map[string]page // by URL
+}
+
+// NewClient returns a client of the repository `repo`, e.g.
+// `xaxes/self-update`, of the API at `url`, e.g. DefaultURL or
+// https://gitea.example.com/api/v1. The token is required for private
+// repositories and draft releases.
+func NewClient(url, repo, token string) *Client {
+	return &Client{
+		url:   strings.TrimSuffix(url, "/"),
+		repo:  strings.Trim(repo, "/"),
+		token: token,
+		// Assets are binaries.
+		http:  &http.Client{Timeout: 10 * time.Minute},
+		pages: make(map[string]page),
+	}
+}
+
+// do sends a GET request for `u`, authenticated if it is a URL of the
+// API host. A 2xx or 304 response is returned.
+func (c *Client) do(ctx context.Context, u string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+
+	// The token is not leaked to other hosts assets are served from;
+	// redirects to them drop it too.
+	if api, err := url.Parse(c.url); err == nil && c.token != "" && req.URL.Host == api.Host {
+		req.Header.Set("Authorization", "token "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 == 2 || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return nil, fmt.Errorf("GET %s: %w until %s", u, ErrRateLimited, time.Unix(reset, 0).UTC().Format(time.RFC3339))
+		}
+		return nil, fmt.Errorf("GET %s: %w", u, ErrRateLimited)
+	}
+
+	return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+}
+
+// Releases lists the releases of the repository, newest first.
+func (c *Client) Releases(ctx context.Context) ([]release, error) {
+	var rs []release
+
+	u := c.url + "/repos/" + c.repo + "/releases?per_page=100"
+	for n := 0; u != "" && n < maxPages; n++ {
+		p, err := c.page(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+
+		var list []release
+		if err := json.Unmarshal(p.body, &list); err != nil {
+			return nil, fmt.Errorf("releases: %w", err)
+		}
+
+		rs = append(rs, list...)
+		u = p.next
+	}
+
+	return rs, nil
+}
+
+// page returns the response for `u`, the cached one if unchanged.
+func (c *Client) page(ctx context.Context, u string) (page, error) {
+	c.mu.Lock()
+	cached, ok := c.pages[u]
+	c.mu.Unlock()
+
+	h := http.Header{"Accept": {"application/json"}}
+	if ok {
+		h.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := c.do(ctx, u, h)
+	if err != nil {
+		return page{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		if !ok {
+			return page{}, fmt.Errorf("GET %s: %s without a condition", u, resp.Status)
+		}
+		return cached, nil
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPageSize+1))
+	if err != nil {
+		return page{}, err
+	}
+	if len(b) > maxPageSize {
+		return page{}, fmt.Errorf("GET %s: exceeds %d bytes", u, maxPageSize)
+	}
+
+	p := page{etag: resp.Header.Get("ETag"), body: b, next: remote.NextLink(resp.Header.Get("Link"))}
+	if p.etag != "" {
+		c.mu.Lock()
+		c.pages[u] = p
+		c.mu.Unlock()
+	}
+
+	return p, nil
+}
+
+// Download writes the contents of the asset at `u` to `w`.
+func (c *Client) Download(ctx context.Context, u string, w io.Writer) error {
+	resp, err := c.do(ctx, u, http.Header{"Accept": {"application/octet-stream"}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Sums returns the checksum manifest at `u`, in the format of
+// `sha256sum`.
+func (c *Client) Sums(ctx context.Context, u string) (map[string]string, error) {
+	resp, err := c.do(ctx, u, http.Header{"Accept": {"application/octet-stream"}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSumsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxSumsSize {
+		return nil, fmt.Errorf("GET %s: exceeds %d bytes", u, maxSumsSize)
+	}
+
+	return check.ParseSums(b)
+}
@@ -0,0 +1,286 @@
+package forge
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver"
+	"github.com/xaxes/self-update/check"
+	"github.com/xaxes/self-update/remote"
+	"go.uber.org/zap"
+)
+
+// Channel selects releases by their draft and pre-release flags.
+type Channel string
+
+// Channels, each including the releases of the previous ones.
+const (
+	Stable     Channel = "stable"
+	Prerelease Channel = "prerelease"
+	Draft      Channel = "draft" // Drafts are only listed with a token
+)
+
+func (ch Channel) includes(r release) bool {
+	switch {
+	case r.Draft:
+		return ch == Draft
+	case r.Prerelease:
+		return ch == Draft || ch == Prerelease
+	}
+
+	return true
+}
+
+// platformAliases are the names of platforms in asset names other than
+// GOOS and GOARCH, e.g. app_Darwin_x86_64.tar.gz.
+var platformAliases = map[string][]string{
+	"darwin":  {"macos", "osx"},
+	"windows": {"win"},
+	"amd64":   {"x86_64", "x64"},
+	"386":     {"i386", "i686"},
+	"arm64":   {"aarch64"},
+	"arm":     {"armv6", "armv7", "armhf"},
+}
+
+// otherAssets are the suffixes of assets other than binaries and
+// archives of them, e.g. signatures and packages.
+var otherAssets = []string{
+	".sig", ".pem", ".bundle", ".intoto.jsonl", ".sha256", ".asc", ".txt", ".json", ".sbom",
+	".deb", ".rpm", ".apk", ".msi", ".dmg", ".pkg",
+}
+
+// Source provides the releases of a repository with a semver tag, e.g.
+// v1.2.0, and an asset for the running platform.
+type Source struct {
+	client  *Client
+	channel Channel
+	goos    string
+	goarch  string
+
+	mu         sync.Mutex
+	sums       map[string]string           // URLs of checksum manifests by asset URL
+	companions map[string][]remote.Release // by asset URL
+}
+
+// NewSource returns the source of the releases of `c` in the channel
+// `ch`, Stable if empty.
+func NewSource(c *Client, ch Channel) *Source {
+	if ch == "" {
+		ch = Stable
+	}
+
+	return &Source{
+		client:  c,
+		channel: ch,
+		goos:    runtime.GOOS,
+		goarch:  runtime.GOARCH,
+		sums:    make(map[string]string),
+
+		companions: make(map[string][]remote.Release),
+	}
+}
+
+// Releases implements remote.Source.
+//
+// The asset of a release is the first one in name order whose name has
+// the GOOS and the GOARCH as words, e.g. app_1.2.0_linux_amd64.tar.gz or
+// app-linux-arm64, or common aliases of them, e.g. x86_64 for amd64.
+// A patch archive for the platform (see check.IsPatch) is listed too.
+func (s *Source) Releases(ctx context.Context) ([]remote.Release, error) {
+	list, err := s.client.Releases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string)
+	companions := make(map[string][]remote.Release)
+
+	var rs []remote.Release
+	for _, r := range list {
+		if !s.channel.includes(r) {
+			continue
+		}
+
+		v, err := semver.NewVersion(r.TagName)
+		if err != nil {
+			zap.L().Debug("forge: skip release", zap.String("tag", r.TagName), zap.Error(err))
+			continue
+		}
+
+		a, ok := platformAsset(r.Assets, s.goos, s.goarch, false)
+		if !ok {
+			zap.L().Debug("forge: skip release without asset", zap.String("tag", r.TagName),
+				zap.String("platform", s.goos+"/"+s.goarch))
+			continue
+		}
+
+		assets := []asset{a}
+		if p, ok := platformAsset(r.Assets, s.goos, s.goarch, true); ok {
+			assets = append(assets, p)
+		}
+
+		for _, a := range assets {
+			rel := assetRelease(a, v)
+			rs = append(rs, rel)
+			ref := rel.Ref
+
+			for _, ext := range remote.Companions {
+				if c, ok := namedAsset(r.Assets, a.Name+ext); ok {
+					companions[ref] = append(companions[ref], assetRelease(c, v))
+				}
+			}
+
+			if m, ok := sumsAsset(r.Assets); ok {
+				sums[ref] = m.URL
+				if m.URL == "" {
+					sums[ref] = m.BrowserDownloadURL
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.sums = sums
+	s.companions = companions
+	s.mu.Unlock()
+
+	return rs, nil
+}
+
+// assetRelease returns the asset `a` of the release with version `v`.
+func assetRelease(a asset, v *semver.Version) remote.Release {
+	ref := a.URL
+	if ref == "" {
+		ref = a.BrowserDownloadURL
+	}
+
+	r := remote.Release{
+		Name:    a.Name,
+		Ref:     ref,
+		Version: v,
+		Size:    a.Size,
+	}
+	if strings.HasPrefix(a.Digest, "sha256:") {
+		r.SHA256 = strings.TrimPrefix(a.Digest, "sha256:")
+	}
+
+	return r
+}
+
+// Companions implements remote.CompanionSource. They are the assets of
+// the same release named after the asset of `r`, e.g. its .bundle.
+func (s *Source) Companions(ctx context.Context, r remote.Release) ([]remote.Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.companions[r.Ref], nil
+}
+
+// Resolve implements remote.Resolver. Releases whose asset has no digest
+// are looked up in the checksum manifest attached to them, if any, e.g.
+// checksums.txt of GoReleaser.
+func (s *Source) Resolve(ctx context.Context, r remote.Release) (remote.Release, error) {
+	if r.SHA256 != "" {
+		return r, nil
+	}
+
+	s.mu.Lock()
+	u, ok := s.sums[r.Ref]
+	s.mu.Unlock()
+	if !ok {
+		return r, nil
+	}
+
+	sums, err := s.client.Sums(ctx, u)
+	if err != nil {
+		return remote.Release{}, err
+	}
+
+	sum, ok := sums[r.Name]
+	if !ok {
+		return remote.Release{}, fmt.Errorf("%s not listed in the checksum manifest", r.Name)
+	}
+	r.SHA256 = sum
+
+	return r, nil
+}
+
+// Download implements remote.Source.
+func (s *Source) Download(ctx context.Context, r remote.Release, w io.Writer) error {
+	return s.client.Download(ctx, r.Ref, w)
+}
+
+// platformAsset returns the asset for the platform, the patch archive
+// if `patch`.
+func platformAsset(assets []asset, goos, goarch string, patch bool) (asset, bool) {
+	sorted := append([]asset{}, assets...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Name < sorted[b].Name })
+
+	for _, a := range sorted {
+		if check.IsPatch(a.Name) != patch {
+			continue
+		}
+
+		if !isSums(a.Name) && !isOther(a.Name) && hasWord(a.Name, goos) && hasWord(a.Name, goarch) {
+			return a, true
+		}
+	}
+
+	return asset{}, false
+}
+
+// hasWord reports whether `name` has `word` or one of its aliases
+// delimited by `-`, `_` or `.`, ignoring the case.
+func hasWord(name, word string) bool {
+	name = strings.ToLower(name)
+
+	for _, w := range append([]string{word}, platformAliases[word]...) {
+		if regexp.MustCompile(`(^|[-_.])` + regexp.QuoteMeta(w) + `($|[-_.])`).MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isOther(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range otherAssets {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSums(name string) bool {
+	lower := strings.ToLower(name)
+	return name == check.SumsName || strings.HasSuffix(lower, "checksums.txt") || strings.HasSuffix(lower, "sha256sums.txt")
+}
+
+func namedAsset(assets []asset, name string) (asset, bool) {
+	for _, a := range assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+
+	return asset{}, false
+}
+
+func sumsAsset(assets []asset) (asset, bool) {
+	for _, a := range assets {
+		if isSums(a.Name) {
+			return a, true
+		}
+	}
+
+	return asset{}, false
+}
@@ -0,0 +1,336 @@
+package forge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xaxes/self-update/remote"
+	"github.com/xaxes/self-update/remote/remotetest"
+)
+
+const (
+	testRepo  = "xaxes/self-update"
+	testToken = "token-123"
+)
+
+// testForge serves the releases API of a single repository, two
+// releases per page. Drafts are only listed with the token.
+type testForge struct {
+	t     *testing.T
+	srv   *httptest.Server
+	gitea bool // whether assets have no API URL
+
+	mu          sync.Mutex
+	releases    []release // newest first
+	files       map[string][]byte
+	full        int // listings sent in full
+	notModified int
+	rateLimited bool
+}
+
+func newTestForge(t *testing.T) *testForge {
+	f := &testForge{t: t, files: make(map[string][]byte)}
+	f.srv = remotetest.NewServer(t, f.serve)
+
+	return f
+}
+
+func (f *testForge) serve(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.rateLimited {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", "1700000000")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	authorized := req.Header.Get("Authorization") == "token "+testToken
+
+	if b, ok := f.files[req.URL.Path]; ok {
+		if strings.HasPrefix(req.URL.Path, "/api/") && req.Header.Get("Accept") != "application/octet-stream" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write(b)
+		return
+	}
+
+	if req.URL.Path != "/api/repos/"+testRepo+"/releases" {
+		http.NotFound(w, req)
+		return
+	}
+
+	var visible []release
+	for _, r := range f.releases {
+		if !r.Draft || authorized {
+			visible = append(visible, r)
+		}
+	}
+
+	n, _ := strconv.Atoi(req.URL.Query().Get("page"))
+	if n == 0 {
+		n = 1
+	}
+	start, end := 2*(n-1), 2*n
+	if start > len(visible) {
+		start = len(visible)
+	}
+	if end >= len(visible) {
+		end = len(visible)
+	} else {
+		w.Header().Set("Link", fmt.Sprintf(`<%s/api/repos/%s/releases?per_page=100&page=%d>; rel="next"`, f.srv.URL, testRepo, n+1))
+	}
+
+	b, err := json.Marshal(visible[start:end])
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(b)
+	etag := `W/"` + hex.EncodeToString(sum[:8]) + `"`
+	if req.Header.Get("If-None-Match") == etag {
+		f.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	f.full++
+	w.Header().Set("ETag", etag)
+	w.Write(b)
+}
+
+// release publishes the release `tag` with assets of the given names and
+// contents; a digest is set for assets with `digest`.
+func (f *testForge) release(tag string, draft, prerelease, digest bool, files map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r := release{TagName: tag, Draft: draft, Prerelease: prerelease}
+	for name, data := range files {
+		a := asset{
+			Name:               name,
+			Size:               int64(len(data)),
+			BrowserDownloadURL: f.srv.URL + "/download/" + tag + "/" + name,
+		}
+		f.files["/download/"+tag+"/"+name] = []byte(data)
+
+		if !f.gitea {
+			id := strconv.Itoa(len(f.files))
+			a.URL = f.srv.URL + "/api/repos/" + testRepo + "/releases/assets/" + id
+			f.files["/api/repos/"+testRepo+"/releases/assets/"+id] = []byte(data)
+		}
+
+		if digest {
+			a.Digest = "sha256:" + remotetest.SHA256Hex(data)
+		}
+
+		r.Assets = append(r.Assets, a)
+	}
+
+	f.releases = append([]release{r}, f.releases...)
+}
+
+// binary returns the name of the asset of `version` for the running
+// platform.
+func binary(version string) string {
+	return fmt.Sprintf("app_%s_%s_%s", version, runtime.GOOS, runtime.GOARCH)
+}
+
+func publish(f *testForge) {
+	f.release("v0.9.0", false, false, false, map[string]string{"app_0.9.0_plan9_mips": "0.9.0"})
+	f.release("v1.0.0", false, false, true, map[string]string{binary("1.0.0"): "1.0.0"})
+	f.release("v1.1.0", false, false, false, map[string]string{
+		binary("1.1.0"):                "1.1.0",
+		binary("1.1.0") + ".sig":       "signature",
+		"app_1.1.0_plan9_mips":         "other",
+		"app_1.1.0_checksums.txt":      remotetest.SHA256Hex("1.1.0") + "  " + binary("1.1.0") + "\n",
+		binary("1.1.0") + ".sbom.json": "sbom",
+	})
+	f.release("nightly", false, true, true, map[string]string{binary("nightly"): "nightly"})
+	f.release("v1.2.0-rc.1", false, true, true, map[string]string{binary("1.2.0-rc.1"): "1.2.0-rc.1"})
+	f.release("v1.3.0", true, false, true, map[string]string{binary("1.3.0"): "1.3.0"})
+}
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		gitea     bool
+		token     string
+		channel   Channel
+		want      string
+		wantFiles []string
+	}{
+		{name: "stable", token: testToken, want: "1.1.0", wantFiles: []string{binary("1.1.0") + ".sig"}},
+		{name: "prerelease", token: testToken, channel: Prerelease, want: "1.2.0-rc.1"},
+		{name: "draft", token: testToken, channel: Draft, want: "1.3.0"},
+		{name: "draft without token", channel: Draft, want: "1.2.0-rc.1"},
+		{name: "gitea", gitea: true, token: testToken, want: "1.1.0", wantFiles: []string{binary("1.1.0") + ".sig"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestForge(t)
+			f.gitea = tt.gitea
+			publish(f)
+
+			dir := remotetest.TempDir(t, "forge")
+
+			src := NewSource(NewClient(f.srv.URL+"/api", testRepo, tt.token), tt.channel)
+			rel, err := remote.Sync(context.Background(), src, dir, "0.1.0")
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			if rel.Name != binary(tt.want) || rel.SHA256 != remotetest.SHA256Hex(tt.want) {
+				t.Errorf("Sync() = %s %s, want %s %s", rel.Name, rel.SHA256, binary(tt.want), remotetest.SHA256Hex(tt.want))
+			}
+
+			// Companion files are downloaded next to the release.
+			for _, name := range tt.wantFiles {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("companion %s: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSource_conditional(t *testing.T) {
+	f := newTestForge(t)
+	publish(f)
+
+	src := NewSource(NewClient(f.srv.URL+"/api", testRepo, testToken), Stable)
+	for i := 0; i < 2; i++ {
+		if _, err := src.Releases(context.Background()); err != nil {
+			t.Fatalf("Releases() error = %v", err)
+		}
+	}
+	if f.full != 3 || f.notModified != 3 {
+		t.Errorf("sent %d listings in full and %d unmodified, want 3 and 3", f.full, f.notModified)
+	}
+
+	f.release("v1.4.0", false, false, true, map[string]string{binary("1.4.0"): "1.4.0"})
+	rs, err := src.Releases(context.Background())
+	if err != nil {
+		t.Fatalf("Releases() error = %v", err)
+	}
+	if len(rs) == 0 || rs[0].Version.String() != "1.4.0" {
+		t.Errorf("Releases() = %v, want 1.4.0 first", rs)
+	}
+
+	t.Run("rate limited", func(t *testing.T) {
+		f.rateLimited = true
+		_, err := src.Releases(context.Background())
+		if !errors.Is(err, ErrRateLimited) || !strings.Contains(err.Error(), "2023-11-14T22:13:20Z") {
+			t.Errorf("Releases() error = %v, want %v until 2023-11-14T22:13:20Z", err, ErrRateLimited)
+		}
+	})
+}
+
+func TestSource_tampered(t *testing.T) {
+	f := newTestForge(t)
+	f.release("v1.0.0", false, false, false, map[string]string{
+		binary("1.0.0"): "1.0.0",
+		"checksums.txt": remotetest.SHA256Hex("9.9.9") + "  " + binary("1.0.0") + "\n",
+	})
+
+	dir := remotetest.TempDir(t, "forge")
+
+	src := NewSource(NewClient(f.srv.URL+"/api", testRepo, ""), Stable)
+	if _, err := remote.Sync(context.Background(), src, dir, "0.1.0"); err == nil {
+		t.Error("Sync() error = nil, want a mismatch")
+	}
+}
+
+func Test_platformAsset(t *testing.T) {
+	tests := []struct {
+		name   string
+		assets []string
+		goos   string
+		goarch string
+		patch  bool
+		want   string
+	}{
+		{
+			name:   "goreleaser",
+			assets: []string{"checksums.txt", "app_1.2.0_Darwin_x86_64.tar.gz", "app_1.2.0_Linux_x86_64.tar.gz", "app_1.2.0_Linux_arm64.tar.gz"},
+			goos:   "linux",
+			goarch: "amd64",
+			want:   "app_1.2.0_Linux_x86_64.tar.gz",
+		},
+		{
+			name:   "binaries",
+			assets: []string{"app-linux-arm64", "app-linux-arm", "app-windows-amd64.exe"},
+			goos:   "linux",
+			goarch: "arm",
+			want:   "app-linux-arm",
+		},
+		{
+			name:   "windows",
+			assets: []string{"app-linux-amd64", "app-windows-amd64.exe"},
+			goos:   "windows",
+			goarch: "amd64",
+			want:   "app-windows-amd64.exe",
+		},
+		{
+			name:   "rust target",
+			assets: []string{"app-v1.2.0-x86_64-apple-darwin.tar.gz", "app-v1.2.0-aarch64-unknown-linux-gnu.tar.gz"},
+			goos:   "linux",
+			goarch: "arm64",
+			want:   "app-v1.2.0-aarch64-unknown-linux-gnu.tar.gz",
+		},
+		{
+			name:   "signatures and packages",
+			assets: []string{"app_linux_amd64.deb", "app_linux_amd64.sig", "app_linux_amd64.pem"},
+			goos:   "linux",
+			goarch: "amd64",
+		},
+		{
+			name:   "full with patch",
+			assets: []string{"app_1.2.0_linux_amd64.patch.tar.gz", "app_1.2.0_linux_amd64.tar.gz"},
+			goos:   "linux",
+			goarch: "amd64",
+			want:   "app_1.2.0_linux_amd64.tar.gz",
+		},
+		{
+			name:   "patch",
+			assets: []string{"app_1.2.0_linux_amd64.patch.tar.gz", "app_1.2.0_linux_amd64.tar.gz"},
+			goos:   "linux",
+			goarch: "amd64",
+			patch:  true,
+			want:   "app_1.2.0_linux_amd64.patch.tar.gz",
+		},
+		{
+			name:   "no 386 for amd64",
+			assets: []string{"app-linux-x86_64"},
+			goos:   "linux",
+			goarch: "386",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var assets []asset
+			for _, name := range tt.assets {
+				assets = append(assets, asset{Name: name})
+			}
+
+			got, ok := platformAsset(assets, tt.goos, tt.goarch, tt.patch)
+			if got.Name != tt.want || ok != (tt.want != "") {
+				t.Errorf("platformAsset() = %q, %v, want %q", got.Name, ok, tt.want)
+			}
+		})
+	}
+}
@@ -4,5 +4,6 @@ go 1.14
 
 require (
 	github.com/Masterminds/semver v1.5.0
+	github.com/fsnotify/fsnotify v1.4.9
 	go.uber.org/zap v1.15.0
 )
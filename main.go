@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/Masterminds/semver"
+	"github.com/xaxes/self-update/check"
+	"github.com/xaxes/self-update/config"
+	"github.com/xaxes/self-update/metrics"
+	"github.com/xaxes/self-update/systemd"
+	"github.com/xaxes/self-update/tracing"
+	"github.com/xaxes/self-update/upgrade"
 	"go.uber.org/zap"
 )
 
@@ -22,17 +31,43 @@ import (
 // See https://semver.org/.
 var Version = "unknown"
 
+var buildInfo = metrics.Default.NewGaugeVec(
+	"self_update_build_info",
+	"Always 1; labeled by the version of the running binary.",
+	"version",
+)
+
+// shutdownTimeout is the time in-flight requests have to finish
+// once a signal is caught.
+const shutdownTimeout = 5 * time.Second
+
 // UpgradeDir is the path to the directory containing upgradeable binaries.
 var UpgradeDir = ""
 
-func startUpgradeServer(server *http.Server, upgradeBind string) {
+// StateDir is the path to the directory the service keeps its state in,
+// such as extracted archives.
+var StateDir = ""
+
+// candidateDebounce is the time a binary in UpgradeDir has to stay
+// unchanged for before it is probed.
+const candidateDebounce = time.Second
+
+// candidates indexes the binaries and archives in UpgradeDir and the ones
+// downloaded from the remote source.
+var candidates check.Indexes
+
+func startUpgradeServer(inst *instance, upgradeBind string) {
 	tempRouter := http.NewServeMux()
 	tempServer := &http.Server{
 		Addr:    upgradeBind,
-		Handler: tempRouter,
+		Handler: tracing.Middleware(tempRouter),
 	}
 
-	tempRouter.HandleFunc("/replace", replaceHandler(tempServer, server))
+	_, handoff := tracing.Start(tracing.FromEnv(context.Background()), "main.handoff", tracing.KindInternal)
+
+	tempRouter.HandleFunc("/replace", replaceHandler(tempServer, inst, handoff))
+
+	inst.tracker.Transition(upgrade.StateHandoff, "")
 
 	go func() {
 		zap.L().Info("start", zap.String("bind", upgradeBind), zap.String("version", Version))
@@ -67,15 +102,61 @@ func setupLogger(dev bool) (func(), func()) {
 	}, zap.ReplaceGlobals(logger)
 }
 
+// cosignVerifier returns the verifier of the signatures of candidates,
+// or nil if no roots are given. Log entries are verified against the
+// Sigstore public-good log unless another key is given.
+func cosignVerifier(rootsPath, rekorKeyPath, identity, issuer string) (*check.Verifier, error) {
+	if rootsPath == "" {
+		if identity != "" || issuer != "" || rekorKeyPath != "" {
+			return nil, errors.New("-cosign-roots is required")
+		}
+		return nil, nil
+	}
+
+	roots, err := ioutil.ReadFile(rootsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rekorKey := []byte(check.PublicRekorKey)
+	if rekorKeyPath != "" {
+		if rekorKey, err = ioutil.ReadFile(rekorKeyPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return check.NewVerifier(roots, rekorKey, identity, issuer)
+}
+
 func main() {
 	bind := flag.String("bind", ":8080", "Host and port pair")
 	upgradeBind := flag.String("upgrade-bind", ":8081", "Defines temporary port used during upgrade process")
 	upgradeMode := flag.Bool("upgrade", false, "Used by the upgrade mechanism")
+	selfTestMode := flag.Bool("self-test", false, "Verify configuration, print JSON report and exit")
+	superviseMode := flag.Bool("supervise", false, "Run the service as a worker of a long-lived supervisor")
+	workerMode := flag.Bool("worker", false, "Used by the supervisor")
+	controlBind := flag.String("control-bind", ":8082", "Host and port pair of the supervisor's control endpoints")
 
 	version := flag.Bool("version", false, "Display version")
 	dev := flag.Bool("dev", false, "Development mode")
 
 	flag.StringVar(&UpgradeDir, "upgrade-dir", ".", "Directory with binaries intended for the upgrade.")
+	flag.BoolVar(&check.RequireSums, "require-sums", false, "Reject all upgrade candidates if -upgrade-dir has no SHA256SUMS file")
+	cosignRoots := flag.String("cosign-roots", "", "PEM file with the root and intermediate certificates signatures of upgrade candidates have to chain up to; signatures are not required if empty")
+	cosignRekorKey := flag.String("cosign-rekor-key", "", "PEM public key of the transparency log signatures have to be logged in (default the Sigstore public-good log)")
+	cosignIdentity := flag.String("cosign-identity", "", "Email address or URI the signing certificates have to be issued to")
+	cosignIssuer := flag.String("cosign-issuer", "", "OIDC issuer of the signing certificates")
+	policyPath := flag.String("provenance-policy", "", "JSON file with the provenance policy upgrade candidates have to comply with")
+	flag.StringVar(&StateDir, "state-dir", "", "Directory the service keeps its state in; defaults to .self-update in -upgrade-dir")
+	configPath := flag.String("config", "", "Path to the JSON configuration file")
+	flag.StringVar(&remoteS3.Bucket, "s3-bucket", "", "Bucket upgrade candidates are downloaded from; replaces the remote source of -config")
+	flag.StringVar(&remoteS3.Prefix, "s3-prefix", "", "Prefix of the keys of upgrade candidates in -s3-bucket")
+	flag.StringVar(&remoteS3.Endpoint, "s3-endpoint", "", "URL of S3-compatible storage, e.g. http://localhost:9000; Amazon S3 if empty")
+	flag.StringVar(&remoteS3.Region, "s3-region", "", "Region of -s3-bucket; us-east-1 if empty")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector receiving traces, e.g. http://localhost:4318")
+	traceFile := flag.String("trace-file", "", "File traces are appended to in the OTLP/JSON format")
+	flag.BoolVar(&upgrade.InPlace, "in-place", false, "Install upgrades over the running binary and restart it from there")
+	flag.StringVar(&upgrade.ChildLog, "upgrade-log", "", "File the output of the upgraded instance is appended to; inherits stdout and stderr if empty")
 
 	flag.Parse()
 
@@ -84,6 +165,10 @@ func main() {
 		return
 	}
 
+	if *selfTestMode {
+		os.Exit(selfTest(*bind, *upgradeBind, *configPath))
+	}
+
 	sync, undo := setupLogger(*dev)
 	defer sync()
 	defer undo()
@@ -93,32 +178,167 @@ func main() {
 		zap.L().Error("parse version", zap.Error(err))
 	}
 
-	router := http.NewServeMux()
-	server := &http.Server{
-		Addr:    *bind,
-		Handler: router,
+	buildInfo.With(Version).Set(1)
+
+	switch {
+	case *otlpEndpoint != "":
+		tracing.Setup(tracing.NewOTLPExporter(*otlpEndpoint), "self-update", Version)
+	case *traceFile != "":
+		tracing.Setup(&tracing.FileExporter{Path: *traceFile}, "self-update", Version)
+	}
+	defer tracing.Flush()
+
+	if upgrade.InPlace && *superviseMode {
+		zap.L().Fatal("-in-place cannot be combined with -supervise")
 	}
 
+	if upgrade.InPlace {
+		switch err := upgrade.Recover(); {
+		case errors.Is(err, upgrade.ErrRecovered):
+			// Passed sockets are not consumed yet, so the restarted
+			// process gets them too.
+			err = upgrade.Reexec()
+			zap.L().Fatal("restart restored binary", zap.Error(err))
+		case err != nil:
+			zap.L().Error("recover interrupted upgrade", zap.Error(err))
+		}
+	}
+
+	conf, err := config.NewReloadable(*configPath)
+	if err != nil {
+		zap.L().Fatal("load config", zap.Error(err))
+	}
+
+	if StateDir == "" {
+		StateDir = filepath.Join(UpgradeDir, ".self-update")
+	}
+
+	staging := filepath.Join(StateDir, "staging")
+	downloads := downloadDir()
+
+	verifier, err := cosignVerifier(*cosignRoots, *cosignRekorKey, *cosignIdentity, *cosignIssuer)
+	if err != nil {
+		zap.L().Fatal("signature verification", zap.Error(err))
+	}
+
+	var policy *check.Policy
+	if *policyPath != "" {
+		if policy, err = check.LoadPolicy(*policyPath, verifier); err != nil {
+			zap.L().Fatal("provenance policy", zap.Error(err))
+		}
+	}
+
+	// Each index prunes its own staging directory.
+	local, err := check.NewIndex(UpgradeDir, filepath.Join(staging, "local"), verifier, policy, candidateDebounce)
+	if err != nil {
+		zap.L().Fatal("index upgrade candidates", zap.Error(err))
+	}
+	candidates = append(candidates, local)
+	defer candidates.Close()
+
+	if err := os.MkdirAll(downloads, 0755); err != nil {
+		zap.L().Fatal("create downloads directory", zap.Error(err))
+	}
+
+	// The remote source downloads the signatures and the provenance next
+	// to the releases.
+	downloaded, err := check.NewIndex(downloads, filepath.Join(staging, "downloads"), verifier, policy, candidateDebounce)
+	if err != nil {
+		zap.L().Fatal("index downloaded candidates", zap.Error(err))
+	}
+	candidates = append(candidates, downloaded)
+
+	upgrade.InstanceArgs = []string{
+		"-upgrade-dir", UpgradeDir,
+		"-state-dir", StateDir,
+		"-require-sums=" + strconv.FormatBool(check.RequireSums),
+		"-cosign-roots", *cosignRoots,
+		"-cosign-rekor-key", *cosignRekorKey,
+		"-cosign-identity", *cosignIdentity,
+		"-cosign-issuer", *cosignIssuer,
+		"-provenance-policy", *policyPath,
+		"-config", *configPath,
+		"-s3-bucket", remoteS3.Bucket,
+		"-s3-prefix", remoteS3.Prefix,
+		"-s3-endpoint", remoteS3.Endpoint,
+		"-s3-region", remoteS3.Region,
+		"-otlp-endpoint", *otlpEndpoint,
+		"-trace-file", *traceFile,
+		"-upgrade-log", upgrade.ChildLog,
+		"-in-place=" + strconv.FormatBool(upgrade.InPlace),
+	}
+
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		zap.L().Fatal("socket activation", zap.Error(err))
+	}
+
+	if *superviseMode {
+		if err := supervise(listeners, *bind, *controlBind, *upgradeBind, conf); err != nil {
+			zap.L().Fatal("supervise", zap.Error(err))
+		}
+
+		return
+	}
+
+	// The first passed socket is used for the HTTP server; all of them
+	// are passed on to the upgraded instance.
+	var listener *os.File
+	if len(listeners) > 0 {
+		listener = listeners[0].File
+		upgrade.Listeners = listeners
+	}
+
+	router := http.NewServeMux()
+	inst := newInstance(*bind, tracing.Middleware(router), listener)
+
+	currentVersion := func() string { return Version }
+	start := startUpgrade(inst, *upgradeBind, *bind, conf)
+
 	router.HandleFunc("/", rootHandler)
-	router.HandleFunc("/check", checkHandler)
-	router.HandleFunc("/upgrade", upgradeHandler(server, *upgradeBind, *bind))
+	router.HandleFunc("/check", checkHandler(currentVersion))
+	if *workerMode {
+		// Upgrades are performed by the supervisor.
+		start = func(context.Context) error { return errSupervised }
+
+		router.Handle("/upgrade", controlProxy(*controlBind))
+		router.Handle("/upgrade/events", controlProxy(*controlBind))
+	} else {
+		router.HandleFunc("/upgrade", upgradeHandler(start))
+		router.HandleFunc("/upgrade/events", eventsHandler(inst.tracker))
+	}
+	router.Handle("/metrics", metrics.Handler())
 
 	if *upgradeMode {
-		startUpgradeServer(server, *upgradeBind)
+		startUpgradeServer(inst, *upgradeBind)
 	} else {
-		go func() {
-			zap.L().Info("start", zap.String("bind", *bind), zap.String("version", Version))
-			if err := server.ListenAndServe(); err != nil {
-				if !errors.Is(err, http.ErrServerClosed) {
-					zap.L().Fatal("listen and serve", zap.Error(err))
-				}
-			}
-		}()
+		if err := inst.serve(); err != nil {
+			zap.L().Fatal("listen", zap.Error(err))
+		}
+
+		notifyServing("started")
 	}
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	if upgrade.Reexecuted() {
+		// No other upgrade starts until the installed binary is
+		// confirmed or rolled back.
+		if err := inst.tracker.Begin(); err != nil {
+			zap.L().Fatal("confirm upgrade", zap.Error(err))
+		}
+		go confirmUpgrade(inst, conf.Config().HealthCheck(), *bind)
+	}
 
-	sig := <-sigs
-	zap.L().Info("catch signal", zap.Stringer("signal", sig))
+	if !*workerMode {
+		syncRemote(context.Background(), conf, downloads, currentVersion)
+		autoUpdate(context.Background(), conf, currentVersion, start)
+	}
+
+	handleSignals(start, conf, currentVersion)
+	notify(systemd.Stopping)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := inst.Server().Shutdown(ctx); err != nil {
+		zap.L().Error("shutdown", zap.Error(err))
+	}
 }
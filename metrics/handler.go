@@ -0,0 +1,21 @@
+package metrics
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// contentType is the content type of the text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns an HTTP handler exposing the Default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+
+		if err := Default.Write(w); err != nil {
+			zap.L().Error("write metrics", zap.Error(err))
+		}
+	})
+}
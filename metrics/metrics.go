@@ -0,0 +1,326 @@
+// Package metrics implements a minimal subset of Prometheus metric types
+// exposed in the text exposition format.
+//
+// See https://prometheus.io/docs/instrumenting/exposition_formats/.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry is a collection of metrics exposed together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*vec
+}
+
+// Default is the registry exposed by Handler.
+var Default = &Registry{}
+
+// DefBuckets are the default histogram buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type kind string
+
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+// vec is a metric family partitioned by label values.
+type vec struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a single time series of a family.
+type series struct {
+	labels []string
+
+	mu      sync.Mutex
+	value   float64
+	counts  []uint64 // histogram only, per bucket
+	count   uint64   // histogram only
+	buckets []float64
+}
+
+func (r *Registry) register(v *vec) *vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range r.metrics {
+		if m.name == v.name {
+			panic(fmt.Sprintf("metric %s registered twice", v.name))
+		}
+	}
+
+	r.metrics = append(r.metrics, v)
+	return v
+}
+
+func (v *vec) with(values ...string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: %d label values, want %d", v.name, len(values), len(v.labels)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{
+			labels:  append([]string(nil), values...),
+			buckets: v.buckets,
+			counts:  make([]uint64, len(v.buckets)),
+		}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+func (r *Registry) newVec(name, help string, k kind, buckets []float64, labels []string) *vec {
+	return r.register(&vec{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	})
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct{ v *vec }
+
+// Counter is a monotonically increasing value.
+type Counter struct{ s *series }
+
+// NewCounterVec registers a counter with `labels` in `r`.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.newVec(name, help, counterKind, nil, labels)}
+}
+
+// NewCounter registers a counter without labels in `r`.
+func (r *Registry) NewCounter(name, help string) Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// With returns the counter for the label values.
+func (c *CounterVec) With(values ...string) Counter {
+	return Counter{c.v.with(values...)}
+}
+
+// Inc increments the counter by 1.
+func (c Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by `delta`. Negative values are ignored.
+func (c Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	c.s.value += delta
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct{ v *vec }
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ s *series }
+
+// NewGaugeVec registers a gauge with `labels` in `r`.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.newVec(name, help, gaugeKind, nil, labels)}
+}
+
+// NewGauge registers a gauge without labels in `r`.
+func (r *Registry) NewGauge(name, help string) Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// With returns the gauge for the label values.
+func (g *GaugeVec) With(values ...string) Gauge {
+	return Gauge{g.v.with(values...)}
+}
+
+// Set sets the gauge to `value`.
+func (g Gauge) Set(value float64) {
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+
+	g.s.value = value
+}
+
+// SetToCurrentTime sets the gauge to the current Unix time in seconds.
+func (g Gauge) SetToCurrentTime() {
+	g.Set(float64(time.Now().UnixNano()) / 1e9)
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct{ v *vec }
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct{ s *series }
+
+// NewHistogramVec registers a histogram with `labels` in `r`.
+//
+// `buckets` are upper bounds in increasing order; nil means DefBuckets.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+
+	return &HistogramVec{r.newVec(name, help, histogramKind, buckets, labels)}
+}
+
+// NewHistogram registers a histogram without labels in `r`.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// With returns the histogram for the label values.
+func (h *HistogramVec) With(values ...string) Histogram {
+	return Histogram{h.v.with(values...)}
+}
+
+// Observe adds a single observation.
+func (h Histogram) Observe(value float64) {
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+
+	for i, b := range h.s.buckets {
+		if value <= b {
+			h.s.counts[i]++
+		}
+	}
+	h.s.count++
+	h.s.value += value
+}
+
+// ObserveDuration adds the duration in seconds as an observation.
+func (h Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// formatLabels returns `{name="value",...}` or an empty string.
+func formatLabels(names, values []string, extra ...string) string {
+	var pairs []string
+	for i := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, names[i], labelEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (v *vec) write(w io.Writer) error {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ss := make([]*series, 0, len(keys))
+	for _, k := range keys {
+		ss = append(ss, v.series[k])
+	}
+	v.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind); err != nil {
+		return err
+	}
+
+	for _, s := range ss {
+		if err := v.writeSeries(w, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *vec) writeSeries(w io.Writer, s *series) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v.kind != histogramKind {
+		_, err := fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labels), formatFloat(s.value))
+		return err
+	}
+
+	for i, b := range s.buckets {
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, s.labels, "le", formatFloat(b)), s.counts[i]); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, s.labels, "le", "+Inf"), s.count); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", v.name, formatLabels(v.labels, s.labels), formatFloat(s.value)); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "%s_count%s %d\n", v.name, formatLabels(v.labels, s.labels), s.count)
+	return err
+}
+
+// Write writes all metrics of `r` in the text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	ms := append([]*vec(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range ms {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(r *Registry)
+		want   string
+	}{
+		{
+			name:   "empty",
+			record: func(r *Registry) {},
+			want:   "",
+		},
+		{
+			name: "counter",
+			record: func(r *Registry) {
+				c := r.NewCounter("c_total", "Counter.")
+				c.Inc()
+				c.Add(2)
+				c.Add(-1)
+			},
+			want: "# HELP c_total Counter.\n# TYPE c_total counter\nc_total 3\n",
+		},
+		{
+			name: "labeled gauge",
+			record: func(r *Registry) {
+				g := r.NewGaugeVec("g", "Gauge.", "path")
+				g.With("/b").Set(2)
+				g.With(`/a"`).Set(1)
+			},
+			want: "# HELP g Gauge.\n# TYPE g gauge\ng{path=\"/a\\\"\"} 1\ng{path=\"/b\"} 2\n",
+		},
+		{
+			name: "histogram",
+			record: func(r *Registry) {
+				h := r.NewHistogram("h_seconds", "Histogram.", []float64{1, 5})
+				h.Observe(0.5)
+				h.Observe(3)
+				h.Observe(10)
+			},
+			want: "# HELP h_seconds Histogram.\n# TYPE h_seconds histogram\n" +
+				"h_seconds_bucket{le=\"1\"} 1\n" +
+				"h_seconds_bucket{le=\"5\"} 2\n" +
+				"h_seconds_bucket{le=\"+Inf\"} 3\n" +
+				"h_seconds_sum 13.5\n" +
+				"h_seconds_count 3\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Registry{}
+			tt.record(r)
+
+			var b bytes.Buffer
+			if err := r.Write(&b); err != nil {
+				t.Fatalf("Registry.Write() error = %v", err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("Registry.Write() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
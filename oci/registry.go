@@ -0,0 +1,410 @@
+// Package oci pulls binaries from repositories of OCI registries with
+// the distribution API.
+//
+// See https://github.com/opencontainers/distribution-spec.
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xaxes/self-update/remote"
+)
+
+// Media types of manifests.
+const (
+	mediaTypeManifest       = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeIndex          = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// Size limits of responses other than blobs.
+const (
+	maxManifestSize = 4 << 20
+	maxTagsSize     = 4 << 20
+	maxTokenSize    = 64 << 10
+)
+
+// maxTagPages limits the pages of tags fetched in one listing.
+const maxTagPages = 100
+
+// ErrDigest is returned for content which differs from its digest.
+var ErrDigest = errors.New("content does not match its digest")
+
+// descriptor describes content of a repository.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *platform         `json:"platform,omitempty"`
+}
+
+type platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// manifest is an image manifest or an index.
+type manifest struct {
+	MediaType    string       `json:"mediaType"`
+	ArtifactType string       `json:"artifactType,omitempty"`
+	Config       *descriptor  `json:"config,omitempty"`
+	Layers       []descriptor `json:"layers,omitempty"`
+	Manifests    []descriptor `json:"manifests,omitempty"`
+}
+
+// Client reads a repository of a registry.
+//
+// Registries requesting Basic or Bearer token authentication are passed
+// the username and the password, if set.
+type Client struct {
+	url      string
+	repo     string
+	username string
+	password string
+	http     *http.Client
+
+	mu    sync.Mutex
+	authz string // Authorization header
+}
+
+// NewClient returns a client of the repository `repo` of the registry at
+// `url`, e.g. https://registry.example.com.
+func NewClient(url, repo, username, password string) *Client {
+	return &Client{
+		url:      strings.TrimSuffix(url, "/"),
+		repo:     strings.Trim(repo, "/"),
+		username: username,
+		password: password,
+		// Blobs are binaries.
+		http: &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// get requests `path` of the repository, authenticating if requested.
+// A 2xx response is returned.
+func (c *Client) get(ctx context.Context, path string, accept ...string) (*http.Response, error) {
+	u := c.url + "/v2/" + c.repo + "/" + path
+	if strings.HasPrefix(path, "/") {
+		u = c.url + path
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+
+		c.mu.Lock()
+		if c.authz != "" {
+			req.Header.Set("Authorization", c.authz)
+		}
+		c.mu.Unlock()
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+
+			if err := c.authenticate(ctx, challenge); err != nil {
+				return nil, fmt.Errorf("authenticate: %w", err)
+			}
+			continue
+		}
+
+		if resp.StatusCode/100 != 2 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+		}
+
+		return resp, nil
+	}
+}
+
+// authenticate answers the `WWW-Authenticate` challenge.
+func (c *Client) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.username == "" {
+			return errors.New("registry requires credentials")
+		}
+
+		c.mu.Lock()
+		c.authz = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.username+":"+c.password))
+		c.mu.Unlock()
+
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported challenge %q", challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme == "" {
+		return fmt.Errorf("bad realm %q", params["realm"])
+	}
+
+	q := realm.Query()
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + c.repo + ":pull"
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token: %s", resp.Status)
+	}
+
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxTokenSize)).Decode(&t); err != nil {
+		return fmt.Errorf("token: %w", err)
+	}
+
+	token := t.Token
+	if token == "" {
+		token = t.AccessToken
+	}
+	if token == "" {
+		return errors.New("token: empty")
+	}
+
+	c.mu.Lock()
+	c.authz = "Bearer " + token
+	c.mu.Unlock()
+
+	return nil
+}
+
+// parseChallenge parses `Bearer realm="…",service="…",scope="…"`.
+func parseChallenge(s string) (string, map[string]string) {
+	params := make(map[string]string)
+
+	s = strings.TrimSpace(s)
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, params
+	}
+	scheme, s := s[:i], s[i+1:]
+
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				break
+			}
+			value, s = s[1:end+1], s[end+2:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+
+		params[key] = value
+	}
+
+	return scheme, params
+}
+
+// Tags lists the tags of the repository.
+func (c *Client) Tags(ctx context.Context) ([]string, error) {
+	var tags []string
+
+	path := "tags/list"
+	for page := 0; path != ""; page++ {
+		if page == maxTagPages {
+			return nil, fmt.Errorf("tags: more than %d pages", maxTagPages)
+		}
+
+		resp, err := c.get(ctx, path, "application/json")
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(io.LimitReader(resp.Body, maxTagsSize)).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("tags: %w", err)
+		}
+
+		tags = append(tags, list.Tags...)
+
+		// The link is the path of the next page, with the query.
+		path = ""
+		if next := remote.NextLink(resp.Header.Get("Link")); next != "" {
+			u, err := url.Parse(next)
+			if err != nil {
+				return nil, fmt.Errorf("tags: %w", err)
+			}
+			path = u.RequestURI()
+		}
+	}
+
+	return tags, nil
+}
+
+// Manifest returns the manifest or the index `ref`, a tag or a digest,
+// and its digest.
+func (c *Client) Manifest(ctx context.Context, ref string) (*manifest, string, error) {
+	resp, err := c.get(ctx, "manifests/"+ref, mediaTypeManifest, mediaTypeIndex, mediaTypeDockerManifest, mediaTypeDockerList)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(b) > maxManifestSize {
+		return nil, "", fmt.Errorf("manifest %s: exceeds %d bytes", ref, maxManifestSize)
+	}
+
+	sum := sha256.Sum256(b)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	// Content pulled by digest has to match it; the digest reported
+	// by the registry is checked otherwise.
+	want := resp.Header.Get("Docker-Content-Digest")
+	if strings.Contains(ref, ":") {
+		want = ref
+	}
+	if want != "" && want != digest {
+		return nil, "", fmt.Errorf("manifest %s: %w: %s", ref, ErrDigest, digest)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, "", fmt.Errorf("manifest %s: %w", ref, err)
+	}
+	if m.MediaType == "" {
+		m.MediaType = resp.Header.Get("Content-Type")
+	}
+
+	return &m, digest, nil
+}
+
+// Blob returns the blob `d`, which verifies its digest and size once
+// read to the end.
+func (c *Client) Blob(ctx context.Context, d descriptor) (io.ReadCloser, error) {
+	h, want, err := newDigester(d.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get(ctx, "blobs/"+d.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifyingReader{
+		r:    io.LimitReader(resp.Body, d.Size+1),
+		c:    resp.Body,
+		h:    h,
+		want: want,
+		size: d.Size,
+	}, nil
+}
+
+func newDigester(digest string) (hash.Hash, string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] != "sha256" {
+		return nil, "", fmt.Errorf("unsupported digest %q", digest)
+	}
+
+	return sha256.New(), parts[1], nil
+}
+
+// verifyingReader fails at the end of the content if it does not match
+// the digest or the size.
+type verifyingReader struct {
+	r    io.Reader
+	c    io.Closer
+	h    hash.Hash
+	want string
+	size int64
+	n    int64
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	v.n += int64(n)
+
+	if v.n > v.size {
+		return n, fmt.Errorf("blob: exceeds %d bytes", v.size)
+	}
+
+	if err == io.EOF {
+		if v.n != v.size {
+			return n, fmt.Errorf("blob: %d bytes, want %d", v.n, v.size)
+		}
+		if got := hex.EncodeToString(v.h.Sum(nil)); got != v.want {
+			return n, fmt.Errorf("blob: %w: sha256:%s", ErrDigest, got)
+		}
+	}
+
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.c.Close()
+}
@@ -0,0 +1,274 @@
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/xaxes/self-update/remote"
+	"go.uber.org/zap"
+)
+
+// annotationTitle names the file of a layer of an artifact.
+const annotationTitle = "org.opencontainers.image.title"
+
+var errNoBinary = errors.New("no binary")
+
+// Source provides the semver tags of a repository as releases.
+//
+// The binary is either the layer of an artifact pushed with e.g.
+// `oras push`, or a file at a known path in the layers of an image.
+type Source struct {
+	client *Client
+	path   string
+}
+
+// NewSource returns the source of the tags of `c`. The binary is read
+// from `binPath` in the image layers, or from the artifact if empty.
+func NewSource(c *Client, binPath string) *Source {
+	return &Source{client: c, path: strings.TrimPrefix(path.Clean("/"+binPath), "/")}
+}
+
+// Releases implements remote.Source.
+//
+// Their size and digest are resolved when a release is downloaded.
+func (s *Source) Releases(ctx context.Context) ([]remote.Release, error) {
+	tags, err := s.client.Tags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Base(s.client.repo)
+	if s.path != "" {
+		name = path.Base(s.path)
+	}
+
+	var rs []remote.Release
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			zap.L().Debug("oci: skip tag", zap.String("tag", tag), zap.Error(err))
+			continue
+		}
+
+		rs = append(rs, remote.Release{
+			Name:    name + "-" + v.String(),
+			Ref:     tag,
+			Version: v,
+		})
+	}
+
+	return rs, nil
+}
+
+// Resolve implements remote.Resolver. The release is pinned to the digest
+// of the manifest of its tag for the running platform.
+//
+// The size and the digest of artifacts are the ones of their layer; the
+// ones of binaries in image layers are unknown.
+func (s *Source) Resolve(ctx context.Context, r remote.Release) (remote.Release, error) {
+	m, digest, err := s.manifest(ctx, r.Ref)
+	if err != nil {
+		return remote.Release{}, err
+	}
+	r.Ref = digest
+
+	if s.path != "" {
+		return r, nil
+	}
+
+	l, err := artifactLayer(m, path.Base(s.client.repo))
+	if err != nil {
+		return remote.Release{}, fmt.Errorf("%s: %w", digest, err)
+	}
+
+	r.Size = l.Size
+	r.SHA256 = strings.TrimPrefix(l.Digest, "sha256:")
+	if title := l.Annotations[annotationTitle]; title != "" && title == path.Base(title) {
+		// Archives keep their extension.
+		if ext := archiveExt(title); ext != "" {
+			r.Name += ext
+		}
+	}
+
+	return r, nil
+}
+
+// Download implements remote.Source.
+func (s *Source) Download(ctx context.Context, r remote.Release, w io.Writer) error {
+	m, digest, err := s.manifest(ctx, r.Ref)
+	if err != nil {
+		return err
+	}
+
+	if s.path == "" {
+		l, err := artifactLayer(m, path.Base(s.client.repo))
+		if err != nil {
+			return fmt.Errorf("%s: %w", digest, err)
+		}
+
+		return s.copyBlob(ctx, l, w)
+	}
+
+	// The topmost layer with the file has its current contents.
+	for i := len(m.Layers) - 1; i >= 0; i-- {
+		found, err := s.extract(ctx, m.Layers[i], w)
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", m.Layers[i].Digest, err)
+		}
+		if found {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s: %w at %s", digest, errNoBinary, s.path)
+}
+
+// manifest returns the image manifest of `ref` for the running platform
+// and its digest.
+func (s *Source) manifest(ctx context.Context, ref string) (*manifest, string, error) {
+	m, digest, err := s.client.Manifest(ctx, ref)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if m.MediaType != mediaTypeIndex && m.MediaType != mediaTypeDockerList && len(m.Manifests) == 0 {
+		return m, digest, nil
+	}
+
+	for _, d := range m.Manifests {
+		if p := d.Platform; p != nil && p.OS == runtime.GOOS && p.Architecture == runtime.GOARCH {
+			return s.client.Manifest(ctx, d.Digest)
+		}
+	}
+
+	return nil, "", fmt.Errorf("%s: no manifest for %s/%s", digest, runtime.GOOS, runtime.GOARCH)
+}
+
+// artifactLayer returns the only layer of an artifact, or the one
+// titled `name`.
+func artifactLayer(m *manifest, name string) (descriptor, error) {
+	if len(m.Layers) == 1 {
+		return m.Layers[0], nil
+	}
+
+	for _, l := range m.Layers {
+		if l.Annotations[annotationTitle] == name {
+			return l, nil
+		}
+	}
+
+	return descriptor{}, fmt.Errorf("%w: %d layers, none titled %s", errNoBinary, len(m.Layers), name)
+}
+
+func archiveExt(name string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return ext
+		}
+	}
+
+	return ""
+}
+
+func (s *Source) copyBlob(ctx context.Context, d descriptor, w io.Writer) error {
+	b, err := s.client.Blob(ctx, d)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	_, err = io.Copy(w, b)
+	return err
+}
+
+// extract writes the binary in the image layer `d` to `w`. It reports
+// whether the layer has the binary; a deleted one is an error.
+func (s *Source) extract(ctx context.Context, d descriptor, w io.Writer) (bool, error) {
+	if !strings.Contains(d.MediaType, ".tar") {
+		return false, fmt.Errorf("unsupported media type %s", d.MediaType)
+	}
+
+	b, err := s.client.Blob(ctx, d)
+	if err != nil {
+		return false, err
+	}
+	defer b.Close()
+
+	var r io.Reader = b
+	if strings.HasSuffix(d.MediaType, "gzip") {
+		gz, err := gzip.NewReader(b)
+		if err != nil {
+			return false, err
+		}
+		r = gz
+	} else if strings.Contains(d.MediaType, "+") {
+		return false, fmt.Errorf("unsupported media type %s", d.MediaType)
+	}
+
+	dir, base := path.Split(s.path)
+	whiteout := dir + ".wh." + base
+
+	var found, deleted bool
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+h.Name), "/")
+		switch {
+		case name == whiteout || deletedDir(name, s.path):
+			deleted = true
+			continue
+		case name != s.path || found:
+			continue
+		case h.Typeflag != tar.TypeReg:
+			return false, fmt.Errorf("%s is not a regular file", s.path)
+		}
+
+		if _, err := io.Copy(w, tr); err != nil {
+			return false, err
+		}
+		found = true
+	}
+
+	// The digest is verified at the end of the blob.
+	if _, err := io.Copy(ioutil.Discard, b); err != nil {
+		return false, err
+	}
+
+	// Files are added after the whiteouts of their layer.
+	if !found && deleted {
+		return false, fmt.Errorf("%s deleted", s.path)
+	}
+
+	return found, nil
+}
+
+// deletedDir reports whether the whiteout `name` deletes a directory of
+// `file` or makes it opaque.
+func deletedDir(name, file string) bool {
+	dir, base := path.Split(name)
+	if !strings.HasPrefix(base, ".wh.") {
+		return false
+	}
+
+	if base == ".wh..wh..opq" {
+		return strings.HasPrefix(file, dir)
+	}
+
+	return strings.HasPrefix(file, dir+strings.TrimPrefix(base, ".wh.")+"/")
+}
@@ -0,0 +1,352 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xaxes/self-update/remote"
+	"github.com/xaxes/self-update/remote/remotetest"
+)
+
+const (
+	testRepo     = "infra/self-update"
+	testUser     = "ci"
+	testPassword = "secret"
+	testToken    = "token-123"
+)
+
+// testRegistry is a registry of a single repository requiring a bearer
+// token.
+type testRegistry struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	manifests map[string][]byte // by tag and digest
+	types     map[string]string // media types by digest
+	blobs     map[string][]byte // by digest
+	tags      []string
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		t:         t,
+		manifests: make(map[string][]byte),
+		types:     make(map[string]string),
+		blobs:     make(map[string][]byte),
+	}
+	r.srv = remotetest.NewServer(t, r.serve)
+
+	return r
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		user, password, ok := req.BasicAuth()
+		if !ok || user != testUser || password != testPassword || req.URL.Query().Get("scope") != "repository:"+testRepo+":pull" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"token": testToken})
+		return
+	}
+
+	if req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.srv.URL+`/token",service="registry",scope="repository:`+testRepo+`:pull"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	prefix := "/v2/" + testRepo + "/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.NotFound(w, req)
+		return
+	}
+	p := strings.TrimPrefix(req.URL.Path, prefix)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case p == "tags/list":
+		// Pages of two tags.
+		tags := r.tags
+		if last := req.URL.Query().Get("last"); last != "" {
+			i := sort.SearchStrings(tags, last)
+			tags = tags[i+1:]
+		}
+		if len(tags) > 2 {
+			tags = tags[:2]
+			w.Header().Set("Link", `<`+prefix+`tags/list?n=2&last=`+tags[1]+`>; rel="next"`)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": testRepo, "tags": tags})
+	case strings.HasPrefix(p, "manifests/"):
+		b, ok := r.manifests[strings.TrimPrefix(p, "manifests/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digestOf(b))
+		w.Header().Set("Content-Type", r.types[digestOf(b)])
+		w.Write(b)
+	case strings.HasPrefix(p, "blobs/"):
+		b, ok := r.blobs[strings.TrimPrefix(p, "blobs/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(b)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func digestOf(b []byte) string {
+	return "sha256:" + remotetest.SHA256Hex(string(b))
+}
+
+// blob stores `b` and returns its descriptor.
+func (r *testRegistry) blob(mediaType string, b []byte, annotations map[string]string) descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := descriptor{MediaType: mediaType, Digest: digestOf(b), Size: int64(len(b)), Annotations: annotations}
+	r.blobs[d.Digest] = b
+
+	return d
+}
+
+// manifest stores `m` under its digest and `tag`, if not empty, and
+// returns its descriptor.
+func (r *testRegistry) manifest(tag string, m manifest) descriptor {
+	r.t.Helper()
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := descriptor{MediaType: m.MediaType, Digest: digestOf(b), Size: int64(len(b))}
+	r.manifests[d.Digest] = b
+	r.types[d.Digest] = m.MediaType
+	if tag != "" {
+		r.manifests[tag] = b
+		r.tags = append(r.tags, tag)
+		sort.Strings(r.tags)
+	}
+
+	return d
+}
+
+// artifact pushes `content` like `oras push <repo>:<tag> self-update`.
+func (r *testRegistry) artifact(tag string, content []byte) {
+	config := r.blob("application/vnd.oci.empty.v1+json", []byte("{}"), nil)
+	layer := r.blob("application/octet-stream", content, map[string]string{annotationTitle: "self-update"})
+
+	r.manifest(tag, manifest{
+		MediaType:    mediaTypeManifest,
+		ArtifactType: "application/vnd.example.self-update",
+		Config:       &config,
+		Layers:       []descriptor{layer},
+	})
+}
+
+// layer returns a gzipped tar layer of `files`, pairs of names and
+// contents.
+func layer(t *testing.T, files ...[2]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		h := &tar.Header{Name: f[0], Mode: 0755, Size: int64(len(f[1])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// image pushes a multi-platform image whose layers are `layers`, bottom
+// first.
+func (r *testRegistry) image(tag string, layers ...[]byte) {
+	config := r.blob("application/vnd.oci.image.config.v1+json", []byte(`{"architecture":"`+runtime.GOARCH+`","os":"`+runtime.GOOS+`"}`), nil)
+
+	m := manifest{MediaType: mediaTypeManifest, Config: &config}
+	for _, l := range layers {
+		m.Layers = append(m.Layers, r.blob("application/vnd.oci.image.layer.v1.tar+gzip", l, nil))
+	}
+
+	other := r.manifest("", manifest{MediaType: mediaTypeManifest, Config: &config})
+	other.Platform = &platform{OS: "plan9", Architecture: "386"}
+
+	d := r.manifest("", m)
+	d.Platform = &platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+
+	r.manifest(tag, manifest{MediaType: mediaTypeIndex, Manifests: []descriptor{other, d}})
+}
+
+func TestSource_artifact(t *testing.T) {
+	r := newTestRegistry(t)
+
+	r.artifact("v1.1.0", []byte("1.1.0"))
+	r.artifact("v1.2.0", []byte("1.2.0"))
+	r.artifact("1.0.0", []byte("1.0.0"))
+	r.artifact("latest", []byte("1.2.0"))
+
+	dir := remotetest.TempDir(t, "oci")
+
+	src := NewSource(NewClient(r.srv.URL, testRepo, testUser, testPassword), "")
+
+	rel, err := remote.Sync(context.Background(), src, dir, "1.0.0")
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if rel.Name != "self-update-1.2.0" {
+		t.Errorf("Sync() = %s, want self-update-1.2.0", rel.Name)
+	}
+
+	t.Run("tampered", func(t *testing.T) {
+		r.artifact("v1.3.0", []byte("1.3.0"))
+		for d, b := range r.blobs {
+			if string(b) == "1.3.0" {
+				r.blobs[d] = []byte("9.9.9")
+			}
+		}
+
+		_, err := remote.Sync(context.Background(), src, dir, "1.0.0")
+		if !errors.Is(err, ErrDigest) {
+			t.Errorf("Sync() error = %v, want %v", err, ErrDigest)
+		}
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		src := NewSource(NewClient(r.srv.URL, testRepo, testUser, "wrong"), "")
+		if _, err := src.Releases(context.Background()); err == nil {
+			t.Error("Releases() error = nil, want an error")
+		}
+	})
+}
+
+func TestSource_image(t *testing.T) {
+	const bin = "usr/local/bin/self-update"
+
+	tests := []struct {
+		name    string
+		layers  [][]byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "lower layer",
+			layers: [][]byte{
+				layer(t, [2]string{"./" + bin, "1.2.0"}),
+				layer(t, [2]string{"etc/self-update.json", "{}"}),
+			},
+			want: "1.2.0",
+		},
+		{
+			name: "replaced",
+			layers: [][]byte{
+				layer(t, [2]string{bin, "1.1.0"}),
+				layer(t, [2]string{bin, "1.2.0"}),
+			},
+			want: "1.2.0",
+		},
+		{
+			name: "deleted",
+			layers: [][]byte{
+				layer(t, [2]string{bin, "1.2.0"}),
+				layer(t, [2]string{"usr/local/bin/.wh.self-update", ""}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "opaque directory",
+			layers: [][]byte{
+				layer(t, [2]string{bin, "1.1.0"}),
+				layer(t, [2]string{"usr/local/.wh..wh..opq", ""}, [2]string{bin, "1.2.0"}),
+			},
+			want: "1.2.0",
+		},
+		{
+			name:    "missing",
+			layers:  [][]byte{layer(t, [2]string{"bin/sh", ""})},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(t)
+
+			r.image("v1.2.0", tt.layers...)
+
+			src := NewSource(NewClient(r.srv.URL, testRepo, testUser, testPassword), "/"+bin)
+
+			rs, err := src.Releases(context.Background())
+			if err != nil {
+				t.Fatalf("Releases() error = %v", err)
+			}
+			if len(rs) != 1 || rs[0].Name != "self-update-1.2.0" {
+				t.Fatalf("Releases() = %v, want self-update-1.2.0", rs)
+			}
+
+			rel, err := src.Resolve(context.Background(), rs[0])
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if !strings.HasPrefix(rel.Ref, "sha256:") {
+				t.Errorf("Resolve() Ref = %s, want a digest", rel.Ref)
+			}
+
+			var buf bytes.Buffer
+			err = src.Download(context.Background(), rel, &buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Download() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && buf.String() != tt.want {
+				t.Errorf("Download() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull,push"`)
+
+	want := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:a/b:pull,push",
+	}
+	if scheme != "Bearer" || !reflect.DeepEqual(params, want) {
+		t.Errorf("parseChallenge() = %s, %v, want Bearer, %v", scheme, params, want)
+	}
+}
@@ -0,0 +1,24 @@
+package remote
+
+import "strings"
+
+// NextLink returns the target of the `rel="next"` link of a `Link`
+// header, e.g. `<https://api.github.com/…?page=2>; rel="next", <…>;
+// rel="last"`, as sources paginate their listings.
+func NextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return target[1 : len(target)-1]
+			}
+		}
+	}
+
+	return ""
+}
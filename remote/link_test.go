@@ -0,0 +1,30 @@
+package remote
+
+import "testing"
+
+func TestNextLink(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{
+			header: `<https://api.github.com/repositories/1/releases?page=2>; rel="next", <https://api.github.com/repositories/1/releases?page=5>; rel="last"`,
+			want:   "https://api.github.com/repositories/1/releases?page=2",
+		},
+		{
+			header: `<https://api.github.com/repositories/1/releases?page=1>; rel="prev", <https://api.github.com/repositories/1/releases?page=1>; rel="first"`,
+		},
+		{
+			header: `</v2/a/b/tags/list?n=2&last=v1.2.0>; rel="next"`,
+			want:   "/v2/a/b/tags/list?n=2&last=v1.2.0",
+		},
+		{},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := NextLink(tt.header); got != tt.want {
+				t.Errorf("NextLink() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,451 @@
+// Package remote downloads upgrade candidates from remote sources into
+// a local directory, which is indexed like the upgrade directory.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/xaxes/self-update/check"
+	"github.com/xaxes/self-update/upgrade"
+	"go.uber.org/zap"
+)
+
+// ErrNoRelease is returned if a source has no release newer than the
+// running one.
+var ErrNoRelease = errors.New("no newer release")
+
+// Release is an artifact published by a source: a binary or an archive.
+type Release struct {
+	Name    string // Name of the downloaded file
+	Ref     string // Source-specific reference, e.g. the URL
+	Version *semver.Version
+	Size    int64  // Expected size, unchecked if 0
+	SHA256  string // Expected digest, unchecked if empty
+}
+
+// Source lists and downloads releases.
+type Source interface {
+	// Releases returns the releases available at the source.
+	Releases(ctx context.Context) ([]Release, error)
+	// Download writes the contents of `r` to `w`.
+	Download(ctx context.Context, r Release, w io.Writer) error
+}
+
+// Resolver is implemented by sources which look up the details of a
+// release, such as its size and digest, only before it is downloaded.
+type Resolver interface {
+	// Resolve returns `r` with its details.
+	Resolve(ctx context.Context, r Release) (Release, error)
+}
+
+// Companions are the extensions of the files published next to a
+// release to verify it: its signature, see check.Verifier, and its
+// provenance, see check.Policy.
+var Companions = []string{".bundle", ".sig", ".pem", ".intoto.jsonl"}
+
+// maxCompanionSize is the size limit of a companion file.
+const maxCompanionSize = 1 << 20
+
+// CompanionSource is implemented by sources which publish files next to
+// their releases, named after the release with one of Companions
+// appended, e.g. app-1.2.0.bundle.
+type CompanionSource interface {
+	// Companions returns the companion files of `r`.
+	Companions(ctx context.Context, r Release) ([]Release, error)
+}
+
+// Sync downloads the newest release of `src` newer than `currVersion`
+// into `dir` and returns it.
+//
+// The download is verified against the size and the digest of the
+// release and listed in the SHA256SUMS of `dir`, so it is not tampered
+// with afterwards. Previous downloads are removed.
+//
+// Of several releases of the newest version, patch archives are
+// preferred (see check.IsPatch). If the patch does not apply to the
+// running binary, another release of the version is downloaded instead.
+//
+// The companion files of a release are downloaded before it, so it is
+// verified as soon as it appears.
+func Sync(ctx context.Context, src Source, dir, currVersion string) (Release, error) {
+	curr, err := semver.NewVersion(currVersion)
+	if err != nil {
+		return Release{}, err
+	}
+
+	rs, err := src.Releases(ctx)
+	if err != nil {
+		return Release{}, err
+	}
+
+	var newer []Release
+	for _, r := range rs {
+		if r.Version.GreaterThan(curr) {
+			newer = append(newer, r)
+		}
+	}
+	if len(newer) == 0 {
+		return Release{}, ErrNoRelease
+	}
+
+	sort.SliceStable(newer, func(a, b int) bool { return newer[a].Version.LessThan(newer[b].Version) })
+
+	// The releases of the newest version, the last listed first.
+	var newest []Release
+	for i := len(newer) - 1; i >= 0 && newer[i].Version.Equal(newer[len(newer)-1].Version); i-- {
+		newest = append(newest, newer[i])
+	}
+
+	// A release downloaded before is kept, e.g. the full one after its
+	// patch did not apply; otherwise patches go first.
+	listed := listedName(dir)
+	rank := func(r Release) int {
+		switch {
+		case r.Name == listed:
+			return 0
+		case check.IsPatch(r.Name):
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(newest, func(a, b int) bool { return rank(newest[a]) < rank(newest[b]) })
+
+	for _, r := range newest {
+		r, err = fetch(ctx, src, dir, r)
+		if errors.Is(err, check.ErrPatchBase) {
+			zap.L().Info("skip patch of another binary", zap.String("name", r.Name), zap.Error(err))
+			continue
+		}
+		if err != nil {
+			return Release{}, err
+		}
+
+		if err := prune(dir, r.Name); err != nil {
+			zap.L().Error("remove previous downloads", zap.String("dir", dir), zap.Error(err))
+		}
+
+		return r, nil
+	}
+
+	return Release{}, err
+}
+
+// fetch downloads `r` of `src` into `dir` unless it was downloaded
+// before, and returns it with its digest.
+//
+// Patches of another binary than the running one are removed and
+// reported as check.ErrPatchBase.
+func fetch(ctx context.Context, src Source, dir string, r Release) (Release, error) {
+	if res, ok := src.(Resolver); ok {
+		resolved, err := res.Resolve(ctx, r)
+		if err != nil {
+			return r, fmt.Errorf("resolve %s: %w", r.Name, err)
+		}
+		r = resolved
+	}
+
+	if r.Name == "" || r.Name != filepath.Base(r.Name) || strings.HasPrefix(r.Name, ".") || r.Name == check.SumsName {
+		return r, fmt.Errorf("release %s: invalid name %q", r.Version, r.Name)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return r, err
+	}
+
+	comps, err := companions(ctx, src, r)
+	if err != nil {
+		return r, fmt.Errorf("companions of %s: %w", r.Name, err)
+	}
+
+	path := filepath.Join(dir, r.Name)
+	done := r.SHA256 != "" && downloaded(dir, r)
+	if done && present(dir, comps) {
+		return r, nil
+	}
+
+	for _, c := range comps {
+		if err := downloadCompanion(ctx, src, c, filepath.Join(dir, c.Name)); err != nil {
+			return r, fmt.Errorf("download %s: %w", c.Name, err)
+		}
+	}
+	if done {
+		return r, nil
+	}
+
+	zap.L().Info("download release", zap.String("name", r.Name), zap.String("ref", r.Ref), zap.Stringer("version", r.Version))
+
+	hash, err := download(ctx, src, r, path)
+	if err != nil {
+		return r, fmt.Errorf("download %s: %w", r.Name, err)
+	}
+	r.SHA256 = hash
+
+	if check.IsPatch(r.Name) {
+		if err := check.CheckPatch(path); err != nil {
+			for _, name := range append([]string{r.Name}, companionNames(r.Name)...) {
+				p := filepath.Join(dir, name)
+				if rmErr := os.Remove(p); rmErr != nil && !os.IsNotExist(rmErr) {
+					zap.L().Error("remove patch", zap.String("path", p), zap.Error(rmErr))
+				}
+			}
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// companions returns the companion files `src` publishes next to `r`.
+func companions(ctx context.Context, src Source, r Release) ([]Release, error) {
+	cs, ok := src.(CompanionSource)
+	if !ok {
+		return nil, nil
+	}
+
+	list, err := cs.Companions(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	names := companionNames(r.Name)
+
+	var comps []Release
+	for _, c := range list {
+		if !contains(names, c.Name) {
+			zap.L().Debug("skip companion", zap.String("release", r.Name), zap.String("name", c.Name))
+			continue
+		}
+		comps = append(comps, c)
+	}
+
+	return comps, nil
+}
+
+// companionNames returns the names of the companion files of `name`.
+func companionNames(name string) []string {
+	names := make([]string, 0, len(Companions))
+	for _, ext := range Companions {
+		names = append(names, name+ext)
+	}
+
+	return names
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// present reports whether the companion files `comps` are in `dir`.
+func present(dir string, comps []Release) bool {
+	for _, c := range comps {
+		if _, err := os.Stat(filepath.Join(dir, c.Name)); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// listedName returns the name of the download listed in the SHA256SUMS
+// of `dir`, if any.
+func listedName(dir string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, check.SumsName))
+	if err != nil {
+		return ""
+	}
+
+	sums, err := check.ParseSums(b)
+	if err != nil {
+		return ""
+	}
+
+	for name := range sums {
+		return name
+	}
+
+	return ""
+}
+
+// downloaded reports whether `r` was downloaded into `dir` before.
+func downloaded(dir string, r Release) bool {
+	b, err := ioutil.ReadFile(filepath.Join(dir, check.SumsName))
+	if err != nil {
+		return false
+	}
+
+	if string(b) != sumsLine(r.SHA256, r.Name) {
+		return false
+	}
+
+	_, err = os.Stat(filepath.Join(dir, r.Name))
+	return err == nil
+}
+
+func sumsLine(hash, name string) string {
+	return hash + "  " + name + "\n"
+}
+
+// download writes `r` to `path` and returns its SHA-256.
+func download(ctx context.Context, src Source, r Release, path string) (string, error) {
+	dir := filepath.Dir(path)
+
+	// The partial file is not executable, so it is not probed.
+	f, err := ioutil.TempFile(dir, "."+r.Name+".part-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(f, h)}
+	if err := src.Download(ctx, r, cw); err != nil {
+		f.Close()
+		return "", err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+	if r.Size > 0 && cw.n != r.Size {
+		return "", fmt.Errorf("size %d, want %d", cw.n, r.Size)
+	}
+	if r.SHA256 != "" && !strings.EqualFold(hash, r.SHA256) {
+		return "", fmt.Errorf("SHA-256 %s, want %s", hash, r.SHA256)
+	}
+
+	if err := os.Chmod(f.Name(), 0755); err != nil {
+		return "", err
+	}
+
+	// The digest is listed before the file appears, so it is never
+	// a candidate unverified.
+	if err := writeFile(filepath.Join(dir, check.SumsName), []byte(sumsLine(hash, r.Name))); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// downloadCompanion writes the companion file `r` to `path`.
+func downloadCompanion(ctx context.Context, src Source, r Release, path string) error {
+	var buf bytes.Buffer
+	if err := src.Download(ctx, r, &limitedWriter{w: &buf, n: maxCompanionSize}); err != nil {
+		return err
+	}
+
+	if r.Size > 0 && int64(buf.Len()) != r.Size {
+		return fmt.Errorf("size %d, want %d", buf.Len(), r.Size)
+	}
+	if r.SHA256 != "" {
+		sum := sha256.Sum256(buf.Bytes())
+		if hash := hex.EncodeToString(sum[:]); !strings.EqualFold(hash, r.SHA256) {
+			return fmt.Errorf("SHA-256 %s, want %s", hash, r.SHA256)
+		}
+	}
+
+	return writeFile(path, buf.Bytes())
+}
+
+// writeFile replaces the file at `path` atomically.
+func writeFile(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// prune removes the files in `dir` other than `keep`, its companion
+// files and SHA256SUMS.
+//
+// The running binary is kept, as it may have been started from there.
+func prune(dir, keep string) error {
+	comps := companionNames(keep)
+
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	exe, _ := upgrade.Executable()
+
+	for _, f := range fs {
+		path := filepath.Join(dir, f.Name())
+		if f.IsDir() || f.Name() == keep || contains(comps, f.Name()) || f.Name() == check.SumsName || path == exe {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var errTooLarge = errors.New("too large")
+
+// limitedWriter fails writes beyond `n` bytes.
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, errTooLarge
+	}
+
+	n, err := l.w.Write(p)
+	l.n -= int64(n)
+	return n, err
+}
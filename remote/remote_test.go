@@ -0,0 +1,324 @@
+package remote
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/xaxes/self-update/check"
+)
+
+// fakeSource serves releases from memory and counts downloads.
+type fakeSource struct {
+	releases  []Release
+	contents  map[string]string // by name
+	downloads int
+}
+
+func (f *fakeSource) Releases(ctx context.Context) ([]Release, error) {
+	return f.releases, nil
+}
+
+func (f *fakeSource) Download(ctx context.Context, r Release, w io.Writer) error {
+	f.downloads++
+	_, err := io.WriteString(w, f.contents[r.Name])
+	return err
+}
+
+func release(name, version, content string) Release {
+	sum := sha256.Sum256([]byte(content))
+	return Release{
+		Name:    name,
+		Version: semver.MustParse(version),
+		Size:    int64(len(content)),
+		SHA256:  hex.EncodeToString(sum[:]),
+	}
+}
+
+func names(t *testing.T, dir string) []string {
+	t.Helper()
+
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ns []string
+	for _, f := range fs {
+		ns = append(ns, f.Name())
+	}
+	sort.Strings(ns)
+
+	return ns
+}
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := &fakeSource{
+		releases: []Release{
+			release("app-1.1.0", "1.1.0", "one"),
+			release("app-1.2.0", "1.2.0", "two"),
+			release("app-0.9.0", "0.9.0", "old"),
+		},
+		contents: map[string]string{"app-1.1.0": "one", "app-1.2.0": "two", "app-0.9.0": "old"},
+	}
+
+	got, err := Sync(context.Background(), src, dir, "1.0.0")
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got.Name != "app-1.2.0" {
+		t.Errorf("Sync() = %s, want app-1.2.0", got.Name)
+	}
+
+	sums, err := ioutil.ReadFile(filepath.Join(dir, check.SumsName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sumsLine(got.SHA256, "app-1.2.0"); string(sums) != want {
+		t.Errorf("%s = %q, want %q", check.SumsName, sums, want)
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "app-1.2.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&0111 == 0 {
+		t.Errorf("download mode = %v, want executable", fi.Mode())
+	}
+
+	t.Run("downloaded before", func(t *testing.T) {
+		before := src.downloads
+		if _, err := Sync(context.Background(), src, dir, "1.0.0"); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+		if src.downloads != before {
+			t.Errorf("Sync() downloaded the release again")
+		}
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		src.releases = append(src.releases, release("app-1.3.0", "1.3.0", "three"))
+		src.contents["app-1.3.0"] = "tampered"
+
+		if _, err := Sync(context.Background(), src, dir, "1.0.0"); err == nil {
+			t.Fatal("Sync() error = nil, want a size mismatch")
+		}
+
+		want := []string{check.SumsName, "app-1.2.0"}
+		if got := names(t, dir); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("files = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("previous downloads removed", func(t *testing.T) {
+		src.contents["app-1.3.0"] = "three"
+
+		if _, err := Sync(context.Background(), src, dir, "1.0.0"); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+
+		want := []string{check.SumsName, "app-1.3.0"}
+		if got := names(t, dir); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("files = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("no newer release", func(t *testing.T) {
+		if _, err := Sync(context.Background(), src, dir, "1.3.0"); err != ErrNoRelease {
+			t.Errorf("Sync() error = %v, want %v", err, ErrNoRelease)
+		}
+	})
+}
+
+// patchArchive returns a tar.gz archive with a patch of the binary with
+// `base` SHA-256.
+func patchArchive(t *testing.T, base string) string {
+	t.Helper()
+
+	manifest := fmt.Sprintf(`{"version": "1.2.0", "patch": "app.bsdiff", "base_sha256": %q, "sha256": %q}`, base, base)
+
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+	for name, body := range map[string]string{"manifest.json": manifest, "app.bsdiff": "patch"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.String()
+}
+
+func TestSync_patch(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b)
+
+	tests := []struct {
+		name          string
+		base          string
+		want          string
+		wantDownloads int
+	}{
+		{name: "applies", base: hex.EncodeToString(sum[:]), want: "app-1.2.0.patch.tar.gz", wantDownloads: 1},
+		{name: "another base", base: strings.Repeat("0", 64), want: "app-1.2.0", wantDownloads: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "remote")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			patch := patchArchive(t, tt.base)
+			src := &fakeSource{
+				releases: []Release{
+					release("app-1.2.0", "1.2.0", "full"),
+					release("app-1.2.0.patch.tar.gz", "1.2.0", patch),
+				},
+				contents: map[string]string{"app-1.2.0": "full", "app-1.2.0.patch.tar.gz": patch},
+			}
+
+			for i := 0; i < 2; i++ {
+				got, err := Sync(context.Background(), src, dir, "1.0.0")
+				if err != nil {
+					t.Fatalf("Sync() error = %v", err)
+				}
+				if got.Name != tt.want {
+					t.Errorf("Sync() = %s, want %s", got.Name, tt.want)
+				}
+			}
+
+			if got := names(t, dir); len(got) != 2 || got[1] != tt.want {
+				t.Errorf("files = %v, want %s and %s", got, check.SumsName, tt.want)
+			}
+
+			// The patch is downloaded once, and the full release
+			// only if the patch does not apply.
+			if src.downloads != tt.wantDownloads {
+				t.Errorf("downloaded %d times, want %d", src.downloads, tt.wantDownloads)
+			}
+		})
+	}
+}
+
+// companionSource publishes companion files of its releases, listed
+// with the digest of their published contents.
+type companionSource struct {
+	*fakeSource
+	companions map[string]string // published contents by name
+}
+
+func (c companionSource) Companions(ctx context.Context, r Release) ([]Release, error) {
+	var comps []Release
+	for name, content := range c.companions {
+		if strings.HasPrefix(name, r.Name+".") {
+			comps = append(comps, release(name, r.Version.String(), content))
+		}
+	}
+
+	return comps, nil
+}
+
+func TestSync_companions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := companionSource{
+		fakeSource: &fakeSource{
+			releases: []Release{release("app-1.2.0", "1.2.0", "two")},
+			contents: map[string]string{
+				"app-1.2.0":              "two",
+				"app-1.2.0.bundle":       "bundle",
+				"app-1.2.0.intoto.jsonl": "provenance",
+				"app-1.2.0.txt":          "notes",
+			},
+		},
+		companions: map[string]string{
+			"app-1.2.0.bundle":       "bundle",
+			"app-1.2.0.intoto.jsonl": "provenance",
+			"app-1.2.0.txt":          "notes",
+		},
+	}
+
+	if _, err := Sync(context.Background(), src, dir, "1.0.0"); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	want := []string{check.SumsName, "app-1.2.0", "app-1.2.0.bundle", "app-1.2.0.intoto.jsonl"}
+	if got := names(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+
+	t.Run("missing companion", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(dir, "app-1.2.0.bundle")); err != nil {
+			t.Fatal(err)
+		}
+
+		before := src.downloads
+		if _, err := Sync(context.Background(), src, dir, "1.0.0"); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+
+		if got := names(t, dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("files = %v, want %v", got, want)
+		}
+		// The companions are downloaded again, the release is not.
+		if got := src.downloads - before; got != 2 {
+			t.Errorf("downloaded %d files, want 2", got)
+		}
+	})
+
+	t.Run("tampered companion", func(t *testing.T) {
+		src.releases = append(src.releases, release("app-1.3.0", "1.3.0", "three"))
+		src.contents["app-1.3.0"] = "three"
+		src.contents["app-1.3.0.sig"] = "tampered"
+		src.companions["app-1.3.0.sig"] = "sig"
+
+		if _, err := Sync(context.Background(), src, dir, "1.0.0"); err == nil {
+			t.Fatal("Sync() error = nil, want a digest mismatch")
+		}
+
+		if got := names(t, dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("files = %v, want %v", got, want)
+		}
+	})
+}
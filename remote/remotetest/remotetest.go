@@ -0,0 +1,41 @@
+// Package remotetest provides fixtures shared by the tests of remote
+// sources.
+package remotetest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// NewServer starts a server of `h` which is closed when the test ends.
+func NewServer(t *testing.T, h http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// TempDir creates a directory for downloads which is removed when the
+// test ends.
+func TempDir(t *testing.T, prefix string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+// SHA256Hex returns the hex-encoded SHA-256 of `s`.
+func SHA256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
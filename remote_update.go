@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/xaxes/self-update/config"
+	"github.com/xaxes/self-update/remote"
+	"go.uber.org/zap"
+)
+
+// defaultRemoteInterval is the time between consecutive downloads from
+// the remote source if not configured.
+const defaultRemoteInterval = 15 * time.Minute
+
+// downloadDir returns the directory releases of the remote source are
+// downloaded into.
+func downloadDir() string {
+	return filepath.Join(StateDir, "downloads")
+}
+
+// remoteS3 is the bucket set with the `-s3-*` flags. It replaces the
+// remote source of the configuration if set.
+var remoteS3 config.S3
+
+// syncRemote downloads the newest release of the configured remote
+// source into `dir` in background, until `ctx` is done.
+func syncRemote(ctx context.Context, conf *config.Reloadable, dir string, version func() string) {
+	go func() {
+		var src remoteSource
+		for {
+			r := conf.Config().Remote
+			syncOnce(ctx, &src, r, dir, version())
+
+			interval := time.Duration(r.Interval)
+			if interval <= 0 {
+				interval = defaultRemoteInterval
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// remoteSource is the source of a remote configuration. It is built
+// again only if the configuration changes, so the source keeps its
+// state between syncs, e.g. the ETags of the listings of a forge.
+type remoteSource struct {
+	conf  config.Remote
+	src   remote.Source
+	built bool
+}
+
+// get returns the source of `r`.
+func (s *remoteSource) get(r config.Remote) (remote.Source, error) {
+	if remoteS3.Bucket != "" {
+		s3 := remoteS3
+		r = config.Remote{S3: &s3}
+	}
+	r.Interval = 0
+
+	if s.built && reflect.DeepEqual(r, s.conf) {
+		return s.src, nil
+	}
+
+	src, err := r.Source(StateDir)
+	if err != nil {
+		return nil, err
+	}
+	s.conf, s.src, s.built = r, src, true
+
+	return src, nil
+}
+
+func syncOnce(ctx context.Context, s *remoteSource, r config.Remote, dir, version string) {
+	src, err := s.get(r)
+	if err != nil {
+		zap.L().Error("remote source", zap.Error(err))
+		return
+	}
+	if src == nil {
+		return
+	}
+
+	rel, err := remote.Sync(ctx, src, dir, version)
+	switch {
+	case errors.Is(err, remote.ErrNoRelease):
+		zap.L().Debug("sync remote source", zap.Error(err))
+	case err != nil:
+		zap.L().Error("sync remote source", zap.Error(err))
+	default:
+		zap.L().Info("sync remote source", zap.String("release", rel.Name), zap.Stringer("version", rel.Version))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/xaxes/self-update/config"
+)
+
+func Test_syncOnce(t *testing.T) {
+	var (
+		mu                sync.Mutex
+		full, notModified int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		etag := `"` + req.URL.Path + `"`
+		if req.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		full++
+		w.Header().Set("ETag", etag)
+		io.WriteString(w, "[]")
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var src remoteSource
+	syncRepo := func(repo string) {
+		r := config.Remote{Forge: &config.Forge{URL: srv.URL, Repository: repo}}
+		syncOnce(context.Background(), &src, r, dir, "1.0.0")
+	}
+
+	// The listing is requested with the ETag of the previous one.
+	syncRepo("xaxes/self-update")
+	syncRepo("xaxes/self-update")
+	if full != 1 || notModified != 1 {
+		t.Errorf("sent %d listings in full and %d unmodified, want 1 and 1", full, notModified)
+	}
+
+	t.Run("reconfigured", func(t *testing.T) {
+		syncRepo("xaxes/fork")
+		if full != 2 || notModified != 1 {
+			t.Errorf("sent %d listings in full and %d unmodified, want 2 and 1", full, notModified)
+		}
+	})
+}
@@ -2,19 +2,27 @@ package main
 
 import (
 	"context"
-	"errors"
 	"net/http"
 
+	"github.com/xaxes/self-update/tracing"
+	"github.com/xaxes/self-update/upgrade"
 	"go.uber.org/zap"
 )
 
-func replaceHandler(tempServer, server *http.Server) func(w http.ResponseWriter, r *http.Request) {
+// replaceHandler starts `server` in place of the temporary one.
+//
+// `handoff` is the span covering the time since this instance started;
+// it ends once the server is started.
+func replaceHandler(tempServer *http.Server, inst *instance, handoff *tracing.Span) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		zap.L().Info("handle HTTP request", zap.String("method", r.Method), zap.String("uri", r.RequestURI))
+
+		_, span := tracing.Start(tracing.Detach(r.Context()), "main.replaceHandler", tracing.KindInternal)
+
 		// the goroutine here is needed because the code below closes
 		// the server, so we wouldn't be able to respond to the request
 		// properly.
-		go func(tempServer, server *http.Server) {
+		go func(tempServer *http.Server, inst *instance) {
 			// we don't care if it closes the connections successfully, hence 0
 			ctx, cancel := context.WithTimeout(context.Background(), 10)
 			defer cancel()
@@ -23,13 +31,20 @@ func replaceHandler(tempServer, server *http.Server) func(w http.ResponseWriter,
 				zap.L().Error("shutdown temporary server", zap.Error(err))
 			}
 
-			go func() {
-				if err := server.ListenAndServe(); err != nil {
-					if !errors.Is(err, http.ErrServerClosed) {
-						zap.L().Fatal("listen and serve on replace", zap.Error(err))
-					}
-				}
-			}()
-		}(tempServer, server)
+			if err := inst.serve(); err != nil {
+				zap.L().Fatal("serve on replace", zap.Error(err))
+			}
+
+			upgrade.ObserveHandoff()
+			inst.tracker.Transition(upgrade.StateServing, "")
+			span.End()
+			handoff.End()
+
+			// The outgoing instance exits once it considers the upgrade
+			// successful and kills this one otherwise.
+			upgrade.WaitParent()
+			inst.tracker.Transition(upgrade.StateSucceeded, "")
+			notifyServing("upgraded")
+		}(tempServer, inst)
 	}
 }
@@ -4,7 +4,6 @@ import (
 	"html/template"
 	"net/http"
 
-	"github.com/xaxes/self-update/check"
 	"go.uber.org/zap"
 )
 
@@ -61,7 +60,7 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 
 	status := Status{Version, ""}
 
-	new, err := check.NewestCandidate(UpgradeDir, Version)
+	new, err := candidates.Newest(r.Context(), Version)
 	if err == nil {
 		status.NewVersion = new.Version.String()
 	}
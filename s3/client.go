@@ -0,0 +1,227 @@
+// Package s3 downloads releases from buckets of S3-compatible object
+// storage, such as Amazon S3 or MinIO.
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxListPages limits the pages of objects listed at once.
+const maxListPages = 100
+
+// maxSumsSize limits the size of SHA256SUMS objects.
+const maxSumsSize = 1 << 20
+
+var (
+	// errChanged is returned for objects replaced since they were listed.
+	errChanged  = errors.New("object changed")
+	errNotFound = errors.New("no such object")
+)
+
+// Object is an object of a bucket.
+type Object struct {
+	Key  string
+	ETag string
+	Size int64
+	// Metadata are the user-defined `x-amz-meta-*` headers by their
+	// lowercase names without the prefix; only set by Head.
+	Metadata map[string]string
+	// ChecksumSHA256 is the base64-encoded checksum S3 stores for objects
+	// uploaded with one; only set by Head.
+	ChecksumSHA256 string
+}
+
+// Config configures a client.
+type Config struct {
+	// Endpoint is the URL of the storage; Amazon S3 in the region if
+	// empty. Buckets are addressed by path, unless it is Amazon S3.
+	Endpoint string
+	Region   string
+	Bucket   string
+	Credentials
+}
+
+// Client reads the objects of a bucket.
+type Client struct {
+	base   *url.URL // of the bucket
+	region string
+	creds  Credentials
+	http   *http.Client
+}
+
+// NewClient returns a client of the bucket.
+func NewClient(c Config) (*Client, error) {
+	if c.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+
+	region := c.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	var base *url.URL
+	if c.Endpoint == "" {
+		base = &url.URL{Scheme: "https", Host: c.Bucket + ".s3." + region + ".amazonaws.com", Path: "/"}
+	} else {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("bad endpoint %q", c.Endpoint)
+		}
+
+		base = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/") + "/" + c.Bucket + "/"}
+	}
+
+	return &Client{
+		base:   base,
+		region: region,
+		creds:  c.Credentials,
+		// Objects are binaries.
+		http: &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// do sends a request for `key` signed with the credentials, if any.
+// A 2xx response is returned.
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, header http.Header) (*http.Response, error) {
+	u := *c.base
+	u.Path += key
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+
+	if c.creds.AccessKeyID != "" {
+		sign(req, c.creds, c.region, time.Now())
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusPreconditionFailed:
+			return nil, fmt.Errorf("%s %s: %w", method, key, errChanged)
+		case http.StatusNotFound:
+			return nil, fmt.Errorf("%s %s: %w", method, key, errNotFound)
+		}
+
+		// S3 describes the error in the body; HEAD has none.
+		var e struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e) == nil && e.Code != "" {
+			return nil, fmt.Errorf("%s %s: %s: %s: %s", method, key, resp.Status, e.Code, e.Message)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, key, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// List returns the objects whose keys start with `prefix`.
+func (c *Client) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objs []Object
+
+	token := ""
+	for page := 0; ; page++ {
+		if page == maxListPages {
+			return nil, fmt.Errorf("list: more than %d pages", maxListPages)
+		}
+
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		resp, err := c.do(ctx, http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var res struct {
+			Contents []struct {
+				Key  string `xml:"Key"`
+				ETag string `xml:"ETag"`
+				Size int64  `xml:"Size"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list: %w", err)
+		}
+
+		for _, o := range res.Contents {
+			objs = append(objs, Object{Key: o.Key, ETag: o.ETag, Size: o.Size})
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return objs, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// Head returns the object `key` with its metadata.
+func (c *Client) Head(ctx context.Context, key string) (Object, error) {
+	resp, err := c.do(ctx, http.MethodHead, key, nil, http.Header{"X-Amz-Checksum-Mode": {"ENABLED"}})
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+
+	o := Object{
+		Key:            key,
+		ETag:           resp.Header.Get("ETag"),
+		Size:           resp.ContentLength,
+		Metadata:       make(map[string]string),
+		ChecksumSHA256: resp.Header.Get("X-Amz-Checksum-Sha256"),
+	}
+	for k := range resp.Header {
+		if name := strings.ToLower(k); strings.HasPrefix(name, "x-amz-meta-") {
+			o.Metadata[strings.TrimPrefix(name, "x-amz-meta-")] = resp.Header.Get(k)
+		}
+	}
+
+	return o, nil
+}
+
+// Get returns the contents of the object `key`; it fails if the ETag
+// is not `etag`, unless empty.
+func (c *Client) Get(ctx context.Context, key, etag string) (io.ReadCloser, error) {
+	var h http.Header
+	if etag != "" {
+		h = http.Header{"If-Match": {etag}}
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, key, nil, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
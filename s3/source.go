@@ -0,0 +1,296 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver"
+	"github.com/xaxes/self-update/check"
+	"github.com/xaxes/self-update/remote"
+	"go.uber.org/zap"
+)
+
+// metaVersion and metaSHA256 are the metadata of objects whose key has
+// no version or which are uploaded without a checksum, e.g. set with
+// `aws s3 cp --metadata version=1.2.0,sha256=…`.
+const (
+	metaVersion = "version"
+	metaSHA256  = "sha256"
+)
+
+// companions are the extensions of files published next to binaries.
+var companions = []string{".sig", ".pem", ".bundle", ".intoto.jsonl", ".sha256"}
+
+// nameVersion matches versions in names, e.g. app-1.2.0-linux-amd64 or
+// app_v1.3.0-rc.1.tar.gz. Other pre-releases are not told apart from the
+// platform; their version has to be in a directory or in the metadata.
+var nameVersion = regexp.MustCompile(`(?:^|[-_])v?(\d+\.\d+\.\d+(?:-(?:alpha|beta|rc)(?:\.?\d+)*)?)(?:$|[-_.])`)
+
+// Source provides the objects under a prefix of a bucket as releases.
+//
+// The version of an object is read from its key, either a directory such
+// as releases/1.2.0/app or the base name such as releases/app-1.2.0, or
+// else from its `version` metadata.
+type Source struct {
+	client  *Client
+	prefix  string
+	pattern string
+
+	mu         sync.Mutex
+	etags      map[string]string           // by key, of the last listing
+	companions map[string][]remote.Release // by key of the release
+}
+
+// NewSource returns the source of the objects of `c` under `prefix` whose
+// base name matches `pattern`, see path.Match; all objects match an empty
+// pattern.
+func NewSource(c *Client, prefix, pattern string) *Source {
+	return &Source{
+		client:     c,
+		prefix:     prefix,
+		pattern:    pattern,
+		etags:      make(map[string]string),
+		companions: make(map[string][]remote.Release),
+	}
+}
+
+// Releases implements remote.Source.
+//
+// Their digest is resolved when a release is downloaded.
+func (s *Source) Releases(ctx context.Context) ([]remote.Release, error) {
+	objs, err := s.client.List(ctx, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	etags := make(map[string]string)
+	keys := make(map[string]Object)
+
+	var rs []remote.Release
+	for _, o := range objs {
+		keys[o.Key] = o
+
+		name := path.Base(o.Key)
+		if strings.HasSuffix(o.Key, "/") || name == check.SumsName || isCompanion(name) {
+			continue
+		}
+
+		if s.pattern != "" {
+			if ok, err := path.Match(s.pattern, name); err != nil || !ok {
+				continue
+			}
+		}
+
+		v := keyVersion(strings.TrimPrefix(o.Key, s.prefix))
+		if v == nil {
+			if v, err = s.metaVersion(ctx, o.Key); err != nil {
+				zap.L().Debug("s3: skip object without version", zap.String("key", o.Key), zap.Error(err))
+				continue
+			}
+		}
+
+		etags[o.Key] = o.ETag
+		rs = append(rs, remote.Release{
+			Name:    name,
+			Ref:     o.Key,
+			Version: v,
+			Size:    o.Size,
+		})
+	}
+	sort.Slice(rs, func(a, b int) bool { return rs[a].Ref < rs[b].Ref })
+
+	companions := make(map[string][]remote.Release)
+	for _, r := range rs {
+		for _, ext := range remote.Companions {
+			o, ok := keys[r.Ref+ext]
+			if !ok {
+				continue
+			}
+
+			etags[o.Key] = o.ETag
+			companions[r.Ref] = append(companions[r.Ref], remote.Release{
+				Name:    path.Base(o.Key),
+				Ref:     o.Key,
+				Version: r.Version,
+				Size:    o.Size,
+			})
+		}
+	}
+
+	s.mu.Lock()
+	s.etags = etags
+	s.companions = companions
+	s.mu.Unlock()
+
+	return rs, nil
+}
+
+// Companions implements remote.CompanionSource. They are the objects
+// whose key is the one of `r` with a companion extension, e.g. its
+// .bundle; they are verified against their ETag on download.
+func (s *Source) Companions(ctx context.Context, r remote.Release) ([]remote.Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.companions[r.Ref], nil
+}
+
+func isCompanion(name string) bool {
+	for _, ext := range companions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keyVersion returns the version in the base name of `key`, or else in
+// the nearest directory named after one.
+func keyVersion(key string) *semver.Version {
+	segments := strings.Split(key, "/")
+
+	if m := nameVersion.FindStringSubmatch(segments[len(segments)-1]); m != nil {
+		if v, err := semver.NewVersion(m[1]); err == nil {
+			return v
+		}
+	}
+
+	for i := len(segments) - 2; i >= 0; i-- {
+		if v, err := semver.NewVersion(segments[i]); err == nil {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (s *Source) metaVersion(ctx context.Context, key string) (*semver.Version, error) {
+	o, err := s.client.Head(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return semver.NewVersion(o.Metadata[metaVersion])
+}
+
+// Resolve implements remote.Resolver. The SHA-256 is the one of the
+// `sha256` metadata, the checksum stored by S3, or the one listed in a
+// SHA256SUMS object next to the release, in that order.
+//
+// Releases without any are verified against their ETag, if it is the MD5
+// of the contents.
+func (s *Source) Resolve(ctx context.Context, r remote.Release) (remote.Release, error) {
+	o, err := s.client.Head(ctx, r.Ref)
+	if err != nil {
+		return remote.Release{}, err
+	}
+
+	// The object may have been replaced since it was listed; the details
+	// are the ones of the current one.
+	r.Size = o.Size
+	s.mu.Lock()
+	s.etags[r.Ref] = o.ETag
+	s.mu.Unlock()
+
+	if sum := strings.ToLower(o.Metadata[metaSHA256]); sum != "" {
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 {
+			return remote.Release{}, fmt.Errorf("bad %s metadata %q", metaSHA256, sum)
+		}
+		r.SHA256 = sum
+		return r, nil
+	}
+
+	// Checksums of multipart uploads are checksums of the parts.
+	if b, err := base64.StdEncoding.DecodeString(o.ChecksumSHA256); err == nil && len(b) == 32 {
+		r.SHA256 = hex.EncodeToString(b)
+		return r, nil
+	}
+
+	sums, err := s.sums(ctx, path.Dir(r.Ref))
+	if err != nil {
+		return remote.Release{}, err
+	}
+	if sums == nil {
+		return r, nil
+	}
+
+	sum, ok := sums[path.Base(r.Ref)]
+	if !ok {
+		return remote.Release{}, fmt.Errorf("%s not listed in %s", r.Ref, check.SumsName)
+	}
+	r.SHA256 = sum
+
+	return r, nil
+}
+
+// sums returns the checksum manifest of the directory `dir`, or nil if
+// there is none.
+func (s *Source) sums(ctx context.Context, dir string) (map[string]string, error) {
+	key := check.SumsName
+	if dir != "." {
+		key = dir + "/" + key
+	}
+
+	b, err := s.client.Get(ctx, key, "")
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(b, maxSumsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSumsSize {
+		return nil, fmt.Errorf("%s exceeds %d bytes", key, maxSumsSize)
+	}
+
+	return check.ParseSums(data)
+}
+
+// Download implements remote.Source. The object has to be the one
+// resolved, so a replaced one is not mixed up with its details.
+func (s *Source) Download(ctx context.Context, r remote.Release, w io.Writer) error {
+	s.mu.Lock()
+	etag := s.etags[r.Ref]
+	s.mu.Unlock()
+
+	body, err := s.client.Get(ctx, r.Ref, etag)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// ETags of multipart uploads and of objects encrypted with KMS are
+	// not MD5s of the contents; the latter need a SHA-256.
+	want := strings.Trim(etag, `"`)
+	if b, err := hex.DecodeString(want); r.SHA256 != "" || err != nil || len(b) != md5.Size {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), body); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+		return fmt.Errorf("MD5 %s, want ETag %s", got, want)
+	}
+
+	return nil
+}
@@ -0,0 +1,352 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xaxes/self-update/remote"
+	"github.com/xaxes/self-update/remote/remotetest"
+)
+
+const testBucketName = "releases"
+
+var testCreds = Credentials{AccessKeyID: "minio", SecretAccessKey: "minio-secret"}
+
+type testObject struct {
+	data     []byte
+	etag     string
+	meta     map[string]string
+	checksum bool // whether S3 stores its SHA-256
+}
+
+// testBucket is a MinIO-like storage with a single bucket addressed by
+// path, which requires requests signed with testCreds.
+type testBucket struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]*testObject
+}
+
+func newTestBucket(t *testing.T) *testBucket {
+	b := &testBucket{t: t, objects: make(map[string]*testObject)}
+	b.srv = remotetest.NewServer(t, b.serve)
+
+	return b
+}
+
+func (b *testBucket) put(key, data string, meta map[string]string) *testObject {
+	sum := md5.Sum([]byte(data))
+	o := &testObject{data: []byte(data), etag: `"` + hex.EncodeToString(sum[:]) + `"`, meta: meta}
+
+	b.mu.Lock()
+	b.objects[key] = o
+	b.mu.Unlock()
+
+	return o
+}
+
+func (b *testBucket) client(creds Credentials) *Client {
+	c, err := NewClient(Config{Endpoint: b.srv.URL, Bucket: testBucketName, Credentials: creds})
+	if err != nil {
+		b.t.Fatal(err)
+	}
+
+	return c
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, http.StatusText(status))
+}
+
+// verify signs the request again and compares the signatures.
+func verify(req *http.Request) bool {
+	authz := req.Header.Get("Authorization")
+	i := strings.Index(authz, "SignedHeaders=")
+	if i < 0 {
+		return false
+	}
+	signed := strings.SplitN(authz[i+len("SignedHeaders="):], ",", 2)[0]
+
+	now, err := time.Parse(amzDate, req.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	again := httptest.NewRequest(req.Method, "http://"+req.Host+req.URL.RequestURI(), nil)
+	for _, k := range strings.Split(signed, ";") {
+		if k != "host" {
+			again.Header.Set(k, req.Header.Get(k))
+		}
+	}
+	sign(again, testCreds, "us-east-1", now)
+
+	return again.Header.Get("Authorization") == authz
+}
+
+func (b *testBucket) serve(w http.ResponseWriter, req *http.Request) {
+	if !verify(req) {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if req.URL.Path == "/"+testBucketName+"/" {
+		b.list(w, req)
+		return
+	}
+
+	key := strings.TrimPrefix(req.URL.Path, "/"+testBucketName+"/")
+	o, ok := b.objects[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if m := req.Header.Get("If-Match"); m != "" && m != o.etag {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	w.Header().Set("ETag", o.etag)
+	w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+	for k, v := range o.meta {
+		w.Header().Set("X-Amz-Meta-"+k, v)
+	}
+	if o.checksum && req.Header.Get("X-Amz-Checksum-Mode") == "ENABLED" {
+		sum := sha256.Sum256(o.data)
+		w.Header().Set("X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	if req.Method == http.MethodGet {
+		w.Write(o.data)
+	}
+}
+
+// list lists two objects per page.
+func (b *testBucket) list(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if q.Get("list-type") != "2" {
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+
+	var keys []string
+	for k := range b.objects {
+		if strings.HasPrefix(k, q.Get("prefix")) && k > q.Get("continuation-token") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key  string
+		ETag string
+		Size int
+	}
+	res := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+	for i, k := range keys {
+		if i == 2 {
+			res.IsTruncated = true
+			res.NextContinuationToken = keys[i-1]
+			break
+		}
+		res.Contents = append(res.Contents, content{Key: k, ETag: b.objects[k].etag, Size: len(b.objects[k].data)})
+	}
+
+	xml.NewEncoder(w).Encode(res)
+}
+
+func TestSource(t *testing.T) {
+	b := newTestBucket(t)
+
+	b.put("releases/app-1.0.0", "1.0.0", nil)
+	b.put("releases/app-1.1.0", "1.1.0", map[string]string{"sha256": remotetest.SHA256Hex("1.1.0")})
+	b.put("releases/app-1.1.0.sig", "signature", nil)
+	b.put("releases/1.2.0/app", "1.2.0", nil)
+	b.put("releases/1.2.0/SHA256SUMS", remotetest.SHA256Hex("1.2.0")+"  app\n", nil)
+	b.put("releases/1.2.0/app.bundle", "bundle", nil)
+	b.put("releases/app", "0.9.0", map[string]string{"version": "0.9.0"})
+	b.put("nightly/app-9.0.0", "9.0.0", nil)
+
+	dir := remotetest.TempDir(t, "s3")
+
+	src := NewSource(b.client(testCreds), "releases/", "")
+
+	rel, err := remote.Sync(context.Background(), src, dir, "1.0.0")
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if rel.Name != "app" || rel.Version.String() != "1.2.0" {
+		t.Errorf("Sync() = %s %s, want app 1.2.0", rel.Name, rel.Version)
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "app.bundle")); err != nil || string(data) != "bundle" {
+		t.Errorf("companion app.bundle = %q, %v, want bundle", data, err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		data    string
+		meta    map[string]string
+		tamper  func(o *testObject)
+		wantErr bool
+	}{
+		{
+			name: "stored checksum",
+			key:  "releases/app-1.3.0",
+			data: "1.3.0",
+			tamper: func(o *testObject) {
+				o.checksum = true
+			},
+		},
+		{
+			name: "ETag",
+			key:  "releases/app-1.4.0",
+			data: "1.4.0",
+		},
+		{
+			name:    "metadata mismatch",
+			key:     "releases/app-1.5.0",
+			data:    "1.5.0",
+			meta:    map[string]string{"sha256": remotetest.SHA256Hex("9.9.9")},
+			wantErr: true,
+		},
+		{
+			name: "ETag mismatch",
+			key:  "releases/app-1.6.0",
+			data: "1.6.0",
+			tamper: func(o *testObject) {
+				o.etag = `"00000000000000000000000000000000"`
+			},
+			wantErr: true,
+		},
+		{
+			name: "unlisted",
+			key:  "releases/1.7.0/app",
+			data: "1.7.0",
+			tamper: func(*testObject) {
+				b.put("releases/1.7.0/SHA256SUMS", remotetest.SHA256Hex("1.7.0")+"  other\n", nil)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := b.put(tt.key, tt.data, tt.meta)
+			if tt.tamper != nil {
+				tt.tamper(o)
+			}
+			defer func() {
+				if tt.wantErr {
+					b.mu.Lock()
+					delete(b.objects, tt.key)
+					b.mu.Unlock()
+				}
+			}()
+
+			_, err := remote.Sync(context.Background(), src, dir, "1.0.0")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("replaced", func(t *testing.T) {
+		r := remote.Release{Name: "app-1.4.0", Ref: "releases/app-1.4.0"}
+		r, err := src.Resolve(context.Background(), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b.put(r.Ref, "1.4.1", nil)
+
+		err = src.Download(context.Background(), r, ioutil.Discard)
+		if !errors.Is(err, errChanged) {
+			t.Errorf("Download() error = %v, want %v", err, errChanged)
+		}
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		src := NewSource(b.client(Credentials{AccessKeyID: "minio", SecretAccessKey: "wrong"}), "releases/", "")
+		_, err := src.Releases(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+			t.Errorf("Releases() error = %v, want SignatureDoesNotMatch", err)
+		}
+	})
+}
+
+func TestSource_Releases(t *testing.T) {
+	b := newTestBucket(t)
+
+	for _, key := range []string{"a/app-1.0.0-linux-amd64", "a/app-1.1.0-darwin-arm64", "a/app-1.2.0-linux-amd64.tar.gz", "a/SHA256SUMS", "a/readme"} {
+		b.put(key, key, nil)
+	}
+
+	rs, err := NewSource(b.client(testCreds), "a/", "*-linux-amd64*").Releases(context.Background())
+	if err != nil {
+		t.Fatalf("Releases() error = %v", err)
+	}
+
+	var got []string
+	for _, r := range rs {
+		got = append(got, r.Name+"@"+r.Version.String())
+	}
+	want := "app-1.0.0-linux-amd64@1.0.0 app-1.2.0-linux-amd64.tar.gz@1.2.0"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Releases() = %v, want %s", got, want)
+	}
+}
+
+func Test_keyVersion(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "app-1.2.0", want: "1.2.0"},
+		{key: "app_v1.2.0.tar.gz", want: "1.2.0"},
+		{key: "app-1.2.0-linux-amd64", want: "1.2.0"},
+		{key: "app-1.3.0-rc.1-linux-amd64", want: "1.3.0-rc.1"},
+		{key: "v1.2.0/app", want: "1.2.0"},
+		{key: "1.2.0/linux/app", want: "1.2.0"},
+		{key: "1.2.0/app-1.3.0", want: "1.3.0"},
+		{key: "app", want: ""},
+		{key: "app1.2.0", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got := ""
+			if v := keyVersion(tt.key); v != nil {
+				got = v.String()
+			}
+			if got != tt.want {
+				t.Errorf("keyVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net"
+
+	"github.com/Masterminds/semver"
+	"github.com/xaxes/self-update/config"
+	"github.com/xaxes/self-update/upgrade"
+)
+
+// checkBind verifies that `bind` is a valid host and port pair.
+func checkBind(bind string) error {
+	_, _, err := net.SplitHostPort(bind)
+	return err
+}
+
+// checkListen verifies that the service is able to listen on `bind`.
+func checkListen(bind string) error {
+	l, err := net.Listen("tcp", bind)
+	if err != nil {
+		return err
+	}
+
+	return l.Close()
+}
+
+// selfTest verifies that the binary is able to run with the supplied
+// configuration and prints a JSON report on stdout.
+//
+// It is executed by the running instance on the upgrade candidate
+// before the upgrade starts. See upgrade.SelfTest.
+//
+// Returns the exit code.
+func selfTest(bind, upgradeBind, configPath string) int {
+	report := upgrade.NewReport(Version)
+
+	_, err := semver.NewVersion(Version)
+	report.Add("version", err)
+
+	_, err = config.Load(configPath)
+	report.Add("config", err)
+
+	report.Add("bind", checkBind(bind))
+	report.Add("upgrade-bind", checkListen(upgradeBind))
+
+	_, err = ioutil.ReadDir(UpgradeDir)
+	report.Add("upgrade-dir", err)
+
+	_, err = template.New("page").Parse(pageTemplate)
+	report.Add("template", err)
+
+	out, err := json.Marshal(report)
+	if err != nil {
+		fmt.Printf(`{"error": "%s"}`+"\n", err)
+		return 1
+	}
+
+	fmt.Println(string(out))
+
+	if !report.OK {
+		return 1
+	}
+
+	return 0
+}
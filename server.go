@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/xaxes/self-update/systemd"
+	"github.com/xaxes/self-update/upgrade"
+	"go.uber.org/zap"
+)
+
+// instance owns the HTTP server of the running service.
+//
+// A shut down http.Server cannot be started again, so the server
+// is replaced when a failed upgrade is rolled back.
+type instance struct {
+	tracker *upgrade.Tracker
+
+	// listener is the socket passed by the service manager or nil.
+	// It stays open, so the server can be started on it again.
+	listener *os.File
+
+	mu     sync.Mutex
+	server *http.Server
+}
+
+func newInstance(bind string, handler http.Handler, listener *os.File) *instance {
+	return &instance{
+		tracker:  upgrade.NewTracker(Version),
+		listener: listener,
+		server: &http.Server{
+			Addr:    bind,
+			Handler: handler,
+		},
+	}
+}
+
+// Server returns the current HTTP server.
+func (i *instance) Server() *http.Server {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.server
+}
+
+// listen returns the passed socket, if any, or binds to `addr`.
+func (i *instance) listen(addr string) (net.Listener, error) {
+	if i.listener != nil {
+		return net.FileListener(i.listener)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+// serve starts the current HTTP server in background.
+//
+// It returns once the server listens, so it is ready to accept
+// connections.
+func (i *instance) serve() error {
+	s := i.Server()
+
+	l, err := i.listen(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		zap.L().Info("start", zap.String("bind", s.Addr), zap.String("version", Version))
+		if err := s.Serve(l); err != nil {
+			if !errors.Is(err, http.ErrServerClosed) {
+				zap.L().Fatal("serve", zap.Error(err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// resume replaces the shut down HTTP server and starts the new one.
+func (i *instance) resume() error {
+	i.mu.Lock()
+	i.server = &http.Server{
+		Addr:    i.server.Addr,
+		Handler: i.server.Handler,
+	}
+	i.mu.Unlock()
+
+	return i.serve()
+}
+
+// notify sends `states` to the service manager.
+func notify(states ...string) {
+	if err := systemd.Notify(states...); err != nil {
+		zap.L().Error("notify service manager", zap.Error(err))
+	}
+}
+
+// notifyServing announces to the service manager that the service
+// is ready; `status` describes how it got there.
+func notifyServing(status string) {
+	notify(systemd.Ready, systemd.MainPID(os.Getpid()), systemd.Status(fmt.Sprintf("serving %s; %s", Version, status)))
+}
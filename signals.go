@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/xaxes/self-update/check"
+	"github.com/xaxes/self-update/config"
+	"github.com/xaxes/self-update/systemd"
+	"github.com/xaxes/self-update/tracing"
+	"go.uber.org/zap"
+)
+
+// handleSignals reacts to the caught signals until SIGINT or SIGTERM.
+//
+// upgradeSignal starts an upgrade like `GET /upgrade`, subject to the same
+// guard against concurrent upgrades; reloadSignal re-reads the configuration
+// and rescans the upgrade candidates newer than `version`.
+func handleSignals(start upgradeStarter, conf *config.Reloadable, version func() string) {
+	handled := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	for _, sig := range []os.Signal{upgradeSignal, reloadSignal} {
+		if sig != nil {
+			handled = append(handled, sig)
+		}
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, handled...)
+	defer signal.Stop(sigs)
+
+	for sig := range sigs {
+		zap.L().Info("catch signal", zap.Stringer("signal", sig))
+
+		switch sig {
+		case upgradeSignal:
+			ctx, span := tracing.Start(context.Background(), "main.signal", tracing.KindInternal, tracing.String("signal", sig.String()))
+			err := start(ctx)
+			span.RecordError(err)
+			span.End()
+
+			if err != nil {
+				zap.L().Error("start upgrade", zap.Error(err))
+			}
+		case reloadSignal:
+			reload(conf, version())
+		default:
+			return
+		}
+	}
+}
+
+// reload re-reads the configuration and logs the newest candidate.
+func reload(conf *config.Reloadable, version string) {
+	notify(systemd.Reloading, systemd.Status("reloading"))
+	defer notify(systemd.Ready, systemd.Status("serving "+version+"; reloaded"))
+
+	if err := conf.Reload(); err != nil {
+		zap.L().Error("reload config", zap.Error(err))
+	} else {
+		zap.L().Info("reload config")
+	}
+
+	if err := candidates.Rescan(); err != nil {
+		zap.L().Error("rescan candidates", zap.Error(err))
+		return
+	}
+
+	c, err := candidates.Newest(context.Background(), version)
+	if err != nil {
+		if errors.Is(err, check.ErrNoCandidate) {
+			zap.L().Info("rescan candidates", zap.Error(err))
+			return
+		}
+
+		zap.L().Error("rescan candidates", zap.Error(err))
+		return
+	}
+
+	zap.L().Info("rescan candidates", zap.String("candidate", c.Path), zap.Stringer("version", c.Version))
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// upgradeSignal starts an upgrade.
+	upgradeSignal os.Signal = syscall.SIGUSR2
+	// reloadSignal re-reads the configuration.
+	reloadSignal os.Signal = syscall.SIGHUP
+)
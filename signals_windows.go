@@ -0,0 +1,10 @@
+package main
+
+import "os"
+
+// Windows has no equivalents of SIGUSR2 and SIGHUP; upgrades are only
+// started over HTTP.
+var (
+	upgradeSignal os.Signal
+	reloadSignal  os.Signal
+)
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/xaxes/self-update/config"
+	"github.com/xaxes/self-update/metrics"
+	"github.com/xaxes/self-update/supervisor"
+	"github.com/xaxes/self-update/systemd"
+	"github.com/xaxes/self-update/tracing"
+	"github.com/xaxes/self-update/upgrade"
+	"go.uber.org/zap"
+)
+
+// startSupervisorUpgrade returns the starter of upgrades replacing
+// the workers of `s`.
+//
+// Unlike startUpgrade, the process keeps running whatever the result.
+func startSupervisorUpgrade(s *supervisor.Supervisor, t *upgrade.Tracker, tempBind, bind string, conf *config.Reloadable) upgradeStarter {
+	return func(ctx context.Context) error {
+		c, err := candidates.Newest(ctx, s.Version())
+		if err != nil {
+			return err
+		}
+
+		if err := t.Begin(); err != nil {
+			return err
+		}
+
+		hc := conf.Config().HealthCheck()
+		ctx = tracing.Detach(ctx)
+		tested := make(chan error, 1)
+
+		go func() {
+			err := s.Upgrade(ctx, t, c.Path, c.Version.String(), tempBind, bind, hc, tested)
+			t.Finish(err)
+
+			if err != nil {
+				zap.L().Error("upgrade", zap.Error(err), zap.String("status", "failure"))
+				return
+			}
+
+			zap.L().Info("upgrade", zap.String("status", "success"), zap.String("version", s.Version()))
+		}()
+
+		return <-tested
+	}
+}
+
+// controlProxy forwards requests of workers to the supervisor's
+// control server on `controlBind`.
+func controlProxy(controlBind string) http.Handler {
+	host := controlBind
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: host})
+	// Stream the upgrade events as they come.
+	proxy.FlushInterval = -1
+
+	return proxy
+}
+
+// supervisorListeners returns the passed sockets or binds to `bind`.
+func supervisorListeners(passed []systemd.Listener, bind string) ([]systemd.Listener, error) {
+	if len(passed) > 0 {
+		return passed, nil
+	}
+
+	l, err := net.Listen("tcp", bind)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	// The duplicate stays open for the lifetime of the supervisor.
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		return nil, err
+	}
+
+	return []systemd.Listener{{Name: "http", File: f}}, nil
+}
+
+// supervise runs the application as a worker of a supervisor serving
+// the control endpoints on `controlBind` until a signal is caught.
+func supervise(passed []systemd.Listener, bind, controlBind, upgradeBind string, conf *config.Reloadable) error {
+	listeners, err := supervisorListeners(passed, bind)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{
+		"-worker=true",
+		"-bind", bind,
+		"-control-bind", controlBind,
+	}, upgrade.InstanceArgs...)
+
+	// os.Args[0] may be a name looked up in $PATH or a relative symlink.
+	exe, err := upgrade.Executable()
+	if err != nil {
+		return err
+	}
+
+	s := supervisor.New(args, listeners)
+	if err := s.Start(exe, Version); err != nil {
+		return err
+	}
+
+	tracker := upgrade.NewTracker(Version)
+
+	start := startSupervisorUpgrade(s, tracker, upgradeBind, bind, conf)
+
+	router := http.NewServeMux()
+	router.HandleFunc("/check", checkHandler(s.Version))
+	router.HandleFunc("/upgrade", upgradeHandler(start))
+	router.HandleFunc("/upgrade/events", eventsHandler(tracker))
+	router.Handle("/metrics", metrics.Handler())
+
+	control := &http.Server{
+		Addr:    controlBind,
+		Handler: tracing.Middleware(router),
+	}
+
+	go func() {
+		zap.L().Info("start supervisor", zap.String("bind", controlBind), zap.String("version", Version))
+
+		if err := control.ListenAndServe(); err != nil {
+			if !errors.Is(err, http.ErrServerClosed) {
+				zap.L().Fatal("listen and serve control", zap.Error(err))
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	syncRemote(ctx, conf, downloadDir(), s.Version)
+	autoUpdate(ctx, conf, s.Version, start)
+
+	handleSignals(start, conf, s.Version)
+	notify(systemd.Stopping)
+
+	cancel()
+	<-done
+
+	return nil
+}
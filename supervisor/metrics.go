@@ -0,0 +1,14 @@
+package supervisor
+
+import "github.com/xaxes/self-update/metrics"
+
+var (
+	workerRestarts = metrics.Default.NewCounter(
+		"self_update_worker_restarts_total",
+		"Number of workers restarted after an unexpected exit.",
+	)
+	workerGeneration = metrics.Default.NewGauge(
+		"self_update_worker_generation",
+		"Generation of the most recently started worker.",
+	)
+)
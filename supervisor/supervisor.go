@@ -0,0 +1,274 @@
+// Package supervisor implements a long-lived master process which owns
+// the listeners and runs the application as a worker process.
+//
+// Upgrades start a new generation of workers on the same listeners and
+// retire the previous one, so connections are never refused. Workers
+// which exit unexpectedly are restarted with an exponential backoff.
+//
+// Workers announce readiness with the sd_notify(3) protocol on a socket
+// owned by the supervisor.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/xaxes/self-update/systemd"
+	"github.com/xaxes/self-update/tracing"
+	"github.com/xaxes/self-update/upgrade"
+	"go.uber.org/zap"
+)
+
+const (
+	// readyTimeout is the time a worker has to announce readiness.
+	readyTimeout = 30 * time.Second
+
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+	// stableUptime is the time after which a crashed worker is considered
+	// to have run correctly before, resetting the backoff.
+	stableUptime = time.Minute
+)
+
+// ErrUnsupported is returned on systems which cannot pass listeners
+// to child processes.
+var ErrUnsupported = errors.New("supervisor is not supported on " + runtime.GOOS)
+
+// Supervisor runs and replaces generations of workers.
+type Supervisor struct {
+	args      []string
+	listeners []systemd.Listener
+
+	mu      sync.Mutex
+	gen     int
+	current *worker
+}
+
+// New returns a supervisor passing `args` and `listeners` to workers.
+func New(args []string, listeners []systemd.Listener) *Supervisor {
+	return &Supervisor{
+		args:      args,
+		listeners: listeners,
+	}
+}
+
+// notify sends `states` to the service manager.
+func notify(states ...string) {
+	if err := systemd.Notify(states...); err != nil {
+		zap.L().Error("notify service manager", zap.Error(err))
+	}
+}
+
+// Version returns the version of the current worker.
+func (s *Supervisor) Version() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		return ""
+	}
+
+	return s.current.version
+}
+
+// swap makes `w` the current worker and returns the previous one.
+func (s *Supervisor) swap(w *worker) *worker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.current
+	s.current = w
+
+	return prev
+}
+
+func (s *Supervisor) worker() *worker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.current
+}
+
+// Start executes `bin` in `version` as the first worker and waits
+// until it is ready.
+func (s *Supervisor) Start(bin, version string) error {
+	if runtime.GOOS == "windows" {
+		return ErrUnsupported
+	}
+
+	w, err := s.startWorker(context.Background(), bin, version)
+	if err != nil {
+		return err
+	}
+
+	if err := w.waitReady(readyTimeout); err != nil {
+		w.retire()
+		return err
+	}
+
+	s.swap(w)
+	notify(systemd.Ready, systemd.Status("serving "+version))
+
+	return nil
+}
+
+// Run restarts the current worker whenever it exits unexpectedly.
+//
+// It blocks until `ctx` is done and retires the current worker then.
+func (s *Supervisor) Run(ctx context.Context) {
+	backoff := minBackoff
+
+	for {
+		w := s.worker()
+
+		select {
+		case <-ctx.Done():
+			s.worker().retire()
+			return
+		case <-w.exited:
+		}
+
+		// Replaced by an upgrade.
+		if w != s.worker() {
+			continue
+		}
+
+		if time.Since(w.started) > stableUptime {
+			backoff = minBackoff
+		}
+
+		workerRestarts.Inc()
+		zap.L().Error("worker exited", zap.Int("generation", w.gen), zap.Error(w.err), zap.Duration("backoff", backoff))
+		notify(systemd.Status(fmt.Sprintf("worker exited: %v; restarting in %s", w.err, backoff)))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		nw, err := s.startWorker(context.Background(), w.bin, w.version)
+		if err != nil {
+			// The next iteration retries with the dead worker.
+			zap.L().Error("restart worker", zap.Error(err))
+			continue
+		}
+
+		s.mu.Lock()
+		stale := s.current != w
+		if !stale {
+			s.current = nw
+		}
+		s.mu.Unlock()
+
+		if stale {
+			nw.retire()
+			continue
+		}
+
+		go func() {
+			if err := nw.waitReady(readyTimeout); err == nil {
+				notify(systemd.Status("serving " + nw.version + "; worker restarted"))
+			}
+		}()
+	}
+}
+
+// Upgrade replaces the current worker with `binPath` in `version`.
+//
+// 1. Runs the self-test of `binPath`
+// 2. Starts a new worker and waits until it is ready
+// 3. Retires the current worker
+// 4. Runs `hc` probes against the new worker on `bind`
+//
+// The result of the self-test is sent to `tested`, if not nil, so that
+// the caller can report it. A failed self-test is also returned as
+// *SelfTestError. If the new worker
+// fails to get ready, it is retired and the current one keeps serving.
+// If the probes fail, the previous binary is started again and
+// the failure is reported as *RollbackError.
+//
+// The steps are reported to `t`. The caller is expected to call
+// t.Begin before and t.Finish after the upgrade.
+func (s *Supervisor) Upgrade(ctx context.Context, t *upgrade.Tracker, binPath, version, tempBind, bind string, hc upgrade.HealthCheck, tested chan<- error) error {
+	ctx, span := tracing.Start(ctx, "supervisor.Upgrade", tracing.KindInternal, tracing.String("bin", binPath))
+	defer span.End()
+
+	fail := func(err error) error {
+		span.RecordError(err)
+		return err
+	}
+
+	_, err := upgrade.SelfTest(ctx, binPath, tempBind, bind)
+	if tested != nil {
+		tested <- err
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	t.Transition(upgrade.StateStarting, binPath)
+	notify(systemd.Reloading, systemd.Status("upgrading to "+binPath))
+	defer func() {
+		notify(systemd.Ready, systemd.Status("serving "+s.Version()))
+	}()
+
+	w, err := s.startWorker(ctx, binPath, version)
+	if err != nil {
+		return fail(fmt.Errorf("start worker: %w", err))
+	}
+
+	if err := w.waitReady(readyTimeout); err != nil {
+		w.retire()
+		return fail(fmt.Errorf("start worker: %w", err))
+	}
+
+	t.Transition(upgrade.StateStopping, "")
+	prev := s.swap(w)
+	prev.retire()
+
+	t.Transition(upgrade.StateProbing, "")
+	_, probeSpan := tracing.Start(ctx, "upgrade.HealthCheck", tracing.KindInternal, tracing.String("bind", bind))
+	err = hc.Run(bind)
+	probeSpan.RecordError(err)
+	probeSpan.End()
+
+	if err != nil {
+		err = fmt.Errorf("health check: %w", err)
+
+		if rErr := s.restore(ctx, prev.bin, prev.version); rErr != nil {
+			return fail(fmt.Errorf("%v; restore %s: %w", err, prev.bin, rErr))
+		}
+
+		return fail(&upgrade.RollbackError{Err: err})
+	}
+
+	return nil
+}
+
+// restore replaces the current worker with `bin` in `version`.
+//
+// The current worker keeps serving if the restored one fails to start.
+func (s *Supervisor) restore(ctx context.Context, bin, version string) error {
+	w, err := s.startWorker(ctx, bin, version)
+	if err != nil {
+		return err
+	}
+
+	if err := w.waitReady(readyTimeout); err != nil {
+		w.retire()
+		return err
+	}
+
+	s.swap(w).retire()
+
+	return nil
+}
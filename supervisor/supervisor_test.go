@@ -0,0 +1,128 @@
+package supervisor
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/xaxes/self-update/systemd"
+)
+
+// helperEnv selects the behaviour of the test binary run as a worker.
+const helperEnv = "SUPERVISOR_TEST_WORKER"
+
+// TestHelperProcess is not a real test. It is executed as a worker
+// by the tests below.
+func TestHelperProcess(t *testing.T) {
+	switch os.Getenv(helperEnv) {
+	case "ready":
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM)
+
+		if err := systemd.Notify(systemd.Ready); err != nil {
+			os.Exit(2)
+		}
+
+		<-sigs
+		os.Exit(0)
+	case "exit":
+		os.Exit(1)
+	}
+}
+
+func helper(t *testing.T, mode string) *Supervisor {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip(ErrUnsupported)
+	}
+
+	if err := os.Setenv(helperEnv, mode); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Unsetenv(helperEnv) })
+
+	return New([]string{"-test.run=TestHelperProcess"}, nil)
+}
+
+func TestSupervisor_Start(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		wantErr bool
+	}{
+		{
+			name: "ready",
+			mode: "ready",
+		},
+		{
+			name:    "exited",
+			mode:    "exit",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := helper(t, tt.mode)
+
+			err := s.Start(os.Args[0], "1.0.0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Start() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer s.worker().retire()
+
+			if got := s.Version(); got != "1.0.0" {
+				t.Errorf("Version() = %v, want %v", got, "1.0.0")
+			}
+		})
+	}
+}
+
+func TestSupervisor_Run(t *testing.T) {
+	s := helper(t, "ready")
+
+	if err := s.Start(os.Args[0], "1.0.0"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	crashed := s.worker()
+	if err := crashed.cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(minBackoff + 5*time.Second)
+	for s.worker() == crashed {
+		if time.Now().After(deadline) {
+			t.Fatal("worker not restarted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	restarted := s.worker()
+	if err := restarted.waitReady(5 * time.Second); err != nil {
+		t.Fatalf("restarted worker: %v", err)
+	}
+
+	cancel()
+	<-done
+
+	select {
+	case <-restarted.exited:
+	default:
+		t.Error("Run() did not retire the worker")
+	}
+}
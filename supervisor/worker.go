@@ -0,0 +1,199 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xaxes/self-update/systemd"
+	"github.com/xaxes/self-update/tracing"
+	"go.uber.org/zap"
+)
+
+// retireTimeout is the time a retired worker has to exit gracefully
+// before it is killed.
+const retireTimeout = 10 * time.Second
+
+var errNotReady = errors.New("worker not ready in time")
+
+// worker is a generation of the application process.
+type worker struct {
+	gen     int
+	bin     string
+	version string
+	started time.Time
+	cmd     *exec.Cmd
+
+	// conn receives the notifications of the worker.
+	conn *net.UnixConn
+	dir  string
+
+	readyOnce sync.Once
+	ready     chan struct{}
+
+	// err is the result of cmd.Wait, set before exited is closed.
+	err    error
+	exited chan struct{}
+}
+
+// environ returns the environment of a worker notifying `socket`.
+//
+// The variables of the service manager are replaced, so the worker
+// reports to the supervisor instead.
+func environ(ctx context.Context, socket string, listeners []systemd.Listener) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		switch {
+		case strings.HasPrefix(kv, systemd.NotifySocketEnv+"="),
+			strings.HasPrefix(kv, tracing.TraceparentEnv+"="):
+			continue
+		}
+
+		env = append(env, kv)
+	}
+
+	env = append(env, systemd.NotifySocketEnv+"="+socket)
+	if tp := tracing.Environ(ctx); tp != "" {
+		env = append(env, tp)
+	}
+
+	return append(env, systemd.Environ(listeners)...)
+}
+
+// startWorker executes `bin` as the next generation of workers.
+func (s *Supervisor) startWorker(ctx context.Context, bin, version string) (*worker, error) {
+	s.mu.Lock()
+	s.gen++
+	gen := s.gen
+	s.mu.Unlock()
+
+	dir, err := ioutil.TempDir("", "self-update")
+	if err != nil {
+		return nil, err
+	}
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("listen for notifications: %w", err)
+	}
+
+	cmd := exec.Command(bin, s.args...)
+	cmd.Env = environ(ctx, socket, s.listeners)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	for _, l := range s.listeners {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, l.File)
+	}
+
+	if err := cmd.Start(); err != nil {
+		conn.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	w := &worker{
+		gen:     gen,
+		bin:     bin,
+		version: version,
+		started: time.Now(),
+		cmd:     cmd,
+		conn:    conn,
+		dir:     dir,
+		ready:   make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+
+	zap.L().Info("start worker", zap.Int("generation", gen), zap.String("bin", bin), zap.Int("pid", cmd.Process.Pid))
+	workerGeneration.Set(float64(gen))
+
+	go w.listen()
+	go w.wait()
+
+	return w, nil
+}
+
+// listen consumes the notifications until the worker exits.
+func (w *worker) listen() {
+	b := make([]byte, 4096)
+
+	for {
+		n, err := w.conn.Read(b)
+		if err != nil {
+			return
+		}
+
+		for _, state := range strings.Split(string(b[:n]), "\n") {
+			switch {
+			case state == systemd.Ready:
+				w.readyOnce.Do(func() { close(w.ready) })
+			case strings.HasPrefix(state, "STATUS="):
+				zap.L().Info("worker status", zap.Int("generation", w.gen), zap.String("status", strings.TrimPrefix(state, "STATUS=")))
+			}
+		}
+	}
+}
+
+func (w *worker) wait() {
+	w.err = w.cmd.Wait()
+
+	w.conn.Close()
+	if err := os.RemoveAll(w.dir); err != nil {
+		zap.L().Error("remove worker notification socket", zap.Error(err))
+	}
+
+	close(w.exited)
+}
+
+// waitReady blocks until the worker announces readiness.
+func (w *worker) waitReady(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-w.exited:
+		return fmt.Errorf("worker exited: %v", w.err)
+	case <-timer.C:
+		return errNotReady
+	}
+}
+
+// retire asks the worker to exit and kills it if it does not
+// in retireTimeout.
+func (w *worker) retire() {
+	if w == nil {
+		return
+	}
+
+	zap.L().Info("retire worker", zap.Int("generation", w.gen), zap.Int("pid", w.cmd.Process.Pid))
+
+	if err := w.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		zap.L().Error("signal worker", zap.Error(err))
+	}
+
+	timer := time.NewTimer(retireTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.exited:
+		return
+	case <-timer.C:
+	}
+
+	if err := w.cmd.Process.Kill(); err != nil {
+		zap.L().Error("kill worker", zap.Error(err))
+	}
+	<-w.exited
+}
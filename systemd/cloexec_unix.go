@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package systemd
+
+import (
+	"os"
+	"syscall"
+)
+
+// closeOnExec prevents `f` from leaking into unrelated child processes.
+func closeOnExec(f *os.File) {
+	syscall.CloseOnExec(int(f.Fd()))
+}
+
+// PassOnExec makes `ls` inherited by the process image replacing this
+// one with exec(2) if `pass` is true, and closed by it otherwise.
+func PassOnExec(ls []Listener, pass bool) error {
+	for _, l := range ls {
+		if !pass {
+			closeOnExec(l.File)
+			continue
+		}
+
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, l.File.Fd(), syscall.F_SETFD, 0); errno != 0 {
+			return errno
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+package systemd
+
+import "os"
+
+// closeOnExec is a no-op; socket activation is not supported on Windows.
+func closeOnExec(f *os.File) {}
+
+// PassOnExec does nothing, as there are no passed sockets on Windows.
+func PassOnExec(ls []Listener, pass bool) error {
+	return nil
+}
@@ -0,0 +1,109 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Variables of the socket activation protocol.
+const (
+	ListenPIDEnv     = "LISTEN_PID"
+	ListenFDsEnv     = "LISTEN_FDS"
+	ListenFDNamesEnv = "LISTEN_FDNAMES"
+)
+
+// listenFDsStart is the first passed file descriptor, SD_LISTEN_FDS_START.
+const listenFDsStart = 3
+
+var errInvalidListenFDs = errors.New("invalid " + ListenFDsEnv)
+
+// listenFDs returns the number and names of the passed descriptors.
+//
+// Unlike sd_listen_fds(3), a missing LISTEN_PID is accepted: the upgraded
+// instance inherits the sockets from its predecessor, which cannot know
+// the PID before starting it.
+func listenFDs(getenv func(string) string, pid int) (int, []string, error) {
+	fds := getenv(ListenFDsEnv)
+	if fds == "" {
+		return 0, nil, nil
+	}
+
+	if p := getenv(ListenPIDEnv); p != "" {
+		parsed, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid %s: %w", ListenPIDEnv, err)
+		}
+
+		// The descriptors are meant for another process.
+		if parsed != pid {
+			return 0, nil, nil
+		}
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return 0, nil, errInvalidListenFDs
+	}
+
+	names := make([]string, n)
+	if v := getenv(ListenFDNamesEnv); v != "" {
+		copy(names, strings.Split(v, ":"))
+	}
+
+	return n, names, nil
+}
+
+// Listener is a socket passed by the service manager.
+type Listener struct {
+	Name string
+	File *os.File
+}
+
+// Listeners returns the sockets passed with the socket activation
+// protocol and unsets its variables, so children do not inherit them.
+func Listeners() ([]Listener, error) {
+	n, names, err := listenFDs(os.Getenv, os.Getpid())
+
+	for _, env := range []string{ListenPIDEnv, ListenFDsEnv, ListenFDNamesEnv} {
+		os.Unsetenv(env)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ls := make([]Listener, 0, n)
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(listenFDsStart+i), names[i])
+		closeOnExec(f)
+
+		ls = append(ls, Listener{
+			Name: names[i],
+			File: f,
+		})
+	}
+
+	return ls, nil
+}
+
+// Environ returns the variables passing `ls` to a child process.
+//
+// The files have to be passed as exec.Cmd.ExtraFiles in the same order.
+func Environ(ls []Listener) []string {
+	if len(ls) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(ls))
+	for _, l := range ls {
+		names = append(names, l.Name)
+	}
+
+	return []string{
+		ListenFDsEnv + "=" + strconv.Itoa(len(ls)),
+		ListenFDNamesEnv + "=" + strings.Join(names, ":"),
+	}
+}
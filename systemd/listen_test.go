@@ -0,0 +1,78 @@
+package systemd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_listenFDs(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		want      int
+		wantNames []string
+		wantErr   bool
+	}{
+		{
+			name: "not activated",
+			env:  map[string]string{},
+			want: 0,
+		},
+		{
+			name:      "activated",
+			env:       map[string]string{ListenPIDEnv: "42", ListenFDsEnv: "2", ListenFDNamesEnv: "http:admin"},
+			want:      2,
+			wantNames: []string{"http", "admin"},
+		},
+		{
+			name:      "inherited from predecessor",
+			env:       map[string]string{ListenFDsEnv: "1"},
+			want:      1,
+			wantNames: []string{""},
+		},
+		{
+			name: "meant for another process",
+			env:  map[string]string{ListenPIDEnv: "43", ListenFDsEnv: "1"},
+			want: 0,
+		},
+		{
+			name:    "invalid count",
+			env:     map[string]string{ListenPIDEnv: "42", ListenFDsEnv: "-1"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid PID",
+			env:     map[string]string{ListenPIDEnv: "alice", ListenFDsEnv: "1"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(k string) string { return tt.env[k] }
+
+			got, names, err := listenFDs(getenv, 42)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("listenFDs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("listenFDs() = %v, want %v", got, tt.want)
+			}
+			if tt.want > 0 && !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("listenFDs() names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	got := Environ([]Listener{{Name: "http"}, {Name: "admin"}})
+	want := []string{"LISTEN_FDS=2", "LISTEN_FDNAMES=http:admin"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ() = %v, want %v", got, want)
+	}
+
+	if got := Environ(nil); got != nil {
+		t.Errorf("Environ(nil) = %v, want nil", got)
+	}
+}
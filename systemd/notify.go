@@ -0,0 +1,62 @@
+// Package systemd implements the parts of the systemd service protocol
+// needed by `Type=notify` units: sd_notify(3) and socket activation,
+// sd_listen_fds(3).
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// NotifySocketEnv is the variable systemd passes the notification socket in.
+const NotifySocketEnv = "NOTIFY_SOCKET"
+
+// Notify sends the `VARIABLE=value` assignments to the service manager.
+//
+// It is a no-op returning nil if the service is not run by systemd
+// with the notification socket set.
+func Notify(states ...string) error {
+	path := os.Getenv(NotifySocketEnv)
+	if path == "" {
+		return nil
+	}
+
+	// Abstract namespace sockets start with a null byte.
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		conn.Close()
+		return err
+	}
+
+	return conn.Close()
+}
+
+// Ready is the state announcing that the service finished starting up.
+const Ready = "READY=1"
+
+// Reloading is the state announcing that the service is reloading;
+// it ends with Ready.
+const Reloading = "RELOADING=1"
+
+// Stopping is the state announcing that the service is shutting down.
+const Stopping = "STOPPING=1"
+
+// Status returns the state describing the service in free form.
+func Status(s string) string {
+	return "STATUS=" + strings.ReplaceAll(s, "\n", " ")
+}
+
+// MainPID returns the state changing the main process of the service.
+func MainPID(pid int) string {
+	return "MAINPID=" + strconv.Itoa(pid)
+}
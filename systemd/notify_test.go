@@ -0,0 +1,76 @@
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	defer os.Unsetenv(NotifySocketEnv)
+
+	tests := []struct {
+		name   string
+		socket string
+		states []string
+		want   string
+	}{
+		{
+			name:   "ready",
+			socket: path,
+			states: []string{Ready},
+			want:   "READY=1",
+		},
+		{
+			name:   "handover",
+			socket: path,
+			states: []string{MainPID(42), Status("handing over\nto 42")},
+			want:   "MAINPID=42\nSTATUS=handing over to 42",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(NotifySocketEnv, tt.socket)
+
+			if err := Notify(tt.states...); err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+
+			if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+
+			b := make([]byte, 1024)
+			n, err := conn.Read(b)
+			if err != nil {
+				t.Fatalf("read notification: %v", err)
+			}
+			if got := string(b[:n]); got != tt.want {
+				t.Errorf("Notify() sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("unset socket", func(t *testing.T) {
+		os.Unsetenv(NotifySocketEnv)
+		if err := Notify(Ready); err != nil {
+			t.Errorf("Notify() error = %v, want nil", err)
+		}
+	})
+}
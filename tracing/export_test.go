@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "traces.jsonl")
+	Setup(&FileExporter{Path: path}, "test", "1.0.0")
+
+	ctx, root := Start(context.Background(), "root", KindInternal)
+	_, child := Start(ctx, "child", KindInternal, String("alice", "bob"))
+	child.RecordError(errors.New("whatever"))
+	child.End()
+	root.End()
+	Flush()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req otlpRequest
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(b))), &req); err != nil {
+		t.Fatalf("unmarshal %s: %v", b, err)
+	}
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("len(spans) = %v, want %v", len(spans), 2)
+	}
+	if spans[0].Name != "child" || spans[0].ParentSpanID != root.SpanContext().SpanID.String() {
+		t.Errorf("spans[0] = %+v, want child of %v", spans[0], root.SpanContext().SpanID)
+	}
+	if spans[0].Status.Code != statusError {
+		t.Errorf("spans[0].Status = %+v, want error", spans[0].Status)
+	}
+	if spans[1].Name != "root" || spans[1].ParentSpanID != "" {
+		t.Errorf("spans[1] = %+v, want root", spans[1])
+	}
+}
@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+)
+
+const (
+	// TraceparentHeader carries the span context over HTTP.
+	TraceparentHeader = "traceparent"
+	// TraceparentEnv carries the span context to child processes.
+	TraceparentEnv = "TRACEPARENT"
+)
+
+// Inject adds the span context of `ctx` to `h`.
+func Inject(ctx context.Context, h http.Header) {
+	if sc := parentContext(ctx); sc.IsValid() {
+		h.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+// Extract returns `ctx` with the span context found in `h`, if any.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+
+	return ContextWithRemote(ctx, sc)
+}
+
+// Environ returns the environment variable carrying the span context
+// of `ctx` in the `key=value` form, or an empty string.
+func Environ(ctx context.Context) string {
+	sc := parentContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+
+	return TraceparentEnv + "=" + sc.Traceparent()
+}
+
+// FromEnv returns `ctx` with the span context passed by the parent
+// process, if any.
+func FromEnv(ctx context.Context) context.Context {
+	sc, err := ParseTraceparent(os.Getenv(TraceparentEnv))
+	if err != nil {
+		return ctx
+	}
+
+	return ContextWithRemote(ctx, sc)
+}
+
+// Middleware wraps `next` with a server span named after the request
+// and continues the trace of the caller.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := Extract(r.Context(), r.Header)
+
+		ctx, span := Start(ctx, r.Method+" "+r.URL.Path, KindServer,
+			String("http.method", r.Method),
+			String("http.target", r.RequestURI),
+		)
+		defer span.End()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
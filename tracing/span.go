@@ -0,0 +1,237 @@
+// Package tracing implements minimal distributed tracing compatible with
+// OpenTelemetry: W3C Trace Context propagation and OTLP/JSON export.
+//
+// See https://www.w3.org/TR/trace-context/ and
+// https://opentelemetry.io/docs/specs/otlp/.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext is the part of a span propagated across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent returns the W3C `traceparent` representation.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses the W3C `traceparent` representation.
+func ParseTraceparent(s string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, errInvalidTraceparent
+	}
+
+	var sc SpanContext
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	copy(sc.SpanID[:], spanID)
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, errInvalidTraceparent
+	}
+
+	return sc, nil
+}
+
+// Kind describes the relationship of the span to its peers.
+type Kind int
+
+// Kinds as defined by OTLP.
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attribute is a key-value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// String returns an attribute.
+func String(key, value string) Attribute {
+	return Attribute{key, value}
+}
+
+// Span is a single timed operation.
+type Span struct {
+	Name   string
+	Kind   Kind
+	Parent SpanID
+	Start  time.Time
+
+	ctx SpanContext
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes []Attribute
+	err        error
+}
+
+// SpanContext returns the propagated part of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.ctx
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attributes = append(s.attributes, attrs...)
+}
+
+// RecordError marks the span as failed with `err`. Nil is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+// End finishes the span and queues it for export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.ctx.Sampled {
+		enqueue(s)
+	}
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the span stored in `ctx` or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+type remoteKey struct{}
+
+// ContextWithRemote returns `ctx` with `sc`, received from another
+// process, as the parent of new spans.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// parentContext returns the span context new spans should descend from.
+func parentContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.ctx
+	}
+
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// Detach returns a background context carrying the span of `ctx`.
+//
+// It is useful for work outliving the request which started it.
+func Detach(ctx context.Context) context.Context {
+	if s := SpanFromContext(ctx); s != nil {
+		return context.WithValue(context.Background(), spanKey{}, s)
+	}
+
+	return ContextWithRemote(context.Background(), parentContext(ctx))
+}
+
+func randomID(b []byte) {
+	// crypto/rand does not fail on supported platforms.
+	_, _ = rand.Read(b)
+}
+
+// Start creates a span as a child of the span in `ctx`, if any.
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attribute) (context.Context, *Span) {
+	parent := parentContext(ctx)
+
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		attributes: attrs,
+	}
+
+	if parent.IsValid() {
+		s.ctx.TraceID = parent.TraceID
+		s.ctx.Sampled = parent.Sampled
+		s.Parent = parent.SpanID
+	} else {
+		randomID(s.ctx.TraceID[:])
+		s.ctx.Sampled = enabled()
+	}
+	randomID(s.ctx.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
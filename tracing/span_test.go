@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "sampled",
+			s:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name: "not sampled",
+			s:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		},
+		{
+			name: "future version with extra fields",
+			s:    "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-whatever",
+			want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:    "empty",
+			s:       "",
+			wantErr: true,
+		},
+		{
+			name:    "zero trace ID",
+			s:       "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "short span ID",
+			s:       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01",
+			wantErr: true,
+		},
+		{
+			name:    "long trace ID",
+			s:       "00-4bf92f3577b34da6a3ce929d0e0e473600-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "short trace ID",
+			s:       "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "odd-length trace ID",
+			s:       "00-4bf92f3577b34da6a3ce929d0e0e4736a-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "long span ID",
+			s:       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b700-01",
+			wantErr: true,
+		},
+		{
+			name:    "odd-length span ID",
+			s:       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01",
+			wantErr: true,
+		},
+		{
+			name:    "invalid version",
+			s:       "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTraceparent(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTraceparent() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Traceparent() != tt.want {
+				t.Errorf("ParseTraceparent() = %v, want %v", got.Traceparent(), tt.want)
+			}
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	ctx, root := Start(context.Background(), "root", KindInternal)
+	_, child := Start(ctx, "child", KindInternal)
+
+	if child.SpanContext().TraceID != root.SpanContext().TraceID {
+		t.Errorf("child trace ID = %v, want %v", child.SpanContext().TraceID, root.SpanContext().TraceID)
+	}
+	if child.Parent != root.SpanContext().SpanID {
+		t.Errorf("child parent = %v, want %v", child.Parent, root.SpanContext().SpanID)
+	}
+
+	h := http.Header{}
+	Inject(ctx, h)
+
+	_, remote := Start(Extract(context.Background(), h), "remote", KindServer)
+	if remote.SpanContext().TraceID != root.SpanContext().TraceID {
+		t.Errorf("remote trace ID = %v, want %v", remote.SpanContext().TraceID, root.SpanContext().TraceID)
+	}
+	if remote.Parent != root.SpanContext().SpanID {
+		t.Errorf("remote parent = %v, want %v", remote.Parent, root.SpanContext().SpanID)
+	}
+}
@@ -0,0 +1,91 @@
+package tuf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// canonical encodes the JSON document `raw` in the canonical form
+// signatures are made over: keys sorted, no insignificant whitespace
+// and only `"` and `\` escaped in strings.
+func canonical(raw []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := encodeCanonical(&buf, v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeCanonical(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case json.Number:
+		if _, err := v.Int64(); err != nil {
+			return fmt.Errorf("canonical JSON supports integers only, got %s", v)
+		}
+		buf.WriteString(v.String())
+	case string:
+		encodeString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeCanonical(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encodeString(buf, k)
+			buf.WriteByte(':')
+			if err := encodeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON value %T", v)
+	}
+
+	return nil
+}
+
+func encodeString(buf *bytes.Buffer, s string) {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	buf.WriteByte('"')
+	buf.WriteString(r.Replace(s))
+	buf.WriteByte('"')
+}
@@ -0,0 +1,39 @@
+package tuf
+
+import "testing"
+
+func Test_canonical(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "sorted keys",
+			in:   `{"b": [1, true, null], "a": {"d": "x", "c": -2}}`,
+			want: `{"a":{"c":-2,"d":"x"},"b":[1,true,null]}`,
+		},
+		{
+			name: "escapes",
+			in:   `{"s": "quote \" backslash \\ newline \n é"}`,
+			want: "{\"s\":\"quote \\\" backslash \\\\ newline \n é\"}",
+		},
+		{
+			name:    "float",
+			in:      `{"f": 1.5}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := canonical([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("canonical() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("canonical() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
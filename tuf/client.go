@@ -0,0 +1,459 @@
+// Package tuf is a client of repositories of The Update Framework.
+//
+// It updates the top-level root, timestamp, snapshot and targets
+// metadata as described in the specification, protecting against
+// rollback, freeze and mix-and-match attacks, and downloads targets
+// verified by the metadata. Delegations are not supported.
+//
+// See https://theupdateframework.github.io/specification/latest/.
+package tuf
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Size limits of metadata files without a length in other metadata.
+const (
+	maxRootSize      = 512 << 10
+	maxTimestampSize = 16 << 10
+	maxMetadataSize  = 4 << 20
+)
+
+// maxRootRotations limits the root versions fetched in one update.
+const maxRootRotations = 32
+
+var errNotFound = errors.New("not found")
+
+// Client updates the metadata of a repository serving `metadata/` and
+// `targets/` at its URL. Trusted metadata is kept in a local directory.
+type Client struct {
+	url  string
+	dir  string
+	http *http.Client
+
+	root      *root
+	timestamp *timestamp
+	snapshot  *snapshot
+	targets   *targets
+}
+
+// New returns a client of the repository at `url` keeping its metadata
+// in `dir`.
+//
+// Unless `dir` holds a root already, `rootPath` is the initially trusted
+// root; it has to be signed by its own keys.
+func New(url, dir, rootPath string) (*Client, error) {
+	c := &Client{
+		url:  strings.TrimSuffix(url, "/"),
+		dir:  dir,
+		http: &http.Client{Timeout: time.Minute},
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "root.json"))
+	if os.IsNotExist(err) {
+		b, err = ioutil.ReadFile(rootPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read trusted root: %w", err)
+	}
+
+	var s signed
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parse trusted root: %w", err)
+	}
+
+	// The expiry is checked once the root is updated.
+	var r root
+	if err := json.Unmarshal(s.Signed, &r); err != nil {
+		return nil, fmt.Errorf("parse trusted root: %w", err)
+	}
+	if err := verify(&s, &r, roleRoot, &r); err != nil {
+		return nil, fmt.Errorf("trusted root: %w", err)
+	}
+
+	c.root = &r
+	if err := c.save("root.json", b); err != nil {
+		return nil, err
+	}
+
+	// Cached metadata only serves to detect rollbacks; it is ignored if
+	// it does not verify anymore.
+	var ts timestamp
+	if c.loadCached(roleTimestamp, &ts) {
+		c.timestamp = &ts
+	}
+	var sn snapshot
+	if c.loadCached(roleSnapshot, &sn) {
+		c.snapshot = &sn
+	}
+	var ta targets
+	if c.loadCached(roleTargets, &ta) {
+		c.targets = &ta
+	}
+
+	return c, nil
+}
+
+func (c *Client) loadCached(name string, v interface{}) bool {
+	b, err := ioutil.ReadFile(filepath.Join(c.dir, name+".json"))
+	if err != nil {
+		return false
+	}
+
+	var s signed
+	if err := json.Unmarshal(b, &s); err != nil {
+		return false
+	}
+
+	return verify(&s, c.root, name, v) == nil
+}
+
+func (c *Client) save(name string, b []byte) error {
+	tmp := filepath.Join(c.dir, "."+name)
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(c.dir, name))
+}
+
+// fetch downloads `name` from the repository, up to `max` bytes.
+func (c *Client) fetch(ctx context.Context, name string, max int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", name, errNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: %s", name, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("%s: exceeds %d bytes", name, max)
+	}
+
+	return b, nil
+}
+
+// Update updates the trusted metadata from the repository.
+func (c *Client) Update(ctx context.Context) error {
+	now := time.Now()
+
+	if err := c.updateRoot(ctx, now); err != nil {
+		return err
+	}
+	if err := c.updateTimestamp(ctx, now); err != nil {
+		return err
+	}
+	if err := c.updateSnapshot(ctx, now); err != nil {
+		return err
+	}
+
+	return c.updateTargets(ctx, now)
+}
+
+func (c *Client) updateRoot(ctx context.Context, now time.Time) error {
+	for i := 0; i < maxRootRotations; i++ {
+		next := c.root.Version + 1
+
+		b, err := c.fetch(ctx, fmt.Sprintf("metadata/%d.root.json", next), maxRootSize)
+		if errors.Is(err, errNotFound) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var s signed
+		if err := json.Unmarshal(b, &s); err != nil {
+			return fmt.Errorf("root %d: %w", next, err)
+		}
+
+		// The new root has to be signed by the keys of both versions.
+		var r root
+		if err := verify(&s, c.root, roleRoot, &r); err != nil {
+			return fmt.Errorf("root %d: %w", next, err)
+		}
+		if err := verify(&s, &r, roleRoot, &r); err != nil {
+			return fmt.Errorf("root %d: %w", next, err)
+		}
+		if r.Type != roleRoot {
+			return fmt.Errorf("root %d: unexpected type %q", next, r.Type)
+		}
+		if r.Version != next {
+			return fmt.Errorf("root %d: %w: version %d", next, ErrRollback, r.Version)
+		}
+
+		// Metadata signed by rotated keys is not trusted anymore.
+		for _, name := range []string{roleTimestamp, roleSnapshot} {
+			if keysOf(c.root, name) != keysOf(&r, name) {
+				c.timestamp, c.snapshot = nil, nil
+				os.Remove(filepath.Join(c.dir, "timestamp.json"))
+				os.Remove(filepath.Join(c.dir, "snapshot.json"))
+			}
+		}
+
+		zap.L().Info("tuf: rotate root", zap.Int64("version", r.Version))
+
+		c.root = &r
+		if err := c.save("root.json", b); err != nil {
+			return err
+		}
+	}
+
+	return c.root.check(roleRoot, now)
+}
+
+func (c *Client) updateTimestamp(ctx context.Context, now time.Time) error {
+	b, err := c.fetch(ctx, "metadata/timestamp.json", maxTimestampSize)
+	if err != nil {
+		return err
+	}
+
+	var s signed
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("timestamp: %w", err)
+	}
+
+	var ts timestamp
+	if err := verify(&s, c.root, roleTimestamp, &ts); err != nil {
+		return err
+	}
+
+	if _, ok := ts.Meta["snapshot.json"]; !ok {
+		return fmt.Errorf("timestamp: no snapshot.json")
+	}
+
+	if old := c.timestamp; old != nil {
+		switch {
+		case ts.Version < old.Version:
+			return fmt.Errorf("timestamp: %w: version %d, trusted %d", ErrRollback, ts.Version, old.Version)
+		case ts.Meta["snapshot.json"].Version < old.Meta["snapshot.json"].Version:
+			return fmt.Errorf("snapshot: %w: version %d, trusted %d", ErrRollback, ts.Meta["snapshot.json"].Version, old.Meta["snapshot.json"].Version)
+		}
+	}
+
+	if err := ts.check(roleTimestamp, now); err != nil {
+		return err
+	}
+
+	c.timestamp = &ts
+	return c.save("timestamp.json", b)
+}
+
+// fetchMeta downloads the metadata file `name` described by `m`.
+func (c *Client) fetchMeta(ctx context.Context, name string, m metaFile) ([]byte, error) {
+	file := name + ".json"
+	if c.root.ConsistentSnapshot {
+		file = fmt.Sprintf("%d.%s.json", m.Version, name)
+	}
+
+	max := int64(maxMetadataSize)
+	if m.Length > 0 {
+		max = m.Length
+	}
+
+	b, err := c.fetch(ctx, "metadata/"+file, max)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkFile(b, m.Length, m.Hashes); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return b, nil
+}
+
+func (c *Client) updateSnapshot(ctx context.Context, now time.Time) error {
+	m := c.timestamp.Meta["snapshot.json"]
+
+	// Unchanged snapshots are not downloaded again.
+	if c.snapshot != nil && c.snapshot.Version == m.Version {
+		return c.snapshot.check(roleSnapshot, now)
+	}
+
+	b, err := c.fetchMeta(ctx, roleSnapshot, m)
+	if err != nil {
+		return err
+	}
+
+	var s signed
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	var sn snapshot
+	if err := verify(&s, c.root, roleSnapshot, &sn); err != nil {
+		return err
+	}
+
+	if sn.Version != m.Version {
+		return fmt.Errorf("snapshot: %w: version %d, timestamp lists %d", ErrMismatch, sn.Version, m.Version)
+	}
+
+	if old := c.snapshot; old != nil {
+		for name, om := range old.Meta {
+			nm, ok := sn.Meta[name]
+			if !ok || nm.Version < om.Version {
+				return fmt.Errorf("%s: %w: version %d, trusted %d", name, ErrRollback, nm.Version, om.Version)
+			}
+		}
+	}
+
+	if _, ok := sn.Meta["targets.json"]; !ok {
+		return fmt.Errorf("snapshot: no targets.json")
+	}
+
+	if err := sn.check(roleSnapshot, now); err != nil {
+		return err
+	}
+
+	c.snapshot = &sn
+	return c.save("snapshot.json", b)
+}
+
+func (c *Client) updateTargets(ctx context.Context, now time.Time) error {
+	m := c.snapshot.Meta["targets.json"]
+
+	if c.targets != nil && c.targets.Version == m.Version {
+		return c.targets.check(roleTargets, now)
+	}
+
+	b, err := c.fetchMeta(ctx, roleTargets, m)
+	if err != nil {
+		return err
+	}
+
+	var s signed
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("targets: %w", err)
+	}
+
+	var ta targets
+	if err := verify(&s, c.root, roleTargets, &ta); err != nil {
+		return err
+	}
+
+	if ta.Version != m.Version {
+		return fmt.Errorf("targets: %w: version %d, snapshot lists %d", ErrMismatch, ta.Version, m.Version)
+	}
+
+	if err := ta.check(roleTargets, now); err != nil {
+		return err
+	}
+
+	c.targets = &ta
+	return c.save("targets.json", b)
+}
+
+// Targets returns the targets of the trusted metadata by path.
+//
+// Update has to succeed first.
+func (c *Client) Targets() map[string]TargetFile {
+	if c.targets == nil {
+		return nil
+	}
+
+	return c.targets.Targets
+}
+
+// Download writes the target at `target` to `w`.
+//
+// The target is verified against its length and SHA-256; `w` has to be
+// discarded if an error is returned.
+func (c *Client) Download(ctx context.Context, target string, w io.Writer) error {
+	tf, ok := c.Targets()[target]
+	if !ok {
+		return fmt.Errorf("target %s: %w", target, errNotFound)
+	}
+
+	want, ok := tf.Hashes["sha256"]
+	if !ok {
+		return fmt.Errorf("target %s: no sha256 hash", target)
+	}
+
+	name := target
+	if c.root.ConsistentSnapshot {
+		name = path.Join(path.Dir(target), want+"."+path.Base(target))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/targets/"+name, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("target %s: %s", target, resp.Status)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(resp.Body, tf.Length+1))
+	if err != nil {
+		return err
+	}
+
+	if n != tf.Length {
+		return fmt.Errorf("target %s: %w: length %d, want %d", target, ErrMismatch, n, tf.Length)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("target %s: %w: SHA-256 %s, want %s", target, ErrMismatch, got, want)
+	}
+
+	return nil
+}
+
+// checkFile verifies `b` against the length and the hashes of metadata
+// describing it, if set.
+func checkFile(b []byte, length int64, hashes map[string]string) error {
+	if length > 0 && int64(len(b)) != length {
+		return fmt.Errorf("%w: length %d, want %d", ErrMismatch, len(b), length)
+	}
+
+	if want, ok := hashes["sha256"]; ok {
+		sum := sha256.Sum256(b)
+		if got := hex.EncodeToString(sum[:]); got != want {
+			return fmt.Errorf("%w: SHA-256 %s, want %s", ErrMismatch, got, want)
+		}
+	}
+
+	return nil
+}
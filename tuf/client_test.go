@@ -0,0 +1,391 @@
+package tuf
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xaxes/self-update/remote/remotetest"
+)
+
+type testKey struct {
+	id   string
+	key  key
+	priv ed25519.PrivateKey
+}
+
+func newTestKey(t *testing.T) testKey {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := key{Type: "ed25519", Scheme: "ed25519"}
+	k.Value.Public = hex.EncodeToString(pub)
+
+	b, err := json.Marshal(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := canonical(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(c)
+
+	return testKey{hex.EncodeToString(sum[:]), k, priv}
+}
+
+// testRepo is a repository served from a temporary directory.
+type testRepo struct {
+	t   *testing.T
+	dir string
+	srv *httptest.Server
+
+	keys      map[string][]testKey // by role
+	threshold map[string]int
+	versions  map[string]int64
+	expires   map[string]time.Time
+	targets   map[string][]byte
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+
+	dir := remotetest.TempDir(t, "tuf-repo")
+	r := &testRepo{
+		t:         t,
+		dir:       dir,
+		srv:       remotetest.NewServer(t, http.FileServer(http.Dir(dir)).ServeHTTP),
+		keys:      make(map[string][]testKey),
+		threshold: make(map[string]int),
+		versions:  make(map[string]int64),
+		expires:   make(map[string]time.Time),
+		targets:   map[string][]byte{"self-update-1.1.0": []byte("#!/bin/sh\necho 1.1.0\n")},
+	}
+
+	for _, name := range []string{roleRoot, roleTimestamp, roleSnapshot, roleTargets} {
+		r.keys[name] = []testKey{newTestKey(t)}
+		r.threshold[name] = 1
+		r.versions[name] = 1
+		r.expires[name] = time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	}
+
+	return r
+}
+
+func (r *testRepo) sign(v interface{}, keys ...testKey) []byte {
+	r.t.Helper()
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	msg, err := canonical(raw)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	s := signed{Signed: raw}
+	for _, k := range keys {
+		s.Signatures = append(s.Signatures, signature{k.id, hex.EncodeToString(ed25519.Sign(k.priv, msg))})
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	return b
+}
+
+func (r *testRepo) write(name string, b []byte) {
+	r.t.Helper()
+
+	path := filepath.Join(r.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func (r *testRepo) header(name, typ string) header {
+	return header{Type: typ, Version: r.versions[name], Expires: r.expires[name]}
+}
+
+// publishRoot publishes the current root signed by `signers`, or by its
+// own keys if none are given, and returns it.
+func (r *testRepo) publishRoot(signers ...testKey) []byte {
+	r.t.Helper()
+
+	ro := root{
+		header: r.header(roleRoot, roleRoot),
+		Keys:   make(map[string]key),
+		Roles:  make(map[string]role),
+	}
+	for name, keys := range r.keys {
+		rl := role{Threshold: r.threshold[name]}
+		for _, k := range keys {
+			ro.Keys[k.id] = k.key
+			rl.KeyIDs = append(rl.KeyIDs, k.id)
+		}
+		ro.Roles[name] = rl
+	}
+
+	if len(signers) == 0 {
+		signers = r.keys[roleRoot]
+	}
+
+	b := r.sign(ro, signers...)
+	r.write("metadata/"+itoa(ro.Version)+".root.json", b)
+
+	return b
+}
+
+// publish publishes the targets, snapshot and timestamp.
+func (r *testRepo) publish() {
+	r.t.Helper()
+
+	ta := targets{header: r.header(roleTargets, roleTargets), Targets: make(map[string]TargetFile)}
+	for name, b := range r.targets {
+		sum := sha256.Sum256(b)
+		ta.Targets[name] = TargetFile{
+			Length: int64(len(b)),
+			Hashes: map[string]string{"sha256": hex.EncodeToString(sum[:])},
+			Custom: json.RawMessage(`{"version": "1.1.0"}`),
+		}
+		r.write("targets/"+name, b)
+	}
+	tb := r.sign(ta, r.keys[roleTargets]...)
+	r.write("metadata/targets.json", tb)
+
+	sn := snapshot{
+		header: r.header(roleSnapshot, roleSnapshot),
+		Meta:   map[string]metaFile{"targets.json": {Version: r.versions[roleTargets]}},
+	}
+	sb := r.sign(sn, r.keys[roleSnapshot]...)
+	r.write("metadata/snapshot.json", sb)
+
+	sum := sha256.Sum256(sb)
+	ts := timestamp{
+		header: r.header(roleTimestamp, roleTimestamp),
+		Meta: map[string]metaFile{"snapshot.json": {
+			Version: r.versions[roleSnapshot],
+			Length:  int64(len(sb)),
+			Hashes:  map[string]string{"sha256": hex.EncodeToString(sum[:])},
+		}},
+	}
+	r.write("metadata/timestamp.json", r.sign(ts, r.keys[roleTimestamp]...))
+}
+
+func itoa(i int64) string {
+	b, _ := json.Marshal(i)
+	return string(b)
+}
+
+// client returns a client trusting `rootJSON` with its metadata in `dir`.
+func (r *testRepo) client(dir string, rootJSON []byte) *Client {
+	r.t.Helper()
+
+	rootPath := filepath.Join(dir, "initial-root.json")
+	if err := ioutil.WriteFile(rootPath, rootJSON, 0644); err != nil {
+		r.t.Fatal(err)
+	}
+
+	c, err := New(r.srv.URL, filepath.Join(dir, "tuf"), rootPath)
+	if err != nil {
+		r.t.Fatalf("New() error = %v", err)
+	}
+
+	return c
+}
+
+func TestClient(t *testing.T) {
+	tests := []struct {
+		name string
+		// change modifies the repository after the first update.
+		change  func(r *testRepo)
+		wantErr error
+	}{
+		{
+			name:   "no changes",
+			change: func(r *testRepo) {},
+		},
+		{
+			name: "new targets",
+			change: func(r *testRepo) {
+				r.targets["self-update-1.2.0"] = []byte("1.2.0")
+				r.versions[roleTargets]++
+				r.versions[roleSnapshot]++
+				r.versions[roleTimestamp]++
+				r.publish()
+			},
+		},
+		{
+			name: "expired timestamp",
+			change: func(r *testRepo) {
+				r.versions[roleTimestamp]++
+				r.expires[roleTimestamp] = time.Now().Add(-time.Hour).UTC()
+				r.publish()
+			},
+			wantErr: ErrExpired,
+		},
+		{
+			name: "timestamp rollback",
+			change: func(r *testRepo) {
+				r.versions[roleTimestamp] = 0
+				r.publish()
+			},
+			wantErr: ErrRollback,
+		},
+		{
+			name: "snapshot rollback",
+			change: func(r *testRepo) {
+				r.versions[roleTimestamp]++
+				r.versions[roleSnapshot] = 0
+				r.publish()
+			},
+			wantErr: ErrRollback,
+		},
+		{
+			name: "mix and match",
+			change: func(r *testRepo) {
+				// Previous targets served with a new snapshot.
+				old, err := ioutil.ReadFile(filepath.Join(r.dir, "metadata", "targets.json"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				r.versions[roleTimestamp]++
+				r.versions[roleSnapshot]++
+				r.versions[roleTargets]++
+				r.publish()
+				r.write("metadata/targets.json", old)
+			},
+			wantErr: ErrMismatch,
+		},
+		{
+			name: "untrusted key",
+			change: func(r *testRepo) {
+				r.versions[roleTimestamp]++
+				trusted := r.keys[roleTimestamp]
+				r.keys[roleTimestamp] = []testKey{newTestKey(t)}
+				r.publish()
+				r.keys[roleTimestamp] = trusted
+			},
+			wantErr: ErrSignature,
+		},
+		{
+			name: "threshold",
+			change: func(r *testRepo) {
+				// The root requires two snapshot signatures; one key signs.
+				r.keys[roleSnapshot] = append(r.keys[roleSnapshot], newTestKey(t))
+				r.threshold[roleSnapshot] = 2
+				r.versions[roleRoot]++
+				r.publishRoot()
+
+				r.keys[roleSnapshot] = r.keys[roleSnapshot][:1]
+				r.versions[roleTimestamp]++
+				r.versions[roleSnapshot]++
+				r.publish()
+			},
+			wantErr: ErrSignature,
+		},
+		{
+			name: "key rotation",
+			change: func(r *testRepo) {
+				old := r.keys[roleRoot]
+				r.keys[roleRoot] = []testKey{newTestKey(t)}
+				r.keys[roleTimestamp] = []testKey{newTestKey(t)}
+				r.versions[roleRoot]++
+				r.publishRoot(append(old, r.keys[roleRoot]...)...)
+
+				r.versions[roleTimestamp]++
+				r.publish()
+			},
+		},
+		{
+			name: "rotation not signed by the previous root",
+			change: func(r *testRepo) {
+				r.keys[roleRoot] = []testKey{newTestKey(t)}
+				r.versions[roleRoot]++
+				r.publishRoot()
+			},
+			wantErr: ErrSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t)
+
+			rootJSON := r.publishRoot()
+			r.publish()
+
+			dir := remotetest.TempDir(t, "tuf-client")
+
+			c := r.client(dir, rootJSON)
+			if err := c.Update(context.Background()); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+
+			tt.change(r)
+
+			// A restarted client remembers the trusted metadata.
+			c = r.client(dir, rootJSON)
+			err := c.Update(context.Background())
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("Update() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			for name, want := range r.targets {
+				var buf bytes.Buffer
+				if err := c.Download(context.Background(), name, &buf); err != nil {
+					t.Fatalf("Download(%s) error = %v", name, err)
+				}
+				if !bytes.Equal(buf.Bytes(), want) {
+					t.Errorf("Download(%s) = %q, want %q", name, buf.Bytes(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_Download(t *testing.T) {
+	r := newTestRepo(t)
+
+	rootJSON := r.publishRoot()
+	r.publish()
+
+	dir := remotetest.TempDir(t, "tuf-client")
+
+	c := r.client(dir, rootJSON)
+	if err := c.Update(context.Background()); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	// The target is replaced after the metadata was published.
+	r.write("targets/self-update-1.1.0", []byte("#!/bin/sh\necho 9.9.9\n"))
+
+	err := c.Download(context.Background(), "self-update-1.1.0", ioutil.Discard)
+	if !errors.Is(err, ErrMismatch) {
+		t.Errorf("Download() error = %v, want %v", err, ErrMismatch)
+	}
+}
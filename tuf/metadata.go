@@ -0,0 +1,178 @@
+package tuf
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Roles of the top-level metadata.
+const (
+	roleRoot      = "root"
+	roleTargets   = "targets"
+	roleSnapshot  = "snapshot"
+	roleTimestamp = "timestamp"
+)
+
+var (
+	// ErrExpired is returned for metadata past its expiry, e.g. served
+	// by a frozen mirror.
+	ErrExpired = errors.New("metadata expired")
+	// ErrRollback is returned for metadata older than the trusted one.
+	ErrRollback = errors.New("metadata rolled back")
+	// ErrSignature is returned for metadata not signed by the threshold
+	// of its role's keys.
+	ErrSignature = errors.New("metadata not signed by the threshold of keys")
+	// ErrMismatch is returned for files which differ from the metadata
+	// describing them.
+	ErrMismatch = errors.New("file does not match its metadata")
+)
+
+// signed is the envelope of all metadata.
+type signed struct {
+	Signatures []signature     `json:"signatures"`
+	Signed     json.RawMessage `json:"signed"`
+}
+
+type signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// key is a public key; only ed25519 keys are supported.
+type key struct {
+	Type   string `json:"keytype"`
+	Scheme string `json:"scheme"`
+	Value  struct {
+		Public string `json:"public"`
+	} `json:"keyval"`
+}
+
+type role struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+// header is common to all metadata.
+type header struct {
+	Type    string    `json:"_type"`
+	Version int64     `json:"version"`
+	Expires time.Time `json:"expires"`
+}
+
+type root struct {
+	header
+	Keys               map[string]key  `json:"keys"`
+	Roles              map[string]role `json:"roles"`
+	ConsistentSnapshot bool            `json:"consistent_snapshot"`
+}
+
+// metaFile describes a metadata file in the snapshot and timestamp.
+type metaFile struct {
+	Version int64             `json:"version"`
+	Length  int64             `json:"length,omitempty"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+}
+
+type timestamp struct {
+	header
+	Meta map[string]metaFile `json:"meta"`
+}
+
+type snapshot struct {
+	header
+	Meta map[string]metaFile `json:"meta"`
+}
+
+// TargetFile describes a target.
+type TargetFile struct {
+	Length int64             `json:"length"`
+	Hashes map[string]string `json:"hashes"`
+	Custom json.RawMessage   `json:"custom,omitempty"`
+}
+
+type targets struct {
+	header
+	Targets map[string]TargetFile `json:"targets"`
+}
+
+// verify checks that `s` is signed by the threshold of the keys of
+// `name` in `r` and decodes it into `v`.
+func verify(s *signed, r *root, name string, v interface{}) error {
+	ro, ok := r.Roles[name]
+	if !ok || ro.Threshold < 1 {
+		return fmt.Errorf("%s: no %s role in root", name, name)
+	}
+
+	msg, err := canonical(s.Signed)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	allowed := make(map[string]bool, len(ro.KeyIDs))
+	for _, id := range ro.KeyIDs {
+		allowed[id] = true
+	}
+
+	// Keys are counted once, also if listed under several IDs.
+	valid := make(map[string]bool)
+	for _, sig := range s.Signatures {
+		k, ok := r.Keys[sig.KeyID]
+		if !allowed[sig.KeyID] || !ok || k.Type != "ed25519" || k.Scheme != "ed25519" {
+			continue
+		}
+
+		pub, err := hex.DecodeString(k.Value.Public)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			continue
+		}
+
+		b, err := hex.DecodeString(sig.Sig)
+		if err != nil {
+			continue
+		}
+
+		if ed25519.Verify(pub, msg, b) {
+			valid[k.Value.Public] = true
+		}
+	}
+
+	if len(valid) < ro.Threshold {
+		return fmt.Errorf("%s: %w: %d of %d", name, ErrSignature, len(valid), ro.Threshold)
+	}
+
+	if err := json.Unmarshal(s.Signed, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	return nil
+}
+
+// check verifies the type and the expiry of the metadata.
+func (h header) check(typ string, now time.Time) error {
+	if h.Type != typ {
+		return fmt.Errorf("%s: unexpected type %q", typ, h.Type)
+	}
+
+	if !now.Before(h.Expires) {
+		return fmt.Errorf("%s: %w at %s", typ, ErrExpired, h.Expires.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// keysOf describes the keys and the threshold of `name` for comparing
+// roles of different root versions.
+func keysOf(r *root, name string) string {
+	ro := r.Roles[name]
+
+	var pubs []string
+	for _, id := range ro.KeyIDs {
+		pubs = append(pubs, r.Keys[id].Value.Public)
+	}
+
+	return fmt.Sprint(ro.Threshold, pubs)
+}
@@ -0,0 +1,101 @@
+package tuf
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/Masterminds/semver"
+	"github.com/xaxes/self-update/remote"
+	"go.uber.org/zap"
+)
+
+// Source provides the targets of a repository as releases.
+//
+// Targets are releases if their path matches the pattern and their
+// custom metadata has a version, e.g. `{"version": "1.2.0"}`.
+type Source struct {
+	client  *Client
+	pattern string
+}
+
+// NewSource returns the source of the targets of `c` matching `pattern`,
+// see path.Match; all targets match an empty pattern.
+func NewSource(c *Client, pattern string) *Source {
+	return &Source{client: c, pattern: pattern}
+}
+
+// Releases implements remote.Source. The metadata is updated first.
+func (s *Source) Releases(ctx context.Context) ([]remote.Release, error) {
+	if err := s.client.Update(ctx); err != nil {
+		return nil, err
+	}
+
+	var rs []remote.Release
+	for p, tf := range s.client.Targets() {
+		if s.pattern != "" {
+			if ok, err := path.Match(s.pattern, p); err != nil || !ok {
+				continue
+			}
+		}
+
+		var custom struct {
+			Version string `json:"version"`
+		}
+		if len(tf.Custom) > 0 {
+			if err := json.Unmarshal(tf.Custom, &custom); err != nil {
+				zap.L().Debug("tuf: skip target", zap.String("target", p), zap.Error(err))
+				continue
+			}
+		}
+
+		v, err := semver.NewVersion(custom.Version)
+		if err != nil {
+			zap.L().Debug("tuf: skip target without version", zap.String("target", p), zap.Error(err))
+			continue
+		}
+
+		rs = append(rs, remote.Release{
+			Name:    path.Base(p),
+			Ref:     p,
+			Version: v,
+			Size:    tf.Length,
+			SHA256:  tf.Hashes["sha256"],
+		})
+	}
+	sort.Slice(rs, func(a, b int) bool { return rs[a].Ref < rs[b].Ref })
+
+	return rs, nil
+}
+
+// Companions implements remote.CompanionSource. They are the targets
+// whose path is the one of `r` with a companion extension, e.g. its
+// .bundle, and are verified like any target.
+func (s *Source) Companions(ctx context.Context, r remote.Release) ([]remote.Release, error) {
+	targets := s.client.Targets()
+
+	var comps []remote.Release
+	for _, ext := range remote.Companions {
+		tf, ok := targets[r.Ref+ext]
+		if !ok {
+			continue
+		}
+
+		comps = append(comps, remote.Release{
+			Name:    path.Base(r.Ref + ext),
+			Ref:     r.Ref + ext,
+			Version: r.Version,
+			Size:    tf.Length,
+			SHA256:  tf.Hashes["sha256"],
+		})
+	}
+
+	return comps, nil
+}
+
+// Download implements remote.Source.
+func (s *Source) Download(ctx context.Context, r remote.Release, w io.Writer) error {
+	return s.client.Download(ctx, r.Ref, w)
+}
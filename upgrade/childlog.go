@@ -0,0 +1,158 @@
+package upgrade
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ChildLog is the file the output of the upgraded instance is appended to.
+//
+// If empty, the upgraded instance inherits the standard output and error
+// of this process.
+var ChildLog string
+
+const (
+	// tailLines is the number of the most recent lines kept for error reports.
+	tailLines = 20
+	// tailInterval is the time between consecutive reads of the log.
+	tailInterval = 200 * time.Millisecond
+)
+
+// childOutput returns the file the upgraded instance writes its output to.
+//
+// The caller is expected to close it once the instance is started.
+func childOutput() (*os.File, error) {
+	if ChildLog == "" {
+		return nil, nil
+	}
+
+	return os.OpenFile(ChildLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+}
+
+// tail follows the lines appended to a file and keeps the most recent ones.
+type tail struct {
+	path   string
+	offset int64
+
+	mu      sync.Mutex
+	partial []byte
+	lines   []string
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// tailChildLog starts following ChildLog from its current end, so only
+// the output of the instance started afterwards is considered.
+//
+// It returns nil if the output is inherited; methods of a nil *tail
+// are no-ops.
+func tailChildLog() *tail {
+	if ChildLog == "" {
+		return nil
+	}
+
+	t := &tail{
+		path:    ChildLog,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if fi, err := os.Stat(ChildLog); err == nil {
+		t.offset = fi.Size()
+	}
+
+	go t.follow()
+
+	return t
+}
+
+func (t *tail) follow() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(tailInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.read()
+		case <-t.done:
+			t.read()
+			return
+		}
+	}
+}
+
+// read consumes the data appended since the previous read.
+func (t *tail) read() {
+	f, err := os.Open(t.path)
+	if err != nil {
+		// The file is created by startInstance.
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		zap.L().Error("tail upgraded instance log", zap.Error(err))
+		return
+	}
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		zap.L().Error("tail upgraded instance log", zap.Error(err))
+	}
+	t.offset += int64(len(b))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b = append(t.partial, b...)
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := string(b[:i])
+		b = b[i+1:]
+
+		zap.L().Info("upgraded instance", zap.String("line", line))
+
+		t.lines = append(t.lines, line)
+		if len(t.lines) > tailLines {
+			t.lines = t.lines[len(t.lines)-tailLines:]
+		}
+	}
+	t.partial = append([]byte(nil), b...)
+}
+
+// stop stops following the file and returns the most recent lines.
+func (t *tail) stop() []string {
+	if t == nil {
+		return nil
+	}
+
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+	<-t.stopped
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := t.lines
+	if len(t.partial) > 0 {
+		lines = append(lines, string(t.partial))
+	}
+
+	return lines
+}
@@ -0,0 +1,55 @@
+package upgrade
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_tail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "childlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ChildLog = filepath.Join(dir, "child.log")
+	defer func() { ChildLog = "" }()
+
+	if err := ioutil.WriteFile(ChildLog, []byte("previous instance\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tl := tailChildLog()
+
+	f, err := childOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var want []string
+	for i := 0; i < tailLines+5; i++ {
+		fmt.Fprintf(f, "line %d\n", i)
+		if i >= 5 {
+			want = append(want, fmt.Sprintf("line %d", i))
+		}
+	}
+	fmt.Fprint(f, "partial")
+	want = append(want, "partial")
+
+	if got := tl.stop(); !reflect.DeepEqual(got, want) {
+		t.Errorf("tail.stop() = %v, want %v", got, want)
+	}
+
+	// stopping twice is allowed
+	tl.stop()
+
+	var nilTail *tail
+	if got := nilTail.stop(); got != nil {
+		t.Errorf("nil tail.stop() = %v, want nil", got)
+	}
+}
@@ -0,0 +1,51 @@
+package upgrade
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// stoppedAtEnv passes the time the outgoing server was stopped at,
+// in Unix nanoseconds, to the upgraded instance.
+const stoppedAtEnv = "SELF_UPDATE_STOPPED_AT"
+
+// handoff is the write end of the upgraded instance's stdin.
+//
+// It stays open for the lifetime of this process, so the upgraded
+// instance reads EOF once the outgoing instance exits.
+var handoff *os.File
+
+// WaitParent blocks until the outgoing instance which started this
+// one exits.
+//
+// It is meant to be called by the upgraded instance only, as it
+// consumes the standard input.
+func WaitParent() {
+	// Any error, including EOF, means that the pipe is closed.
+	_, _ = io.Copy(ioutil.Discard, os.Stdin)
+}
+
+// ObserveHandoff records the time elapsed since the outgoing instance
+// stopped its server.
+//
+// It is meant to be called by the upgraded instance when it starts
+// its own server.
+func ObserveHandoff() {
+	v := os.Getenv(stoppedAtEnv)
+	if v == "" {
+		return
+	}
+
+	ns, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		zap.L().Error("parse "+stoppedAtEnv, zap.Error(err))
+		return
+	}
+
+	handoffDowntime.ObserveDuration(time.Since(time.Unix(0, ns)))
+}
@@ -0,0 +1,277 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xaxes/self-update/systemd"
+	"github.com/xaxes/self-update/tracing"
+	"go.uber.org/zap"
+)
+
+// InPlace makes upgrades install the candidate over the running binary
+// and re-execute it, instead of starting it from the upgrade directory.
+var InPlace bool
+
+// installedEnv marks the process image installed and executed by an
+// in-place upgrade, which has yet to pass the probes.
+const installedEnv = "SELF_UPDATE_INSTALLED"
+
+// reexecuted is whether this process was executed by an in-place
+// upgrade. It is set by Recover.
+var reexecuted bool
+
+// Suffixes of the files kept next to the running binary.
+const (
+	newSuffix  = ".new"  // the candidate being copied
+	oldSuffix  = ".old"  // the backup of the replaced binary
+	swapSuffix = ".swap" // the journal of an unconfirmed swap
+)
+
+// ErrRecovered is returned by Recover when an interrupted swap was
+// rolled back and the process has to be restarted.
+var ErrRecovered = errors.New("restored the binary replaced by an interrupted upgrade")
+
+// Executable returns the path of the running binary with symlinks
+// resolved.
+func Executable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(exe)
+}
+
+// copyFile copies `src` to `dst` and flushes it to the disk.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode().Perm()|0111)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// install replaces the binary at `exe` with `binPath`.
+//
+// 1. Copies `binPath` next to `exe`
+// 2. Keeps a backup of `exe` with the `.old` suffix
+// 3. Records the swap in a journal
+// 4. Renames the copy over `exe`
+//
+// The journal is removed by confirmInstall or restoreInstall; if it is
+// left behind, Recover restores the backup.
+func install(exe, binPath string) error {
+	if err := copyFile(binPath, exe+newSuffix); err != nil {
+		os.Remove(exe + newSuffix)
+		return fmt.Errorf("copy %s: %w", binPath, err)
+	}
+
+	if err := backup(exe, exe+oldSuffix); err != nil {
+		os.Remove(exe + newSuffix)
+		return fmt.Errorf("back up %s: %w", exe, err)
+	}
+
+	if err := ioutil.WriteFile(exe+swapSuffix, []byte(binPath+"\n"), 0644); err != nil {
+		os.Remove(exe + newSuffix)
+		return fmt.Errorf("write journal: %w", err)
+	}
+
+	if err := replaceFile(exe+newSuffix, exe, exe+oldSuffix); err != nil {
+		os.Remove(exe + newSuffix)
+		os.Remove(exe + swapSuffix)
+		return fmt.Errorf("rename over %s: %w", exe, err)
+	}
+
+	if err := syncDir(filepath.Dir(exe)); err != nil {
+		zap.L().Error("sync directory", zap.String("dir", filepath.Dir(exe)), zap.Error(err))
+	}
+
+	zap.L().Info("install upgrade", zap.String("bin", binPath), zap.String("exe", exe))
+
+	return nil
+}
+
+// confirmInstall keeps the installed binary.
+func confirmInstall(exe string) {
+	if err := os.Remove(exe + swapSuffix); err != nil {
+		zap.L().Error("remove journal", zap.Error(err))
+	}
+}
+
+// restoreInstall puts the backup back in place of the installed binary.
+func restoreInstall(exe string) error {
+	if err := replaceFile(exe+oldSuffix, exe, ""); err != nil {
+		return err
+	}
+
+	if err := syncDir(filepath.Dir(exe)); err != nil {
+		zap.L().Error("sync directory", zap.String("dir", filepath.Dir(exe)), zap.Error(err))
+	}
+
+	zap.L().Info("restore binary", zap.String("exe", exe))
+
+	return os.Remove(exe + swapSuffix)
+}
+
+// Recover cleans up after an upgrade interrupted before it was confirmed.
+//
+// It returns ErrRecovered if the backup of the binary was restored;
+// the caller is expected to Reexec then, as this process still runs
+// the replaced one. The swap is left unconfirmed if this process was
+// executed by the upgrade, see Reexecuted.
+func Recover() error {
+	reexecuted = os.Getenv(installedEnv) != ""
+	os.Unsetenv(installedEnv)
+
+	exe, err := Executable()
+	if err != nil {
+		return err
+	}
+
+	return recoverInstall(exe, reexecuted)
+}
+
+func recoverInstall(exe string, reexecuted bool) error {
+	if err := os.Remove(exe + newSuffix); err == nil {
+		zap.L().Warn("remove partial copy of an interrupted upgrade", zap.String("path", exe+newSuffix))
+	}
+
+	journal, err := ioutil.ReadFile(exe + swapSuffix)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if reexecuted {
+		zap.L().Info("run installed binary", zap.String("exe", exe), zap.ByteString("candidate", journal))
+		return nil
+	}
+
+	zap.L().Warn("restore binary replaced by an interrupted upgrade", zap.String("exe", exe), zap.ByteString("candidate", journal))
+
+	if err := restoreInstall(exe); err != nil {
+		return fmt.Errorf("restore %s: %w", exe, err)
+	}
+
+	return ErrRecovered
+}
+
+// Reexecuted reports whether this process was executed by an in-place
+// upgrade, which has to be confirmed with Confirm.
+func Reexecuted() bool {
+	return reexecuted
+}
+
+// reexec re-executes the binary at the path of this process with the
+// variables `env` set, passing Listeners on.
+//
+// It only returns on failure.
+func reexec(ctx context.Context, env ...string) error {
+	env = append(env, systemd.Environ(Listeners)...)
+	if e := tracing.Environ(ctx); e != "" {
+		env = append(env, e)
+	}
+
+	for _, kv := range env {
+		kv := strings.SplitN(kv, "=", 2)
+		os.Setenv(kv[0], kv[1])
+	}
+
+	err := systemd.PassOnExec(Listeners, true)
+	if err == nil {
+		tracing.Flush()
+		err = Reexec()
+	}
+
+	for _, kv := range env {
+		os.Unsetenv(strings.SplitN(kv, "=", 2)[0])
+	}
+	if perr := systemd.PassOnExec(Listeners, false); perr != nil {
+		zap.L().Error("close listeners on exec", zap.Error(perr))
+	}
+
+	return err
+}
+
+// reexecInstalled re-executes the binary installed over this one. The
+// new process image confirms the upgrade with Confirm.
+//
+// It only returns on failure.
+func reexecInstalled(ctx context.Context, stoppedAt time.Time) error {
+	return reexec(ctx, installedEnv+"=1", fmt.Sprintf("%s=%d", stoppedAtEnv, stoppedAt.UnixNano()))
+}
+
+// Restart re-executes the binary at the path of this process, passing
+// Listeners on.
+//
+// It only returns on failure.
+func Restart(ctx context.Context) error {
+	return reexec(ctx)
+}
+
+// Confirm runs `hc` against this process, executed by an in-place
+// upgrade and serving on `bind`. If the probes pass, the installed
+// binary is kept. Otherwise, the replaced binary is restored and
+// *RollbackError is returned; the caller is expected to Restart then.
+//
+// The steps are reported to `t`. The caller is expected to call
+// t.Begin before and t.Finish after the confirmation.
+func Confirm(ctx context.Context, t *Tracker, hc HealthCheck, bind string) error {
+	exe, err := Executable()
+	if err != nil {
+		return err
+	}
+
+	return confirm(ctx, t, exe, hc, bind)
+}
+
+func confirm(ctx context.Context, t *Tracker, exe string, hc HealthCheck, bind string) error {
+	t.Transition(StateProbing, "")
+	_, span := tracing.Start(ctx, "upgrade.HealthCheck", tracing.KindInternal, tracing.String("bind", bind))
+	err := hc.Run(bind)
+	span.RecordError(err)
+	span.End()
+
+	if err == nil {
+		confirmInstall(exe)
+		return nil
+	}
+
+	if rerr := restoreInstall(exe); rerr != nil {
+		return fmt.Errorf("restore %s: %w", exe, rerr)
+	}
+
+	return &RollbackError{Err: fmt.Errorf("health check: %w", err)}
+}
@@ -0,0 +1,184 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// testBind returns the bind of a server responding with `status`.
+func testBind(t *testing.T, status int) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+// testHealthCheck expects 200 from `/` within 100ms.
+var testHealthCheck = HealthCheck{
+	Probes:   []Probe{{}},
+	Interval: 10 * time.Millisecond,
+	Timeout:  100 * time.Millisecond,
+}
+
+func Test_install(t *testing.T) {
+	tests := []struct {
+		name        string
+		finish      func(exe string) error
+		wantContent string
+		wantBackup  bool
+	}{
+		{
+			name: "confirmed",
+			finish: func(exe string) error {
+				confirmInstall(exe)
+				return nil
+			},
+			wantContent: "new",
+			wantBackup:  true,
+		},
+		{
+			name:        "restored",
+			finish:      restoreInstall,
+			wantContent: "old",
+		},
+		{
+			name: "interrupted",
+			finish: func(exe string) error {
+				if err := recoverInstall(exe, false); err != ErrRecovered {
+					t.Errorf("recoverInstall() error = %v, want %v", err, ErrRecovered)
+				}
+				return nil
+			},
+			wantContent: "old",
+		},
+		{
+			name: "re-executed and probed",
+			finish: func(exe string) error {
+				if err := recoverInstall(exe, true); err != nil {
+					t.Errorf("recoverInstall() error = %v", err)
+				}
+				return confirm(context.Background(), NewTracker("1.0.0"), exe, testHealthCheck, testBind(t, http.StatusOK))
+			},
+			wantContent: "new",
+			wantBackup:  true,
+		},
+		{
+			name: "re-executed and failed probes",
+			finish: func(exe string) error {
+				if err := recoverInstall(exe, true); err != nil {
+					t.Errorf("recoverInstall() error = %v", err)
+				}
+
+				err := confirm(context.Background(), NewTracker("1.0.0"), exe, testHealthCheck, testBind(t, http.StatusServiceUnavailable))
+				var rbErr *RollbackError
+				if !errors.As(err, &rbErr) {
+					t.Errorf("confirm() error = %v, want *RollbackError", err)
+				}
+				return nil
+			},
+			wantContent: "old",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "install")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			exe := filepath.Join(dir, "service")
+			bin := filepath.Join(dir, "candidate")
+			writeFile(t, exe, "old")
+			writeFile(t, bin, "new")
+
+			if err := install(exe, bin); err != nil {
+				t.Fatalf("install() error = %v", err)
+			}
+
+			if got := readFile(t, exe); got != "new" {
+				t.Fatalf("installed content = %q, want %q", got, "new")
+			}
+			if !exists(exe + swapSuffix) {
+				t.Fatal("install() left no journal")
+			}
+
+			if err := tt.finish(exe); err != nil {
+				t.Fatalf("finish error = %v", err)
+			}
+
+			if got := readFile(t, exe); got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+			if exists(exe + swapSuffix) {
+				t.Error("journal left behind")
+			}
+			if exists(exe + newSuffix) {
+				t.Error("copy left behind")
+			}
+			if got := exists(exe + oldSuffix); got != tt.wantBackup {
+				t.Errorf("backup exists = %v, want %v", got, tt.wantBackup)
+			}
+			if err := recoverInstall(exe, false); err != nil {
+				t.Errorf("recoverInstall() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_recoverInstall_partialCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	exe := filepath.Join(dir, "service")
+	writeFile(t, exe, "old")
+	writeFile(t, exe+newSuffix, "ne")
+
+	if err := recoverInstall(exe, false); err != nil {
+		t.Fatalf("recoverInstall() error = %v", err)
+	}
+
+	if exists(exe + newSuffix) {
+		t.Error("partial copy left behind")
+	}
+	if got := readFile(t, exe); got != "old" {
+		t.Errorf("content = %q, want %q", got, "old")
+	}
+}
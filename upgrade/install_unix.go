@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package upgrade
+
+import (
+	"os"
+	"syscall"
+)
+
+// backup hard links `exe` to `old`, so the backup exists before `exe`
+// is replaced. It falls back to a copy if linking is not possible.
+func backup(exe, old string) error {
+	if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Link(exe, old); err == nil {
+		return nil
+	}
+
+	return copyFile(exe, old)
+}
+
+// replaceFile atomically renames `src` over `dst`. The running binary
+// stays in place until then, so `old` is already backed up.
+func replaceFile(src, dst, old string) error {
+	return os.Rename(src, dst)
+}
+
+// syncDir flushes the entries of `dir`, so renames survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+
+	return d.Close()
+}
+
+// Reexec replaces this process with the binary at its path, keeping
+// the PID, the arguments, the environment and inherited descriptors.
+func Reexec() error {
+	exe, err := Executable()
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
@@ -0,0 +1,56 @@
+package upgrade
+
+import (
+	"os"
+	"os/exec"
+)
+
+// backup does nothing, as a running binary cannot be linked on Windows;
+// replaceFile moves it to the backup path instead.
+func backup(exe, old string) error {
+	return nil
+}
+
+// replaceFile moves `dst` to `old`, if set, and `src` in its place.
+//
+// A running binary cannot be overwritten on Windows, but it can be
+// renamed, so the replacement is not atomic.
+func replaceFile(src, dst, old string) error {
+	if old != "" {
+		if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := os.Rename(dst, old); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(src, dst)
+}
+
+// syncDir does nothing, as directories cannot be flushed on Windows.
+func syncDir(dir string) error {
+	return nil
+}
+
+// Reexec starts the binary at the path of this process with the same
+// arguments and exits, as Windows cannot replace a running process.
+func Reexec() error {
+	exe, err := Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	os.Exit(0)
+	return nil
+}
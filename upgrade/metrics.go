@@ -0,0 +1,33 @@
+package upgrade
+
+import "github.com/xaxes/self-update/metrics"
+
+var (
+	upgradeAttempts = metrics.Default.NewCounter(
+		"self_update_upgrade_attempts_total",
+		"Number of started upgrades.",
+	)
+	upgradeSuccesses = metrics.Default.NewCounter(
+		"self_update_upgrade_successes_total",
+		"Number of successful upgrades. Counted by the upgraded instance.",
+	)
+	upgradeFailures = metrics.Default.NewCounterVec(
+		"self_update_upgrade_failures_total",
+		"Number of upgrades which failed before the server was stopped, by the failed step.",
+		"reason",
+	)
+	upgradeRollbacks = metrics.Default.NewCounterVec(
+		"self_update_upgrade_rollbacks_total",
+		"Number of rolled back upgrades, by the failed step.",
+		"reason",
+	)
+	handoffDowntime = metrics.Default.NewHistogram(
+		"self_update_handoff_downtime_seconds",
+		"Time between stopping the outgoing server and starting the upgraded one.",
+		[]float64{.5, 1, 2.5, 5, 7.5, 10, 15, 30, 60},
+	)
+	lastSuccess = metrics.Default.NewGauge(
+		"self_update_last_successful_upgrade_timestamp_seconds",
+		"Unix time of the last successful upgrade.",
+	)
+)
@@ -0,0 +1,145 @@
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultProbeInterval = time.Second
+	defaultProbeTimeout  = 30 * time.Second
+)
+
+// Probe describes an HTTP check run against the upgraded instance.
+type Probe struct {
+	Path      string `json:"path"`      // Requested path, "/" by default
+	Status    int    `json:"status"`    // Expected status, 200 by default
+	Body      string `json:"body"`      // Regular expression the body has to match
+	Successes int    `json:"successes"` // Required consecutive successes, 1 by default
+}
+
+// HealthCheck is a set of probes the upgraded instance has to pass
+// before the upgrade is considered successful.
+type HealthCheck struct {
+	Probes   []Probe
+	Interval time.Duration // Time between consecutive probe calls
+	Timeout  time.Duration // Time limit for all probes to succeed
+}
+
+// Validate checks if the probes are well-defined.
+func (h HealthCheck) Validate() error {
+	for _, p := range h.Probes {
+		if _, err := regexp.Compile(p.Body); err != nil {
+			return fmt.Errorf(`probe "%s": body: %w`, p.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// check performs a single call of `p` against `base`.
+func (p Probe) check(client *http.Client, base string, body *regexp.Regexp) error {
+	resp, err := client.Get(base + p.Path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			zap.L().Error("close response body", zap.Error(err))
+		}
+	}()
+
+	if resp.StatusCode != p.Status {
+		return fmt.Errorf("status %d, want %d", resp.StatusCode, p.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if !body.Match(b) {
+		return fmt.Errorf("body does not match %s", body)
+	}
+
+	return nil
+}
+
+// withDefaults returns `p` with unset fields replaced by defaults.
+func (p Probe) withDefaults() Probe {
+	if p.Path == "" {
+		p.Path = "/"
+	}
+	if p.Status == 0 {
+		p.Status = http.StatusOK
+	}
+	if p.Successes <= 0 {
+		p.Successes = 1
+	}
+
+	return p
+}
+
+// Run calls every probe against the instance listening on `bind`
+// until it succeeds the required number of times in a row.
+//
+// A failure resets the counter of the probe. An error is returned
+// if any of the probes did not succeed within the timeout.
+func (h HealthCheck) Run(bind string) error {
+	u, err := urlify(bind)
+	if err != nil {
+		return fmt.Errorf(`invalid bind "%s": %w`, bind, err)
+	}
+
+	interval, timeout := h.Interval, h.Timeout
+	if interval <= 0 {
+		interval = defaultProbeInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	client := &http.Client{Timeout: timeout}
+	deadline := time.Now().Add(timeout)
+
+	for _, p := range h.Probes {
+		p = p.withDefaults()
+
+		body, err := regexp.Compile(p.Body)
+		if err != nil {
+			return fmt.Errorf(`probe "%s": body: %w`, p.Path, err)
+		}
+
+		var lastErr error
+		for successes := 0; successes < p.Successes; {
+			if time.Now().After(deadline) {
+				if lastErr == nil {
+					lastErr = errors.New("timeout")
+				}
+
+				return fmt.Errorf(`probe "%s": %w`, p.Path, lastErr)
+			}
+
+			if lastErr = p.check(client, u.String(), body); lastErr != nil {
+				zap.L().Debug("probe", zap.String("path", p.Path), zap.Error(lastErr))
+				successes = 0
+			} else {
+				successes++
+			}
+
+			if successes < p.Successes {
+				time.Sleep(interval)
+			}
+		}
+
+		zap.L().Debug("probe", zap.String("path", p.Path), zap.String("status", "success"))
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package upgrade
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck_Run(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			_, _ = w.Write([]byte("This server is version 1.1.0"))
+		case "/flaky":
+			// fails every third call
+			calls++
+			if calls%3 == 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	bind := server.Listener.Addr().String()
+
+	tests := []struct {
+		name    string
+		probes  []Probe
+		wantErr bool
+	}{
+		{
+			name:   "no probes",
+			probes: nil,
+		},
+		{
+			name:   "defaults",
+			probes: []Probe{{}},
+		},
+		{
+			name:   "body match",
+			probes: []Probe{{Path: "/", Body: `version 1\.1\.0`}},
+		},
+		{
+			name:    "body mismatch",
+			probes:  []Probe{{Path: "/", Body: `version 2\.0\.0`}},
+			wantErr: true,
+		},
+		{
+			name:   "expected status",
+			probes: []Probe{{Path: "/missing", Status: http.StatusNotFound}},
+		},
+		{
+			name:    "unexpected status",
+			probes:  []Probe{{Path: "/missing"}},
+			wantErr: true,
+		},
+		{
+			name:   "flaky with two consecutive successes",
+			probes: []Probe{{Path: "/flaky", Successes: 2}},
+		},
+		{
+			name:    "flaky with three consecutive successes",
+			probes:  []Probe{{Path: "/flaky", Successes: 3}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := HealthCheck{
+				Probes:   tt.probes,
+				Interval: time.Millisecond,
+				Timeout:  100 * time.Millisecond,
+			}
+			if err := h.Run(bind); (err != nil) != tt.wantErr {
+				t.Errorf("HealthCheck.Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/xaxes/self-update/tracing"
+	"go.uber.org/zap"
+)
+
+// selfTestTimeout limits the time the candidate has to report its state.
+const selfTestTimeout = 10 * time.Second
+
+// Check is a single verification performed by the self-test.
+type Check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the structured output of `<binary> -self-test`.
+type Report struct {
+	Version string  `json:"version"`
+	OK      bool    `json:"ok"`
+	Checks  []Check `json:"checks"`
+}
+
+// Add appends the result of check `name` to the report.
+//
+// A non-nil `err` marks both the check and the whole report as failed.
+func (r *Report) Add(name string, err error) {
+	c := Check{Name: name, OK: err == nil}
+	if err != nil {
+		c.Error = err.Error()
+	}
+
+	r.Checks = append(r.Checks, c)
+	r.OK = r.OK && c.OK
+}
+
+// NewReport returns an empty, passing report for `version`.
+func NewReport(version string) Report {
+	return Report{Version: version, OK: true, Checks: []Check{}}
+}
+
+// SelfTestError is returned when the candidate fails its self-test.
+type SelfTestError struct {
+	Report Report
+}
+
+func (e *SelfTestError) Error() string {
+	var failed []string
+	for _, c := range e.Report.Checks {
+		if !c.OK {
+			failed = append(failed, fmt.Sprintf("%s: %s", c.Name, c.Error))
+		}
+	}
+
+	return fmt.Sprintf("self-test failed: %s", strings.Join(failed, "; "))
+}
+
+// SelfTest executes `binPath` in self-test mode with the configuration
+// it would be started with during the upgrade and returns its report.
+//
+// An error is returned if the binary cannot be executed, its output
+// is not a valid report or any of the checks failed (*SelfTestError).
+func SelfTest(ctx context.Context, binPath, tempBind, bind string) (Report, error) {
+	_, span := tracing.Start(ctx, "upgrade.SelfTest", tracing.KindInternal, tracing.String("bin", binPath))
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, binPath, append([]string{"-self-test"}, instanceArgs(tempBind, bind)...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	zap.L().Debug("self-test", zap.String("bin", binPath))
+
+	runErr := cmd.Run()
+
+	var report Report
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		if runErr != nil {
+			err = fmt.Errorf("run self-test: %w: %s", runErr, strings.TrimSpace(stderr.String()))
+		} else {
+			err = fmt.Errorf("parse self-test report: %w", err)
+		}
+
+		span.RecordError(err)
+		return Report{}, err
+	}
+
+	if runErr != nil && report.OK {
+		report.Add("exit", runErr)
+	}
+
+	if !report.OK {
+		err := &SelfTestError{report}
+		span.RecordError(err)
+		return report, err
+	}
+
+	return report, nil
+}
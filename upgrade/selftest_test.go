@@ -0,0 +1,54 @@
+package upgrade
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReport_Add(t *testing.T) {
+	type check struct {
+		name string
+		err  error
+	}
+	tests := []struct {
+		name   string
+		checks []check
+		want   bool
+	}{
+		{
+			name:   "no checks",
+			checks: nil,
+			want:   true,
+		},
+		{
+			name: "all passing",
+			checks: []check{
+				{name: "alice"},
+				{name: "bob"},
+			},
+			want: true,
+		},
+		{
+			name: "one failing",
+			checks: []check{
+				{name: "alice", err: errors.New("whatever")},
+				{name: "bob"},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReport("1.0.0")
+			for _, c := range tt.checks {
+				r.Add(c.name, c.err)
+			}
+			if r.OK != tt.want {
+				t.Errorf("Report.OK = %v, want %v", r.OK, tt.want)
+			}
+			if len(r.Checks) != len(tt.checks) {
+				t.Errorf("len(Report.Checks) = %v, want %v", len(r.Checks), len(tt.checks))
+			}
+		})
+	}
+}
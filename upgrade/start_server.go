@@ -1,20 +1,96 @@
 package upgrade
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"os/exec"
 	"time"
 
+	"github.com/xaxes/self-update/systemd"
+	"github.com/xaxes/self-update/tracing"
 	"go.uber.org/zap"
 )
 
-func startInstance(binPath, tempBind, bind string) error {
+// InstanceArgs are additional arguments passed to the upgraded instance,
+// e.g. the configuration it should share with the running one.
+var InstanceArgs []string
+
+// Listeners are the sockets passed to the upgraded instance with
+// the socket activation protocol.
+var Listeners []systemd.Listener
+
+// instanceArgs returns the arguments the upgraded instance is started with.
+func instanceArgs(tempBind, bind string) []string {
+	return append([]string{"-upgrade=true", "-upgrade-bind", tempBind, "-bind", bind}, InstanceArgs...)
+}
+
+func startInstance(ctx context.Context, binPath, tempBind, bind string, stoppedAt time.Time) (*exec.Cmd, error) {
+	ctx, span := tracing.Start(ctx, "upgrade.startInstance", tracing.KindInternal, tracing.String("bin", binPath))
+	defer span.End()
+
 	// FIXME: Potential security vulnerability; research if binPath can be a malicious value.
-	cmd := exec.Command(binPath, "-upgrade", "true", "-upgrade-bind", tempBind, "-bind", bind)
+	cmd := exec.Command(binPath, instanceArgs(tempBind, bind)...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", stoppedAtEnv, stoppedAt.UnixNano()))
+	if env := tracing.Environ(ctx); env != "" {
+		cmd.Env = append(cmd.Env, env)
+	}
+
+	cmd.Env = append(cmd.Env, systemd.Environ(Listeners)...)
+	for _, l := range Listeners {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, l.File)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer r.Close()
+
+	out, err := childOutput()
+	if err != nil {
+		w.Close()
+		span.RecordError(err)
+		return nil, err
+	}
+
+	if out != nil {
+		defer out.Close()
+		cmd.Stdout, cmd.Stderr = out, out
+	} else {
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	}
+
+	cmd.Stdin = r
 	if err := cmd.Start(); err != nil {
-		return err
+		w.Close()
+		span.RecordError(err)
+		return nil, err
 	}
 
+	handoff = w
+
 	zap.L().Debug("start upgraded server", zap.String("bin", binPath), zap.String("bind", tempBind))
 	time.Sleep(5 * time.Second)
-	return nil
+	return cmd, nil
+}
+
+// killInstance kills the upgraded instance and waits for it to exit,
+// so the binds are released.
+func killInstance(cmd *exec.Cmd) {
+	zap.L().Debug("kill upgraded server", zap.Int("pid", cmd.Process.Pid))
+
+	if err := cmd.Process.Kill(); err != nil {
+		zap.L().Error("kill upgraded server", zap.Error(err))
+		return
+	}
+
+	// The error is expected as the process has been killed.
+	_ = cmd.Wait()
+
+	if err := handoff.Close(); err != nil {
+		zap.L().Error("close handoff pipe", zap.Error(err))
+	}
+	handoff = nil
 }
@@ -5,12 +5,18 @@ import (
 	"errors"
 	"net/http"
 	"time"
+
+	"github.com/xaxes/self-update/tracing"
 )
 
-func stopServer(server *http.Server) error {
+func stopServer(ctx context.Context, server *http.Server) error {
+	_, span := tracing.Start(ctx, "upgrade.stopServer", tracing.KindInternal, tracing.String("bind", server.Addr))
+	defer span.End()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		span.RecordError(err)
 		return err
 	}
 
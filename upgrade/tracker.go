@@ -0,0 +1,203 @@
+package upgrade
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// State is a step of the upgrade procedure.
+type State string
+
+// States of the outgoing instance.
+const (
+	StateIdle       State = "idle"
+	StateSelfTest   State = "self-test"
+	StateInstalling State = "installing"
+	StateStopping   State = "stopping"
+	StateRestarting State = "restarting" // re-executing the installed binary
+	StateStarting   State = "starting"
+	StateReplacing  State = "replacing"
+	StateProbing    State = "probing"
+	StateSucceeded  State = "succeeded"
+	StateFailed     State = "failed"
+	StateRolledBack State = "rolled-back"
+)
+
+// States of the upgraded instance.
+const (
+	StateHandoff State = "handoff" // waiting for `GET /replace`
+	StateServing State = "serving" // serving, the outgoing instance still runs
+)
+
+// historySize limits the number of events kept for late subscribers.
+const historySize = 100
+
+// subscriberBuffer is the number of events a subscriber may lag behind.
+const subscriberBuffer = 16
+
+// ErrInProgress is returned when an upgrade is already running.
+var ErrInProgress = errors.New("upgrade in progress")
+
+// Event is a state transition of the upgrade procedure.
+//
+// IDs are based on the wall clock, so they keep increasing across
+// the outgoing and the upgraded instance.
+type Event struct {
+	ID      int64     `json:"id"`
+	State   State     `json:"state"`
+	Message string    `json:"message,omitempty"`
+	Version string    `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// Terminal reports whether no further transitions follow the state.
+func (s State) Terminal() bool {
+	switch s {
+	case StateIdle, StateSucceeded, StateFailed, StateRolledBack:
+		return true
+	default:
+		return false
+	}
+}
+
+// Tracker records the state of the upgrade procedure and broadcasts
+// its transitions to subscribers.
+type Tracker struct {
+	version string
+
+	mu      sync.Mutex
+	state   State
+	lastID  int64
+	history []Event
+	subs    map[chan Event]struct{}
+}
+
+// NewTracker returns an idle tracker of the service in `version`.
+func NewTracker(version string) *Tracker {
+	return &Tracker{
+		version: version,
+		state:   StateIdle,
+		subs:    make(map[chan Event]struct{}),
+	}
+}
+
+// State returns the current state.
+func (t *Tracker) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.state
+}
+
+// Begin marks the start of an upgrade and drops the events
+// of the previous one.
+//
+// It returns ErrInProgress if another upgrade has not finished yet.
+func (t *Tracker) Begin() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.state.Terminal() {
+		return ErrInProgress
+	}
+
+	t.history = nil
+	t.transition(StateSelfTest, "")
+	upgradeAttempts.Inc()
+	return nil
+}
+
+// Finish marks the end of an upgrade with the state matching `err`.
+//
+// Failures are counted by the step they occurred in.
+func (t *Tracker) Finish(err error) {
+	var rbErr *RollbackError
+
+	reason := string(t.State())
+
+	switch {
+	case err == nil:
+		t.Transition(StateSucceeded, "")
+	case errors.As(err, &rbErr):
+		upgradeRollbacks.With(reason).Inc()
+		t.Transition(StateRolledBack, err.Error())
+	default:
+		upgradeFailures.With(reason).Inc()
+		t.Transition(StateFailed, err.Error())
+	}
+}
+
+// Transition changes the state and notifies the subscribers.
+func (t *Tracker) Transition(s State, msg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.transition(s, msg)
+}
+
+// transition is Transition with the lock held.
+func (t *Tracker) transition(s State, msg string) {
+	id := time.Now().UnixNano()
+	if id <= t.lastID {
+		id = t.lastID + 1
+	}
+	t.lastID = id
+
+	e := Event{
+		ID:      id,
+		State:   s,
+		Message: msg,
+		Version: t.version,
+		Time:    time.Now(),
+	}
+
+	if s == StateSucceeded {
+		upgradeSuccesses.Inc()
+		lastSuccess.SetToCurrentTime()
+	}
+
+	t.state = s
+	t.history = append(t.history, e)
+	if len(t.history) > historySize {
+		t.history = t.history[len(t.history)-historySize:]
+	}
+
+	zap.L().Debug("upgrade state", zap.String("state", string(s)), zap.String("message", msg))
+
+	for ch := range t.subs {
+		select {
+		case ch <- e:
+		default:
+			zap.L().Warn("drop upgrade event", zap.Int64("id", e.ID))
+		}
+	}
+}
+
+// Subscribe returns the recorded events newer than `after` and
+// a channel with the following ones.
+//
+// The returned function cancels the subscription.
+func (t *Tracker) Subscribe(after int64) ([]Event, <-chan Event, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var backlog []Event
+	for _, e := range t.history {
+		if e.ID > after {
+			backlog = append(backlog, e)
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	t.subs[ch] = struct{}{}
+
+	return backlog, ch, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		delete(t.subs, ch)
+	}
+}
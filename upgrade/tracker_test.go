@@ -0,0 +1,107 @@
+package upgrade
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTracker_Begin(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   State
+		wantErr error
+	}{
+		{
+			name:  "idle",
+			state: StateIdle,
+		},
+		{
+			name:  "rolled back",
+			state: StateRolledBack,
+		},
+		{
+			name:    "in progress",
+			state:   StateProbing,
+			wantErr: ErrInProgress,
+		},
+		{
+			name:    "serving after handoff",
+			state:   StateServing,
+			wantErr: ErrInProgress,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTracker("1.0.0")
+			tr.Transition(tt.state, "")
+			if err := tr.Begin(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Tracker.Begin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTracker_Finish(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want State
+	}{
+		{
+			name: "success",
+			err:  nil,
+			want: StateSucceeded,
+		},
+		{
+			name: "rollback",
+			err:  &RollbackError{Err: errors.New("whatever")},
+			want: StateRolledBack,
+		},
+		{
+			name: "failure",
+			err:  errors.New("whatever"),
+			want: StateFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTracker("1.0.0")
+			tr.Finish(tt.err)
+			if got := tr.State(); got != tt.want {
+				t.Errorf("Tracker.State() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTracker_Subscribe(t *testing.T) {
+	tr := NewTracker("1.0.0")
+	tr.Transition(StateHandoff, "")
+	tr.Transition(StateServing, "")
+
+	backlog, _, cancel := tr.Subscribe(0)
+	cancel()
+	if len(backlog) != 2 {
+		t.Fatalf("len(backlog) = %v, want %v", len(backlog), 2)
+	}
+
+	after, events, cancel := tr.Subscribe(backlog[0].ID)
+	defer cancel()
+	if len(after) != 1 || after[0].State != StateServing {
+		t.Errorf("backlog = %v, want only %v", after, StateServing)
+	}
+
+	tr.Transition(StateSucceeded, "")
+	if e := <-events; e.State != StateSucceeded {
+		t.Errorf("event state = %v, want %v", e.State, StateSucceeded)
+	}
+
+	if err := tr.Begin(); err != nil {
+		t.Fatalf("Tracker.Begin() error = %v", err)
+	}
+	backlog, _, cancel = tr.Subscribe(0)
+	defer cancel()
+	if len(backlog) != 1 {
+		t.Errorf("len(backlog) after Begin = %v, want %v", len(backlog), 1)
+	}
+}
@@ -1,73 +1,234 @@
 package upgrade
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/xaxes/self-update/systemd"
+	"github.com/xaxes/self-update/tracing"
 	"go.uber.org/zap"
 )
 
 var errInvalidBind = errors.New("invalid bind")
 
+// RollbackError is returned when the upgrade failed after the server
+// had been stopped. The upgraded instance is killed by then and the
+// caller is expected to resume serving.
+type RollbackError struct {
+	Err error
+	Log []string // The most recent output of the upgraded instance, if known
+}
+
+func (e *RollbackError) Error() string {
+	if len(e.Log) == 0 {
+		return fmt.Sprintf("rolled back: %s", e.Err)
+	}
+
+	return fmt.Sprintf("rolled back: %s\nupgraded instance output:\n%s", e.Err, strings.Join(e.Log, "\n"))
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// notify sends `states` to the service manager.
+func notify(states ...string) {
+	if err := systemd.Notify(states...); err != nil {
+		zap.L().Error("notify service manager", zap.Error(err))
+	}
+}
+
 // urlify returns bind string (e.g. ":8080") formatted as a proper URL.
 func urlify(bind string) (url.URL, error) {
 	split := strings.Split(bind, ":")
 
 	switch {
-	case len(split) == 1 || split[0] == "":
+	case len(split) == 2 && split[0] == "":
 		return url.URL{
 			Scheme: "http",
 			Host:   fmt.Sprintf("localhost:%s", split[1]),
 		}, nil
-	case len(split) == 2 && split[0] != "":
+	case len(split) == 2:
 		return url.URL{
 			Scheme: "http",
-			Host:   fmt.Sprintf("http://%s/", bind),
+			Host:   bind,
 		}, nil
 	default:
 		return url.URL{}, errInvalidBind
 	}
 }
 
+// replace calls `GET /replace` on the upgraded instance's temporary server.
+func replace(ctx context.Context, logger *zap.Logger, tempBind string) error {
+	url, err := urlify(tempBind)
+	if err != nil {
+		return fmt.Errorf(`invalid bind "%s": %w`, tempBind, err)
+	}
+
+	url.Path = "/replace"
+
+	ctx, span := tracing.Start(ctx, "GET /replace", tracing.KindClient, tracing.String("http.url", url.String()))
+	defer span.End()
+
+	req, err := http.NewRequest(http.MethodGet, url.String(), nil)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("call %s: %w", url.Path, err)
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		logger.Error("close response body", zap.Error(err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("call %s: status %d", url.Path, resp.StatusCode)
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
 // Upgrade performs upgrade procedure.
 //
-// 1. Stops http server
-// 2. Executes `binPath`
-// 3. Calls `GET /replace` provided by the executed binary
-// 4. Exits
+// 1. Runs the self-test of `binPath`
+// 2. Stops http server
+// 3. Executes `binPath`
+// 4. Calls `GET /replace` provided by the executed binary
+// 5. Runs `hc` probes against the upgraded instance on `bind`
+// 6. Exits
+//
+// With InPlace, `binPath` is installed over the running binary after
+// the self-test instead, and the server is stopped and the process
+// re-executed, see upgradeInPlace.
+//
+// The result of the self-test is sent to `tested`, if not nil, so that
+// the caller can report it before the server is stopped. A failed
+// self-test is also returned as *SelfTestError; the server is left
+// untouched in that case. Failures after the server has been stopped
+// kill the executed binary and are reported as *RollbackError.
+//
+// The steps are reported to `t`. The caller is expected to call
+// t.Begin before and t.Finish after the upgrade.
 //
 // Successful call to this function will result in os.Exit(0).
-func Upgrade(logger *zap.Logger, s *http.Server, binPath, tempBind, bind string) error {
-	if err := stopServer(s); err != nil {
-		zap.L().Fatal("shutdown server", zap.Error(err))
-	}
+func Upgrade(ctx context.Context, logger *zap.Logger, t *Tracker, s *http.Server, binPath, tempBind, bind string, hc HealthCheck, tested chan<- error) error {
+	ctx, span := tracing.Start(ctx, "upgrade.Upgrade", tracing.KindInternal, tracing.String("bin", binPath))
+	defer span.End()
 
-	if err := startInstance(binPath, tempBind, bind); err != nil {
+	fail := func(err error) error {
+		span.RecordError(err)
 		return err
 	}
 
-	url, err := urlify(tempBind)
+	_, err := SelfTest(ctx, binPath, tempBind, bind)
+	if tested != nil {
+		tested <- err
+	}
 	if err != nil {
-		return fmt.Errorf(`invalid bind "%s": %w`, tempBind, err)
+		return fail(err)
 	}
 
-	url.Path = "/replace"
+	if InPlace {
+		return fail(upgradeInPlace(ctx, t, s, binPath))
+	}
+
+	t.Transition(StateStopping, "")
+	notify(systemd.Reloading, systemd.Status("upgrading to "+binPath))
 
-	resp, err := http.Get(url.String())
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logger.Error("close response body", zap.Error(err))
-		}
-	}()
+	stoppedAt := time.Now()
+	if err := stopServer(ctx, s); err != nil {
+		span.RecordError(err)
+		span.End()
+		tracing.Flush()
+		zap.L().Fatal("shutdown server", zap.Error(err))
+	}
 
+	t.Transition(StateStarting, binPath)
+	output := tailChildLog()
+	defer output.stop()
+
+	rollback := func(err error) error {
+		return fail(&RollbackError{Err: err, Log: output.stop()})
+	}
+
+	cmd, err := startInstance(ctx, binPath, tempBind, bind, stoppedAt)
 	if err != nil {
-		return fmt.Errorf("call %s: %w", url.Path, err)
+		return rollback(err)
+	}
+
+	t.Transition(StateReplacing, "")
+	if err := replace(ctx, logger, tempBind); err != nil {
+		killInstance(cmd)
+		return rollback(err)
 	}
 
 	zap.L().Info("replace successful")
 
+	t.Transition(StateProbing, "")
+	_, probeSpan := tracing.Start(ctx, "upgrade.HealthCheck", tracing.KindInternal, tracing.String("bind", bind))
+	err = hc.Run(bind)
+	probeSpan.RecordError(err)
+	probeSpan.End()
+
+	if err != nil {
+		killInstance(cmd)
+		return rollback(fmt.Errorf("health check: %w", err))
+	}
+
+	// The upgraded instance announces readiness once this one exits.
+	notify(systemd.MainPID(cmd.Process.Pid), systemd.Status(fmt.Sprintf("handing over to PID %d", cmd.Process.Pid)))
+
 	return nil
 }
+
+// upgradeInPlace installs `binPath` over the running binary, stops the
+// server and re-executes the process, keeping its PID and the passed
+// sockets. The new process image runs the probes against itself and
+// restores the replaced binary if they fail, see Confirm.
+//
+// It only returns on failure; failures after the server has been
+// stopped restore the replaced binary and are reported as
+// *RollbackError.
+func upgradeInPlace(ctx context.Context, t *Tracker, s *http.Server, binPath string) error {
+	t.Transition(StateInstalling, binPath)
+
+	exe, err := Executable()
+	if err != nil {
+		return fmt.Errorf("install: %w", err)
+	}
+
+	if err := install(exe, binPath); err != nil {
+		return fmt.Errorf("install: %w", err)
+	}
+
+	t.Transition(StateStopping, "")
+	notify(systemd.Reloading, systemd.Status("upgrading to "+binPath))
+
+	stoppedAt := time.Now()
+	if err := stopServer(ctx, s); err != nil {
+		tracing.Flush()
+		zap.L().Fatal("shutdown server", zap.Error(err))
+	}
+
+	t.Transition(StateRestarting, exe)
+	err = reexecInstalled(ctx, stoppedAt)
+
+	if rerr := restoreInstall(exe); rerr != nil {
+		zap.L().Error("restore binary", zap.String("exe", exe), zap.Error(rerr))
+	}
+
+	return &RollbackError{Err: fmt.Errorf("re-execute %s: %w", exe, err)}
+}
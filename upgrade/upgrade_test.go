@@ -0,0 +1,50 @@
+package upgrade
+
+import (
+	"testing"
+)
+
+func Test_urlify(t *testing.T) {
+	tests := []struct {
+		name    string
+		bind    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "port only",
+			bind: ":8080",
+			want: "http://localhost:8080",
+		},
+		{
+			name: "host and port",
+			bind: "127.0.0.1:8080",
+			want: "http://127.0.0.1:8080",
+		},
+		{
+			name:    "no port",
+			bind:    "localhost",
+			wantErr: true,
+		},
+		{
+			name:    "too many colons",
+			bind:    "a:b:c",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := urlify(tt.bind)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("urlify() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.String() != tt.want {
+				t.Errorf("urlify() = %v, want %v", got.String(), tt.want)
+			}
+		})
+	}
+}
@@ -1,30 +1,44 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"os"
 
 	"github.com/xaxes/self-update/check"
+	"github.com/xaxes/self-update/config"
+	"github.com/xaxes/self-update/systemd"
+	"github.com/xaxes/self-update/tracing"
 	"github.com/xaxes/self-update/upgrade"
 	"go.uber.org/zap"
 )
 
-func newestCandidateErr(err error, w http.ResponseWriter) {
-	zap.L().Error("get newest upgrade candidate", zap.Error(err))
+// upgradeErr responds with the reason the upgrade could not be started.
+func upgradeErr(err error, w http.ResponseWriter) {
+	zap.L().Error("start upgrade", zap.Error(err))
 
-	if errors.Is(err, check.ErrNoCandidate) {
-		w.WriteHeader(http.StatusNotFound)
+	var stErr *upgrade.SelfTestError
+
+	switch {
+	case errors.As(err, &stErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
 
-		if _, err := w.Write([]byte(err.Error())); err != nil {
+		if err := json.NewEncoder(w).Encode(stErr.Report); err != nil {
 			zap.L().Error("write response", zap.Error(err))
 		}
 
 		return
+	case errors.Is(err, check.ErrNoCandidate):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, upgrade.ErrInProgress):
+		w.WriteHeader(http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
 	}
 
-	w.WriteHeader(http.StatusInternalServerError)
-
 	if _, err := w.Write([]byte(err.Error())); err != nil {
 		zap.L().Error("write response", zap.Error(err))
 	}
@@ -36,37 +50,147 @@ var page = `<!DOCTYPE html>
 		<title>Upgrade</title>
 	</head>
 	<body>
-		<h1>Redirecting...</h1>
+		<h1 id="status">Upgrading...</h1>
+		<ul id="events"></ul>
+		<a id="back" href="/" hidden>Back</a>
 		<script type="text/javascript">
-			setTimeout(()=>{
-				window.location.href = "/";
-			}, 10000)
+			const heading = document.getElementById("status");
+			const events = document.getElementById("events");
+			const source = new EventSource("/upgrade/events");
+
+			source.onmessage = (m) => {
+				const e = JSON.parse(m.data);
+
+				const li = document.createElement("li");
+				li.textContent = e.time + " [" + e.version + "] " + e.state + (e.message ? ": " + e.message : "");
+				events.appendChild(li);
+
+				switch (e.state) {
+				case "succeeded":
+					source.close();
+					heading.textContent = "Upgrade succeeded, redirecting...";
+					setTimeout(()=>{
+						window.location.href = "/";
+					}, 1000);
+					break;
+				case "failed":
+				case "rolled-back":
+					source.close();
+					heading.textContent = "Upgrade failed";
+					document.getElementById("back").hidden = false;
+					break;
+				}
+			};
 		</script>
 	</body>
 </html>
 `
 
-func upgradeHandler(s *http.Server, tempBind, bind string) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		zap.L().Info("handle HTTP request", zap.String("method", r.Method), zap.String("uri", r.RequestURI))
+// upgradeStarter checks for the newest candidate and starts the upgrade
+// in background, returning once the candidate passed its self-test.
+//
+// It returns upgrade.ErrInProgress if another upgrade has not finished.
+type upgradeStarter func(ctx context.Context) error
 
-		c, err := check.NewestCandidate(UpgradeDir, Version)
+// errSupervised is returned by workers, which are upgraded by their supervisor.
+var errSupervised = errors.New("upgrades are performed by the supervisor")
+
+// startUpgrade returns the starter of upgrades replacing this process.
+func startUpgrade(inst *instance, tempBind, bind string, conf *config.Reloadable) upgradeStarter {
+	return func(ctx context.Context) error {
+		c, err := candidates.Newest(ctx, Version)
 		if err != nil {
-			newestCandidateErr(err, w)
-			return
+			return err
 		}
 
-		if _, err := w.Write([]byte(page)); err != nil {
-			zap.L().Error("write response", zap.Error(err))
+		if err := inst.tracker.Begin(); err != nil {
+			return err
 		}
 
+		hc := conf.Config().HealthCheck()
+		ctx = tracing.Detach(ctx)
+		tested := make(chan error, 1)
+
 		go func() {
-			if err := upgrade.Upgrade(zap.L(), s, c.Path, tempBind, bind); err != nil {
+			err := upgrade.Upgrade(ctx, zap.L(), inst.tracker, inst.Server(), c.Path, tempBind, bind, hc, tested)
+
+			var rbErr *upgrade.RollbackError
+			if errors.As(err, &rbErr) {
+				// Resume before announcing the result, so clients
+				// reconnecting on the announcement find the server.
+				if err := inst.resume(); err != nil {
+					tracing.Flush()
+					zap.L().Fatal("resume", zap.Error(err))
+				}
+
+				notifyServing("upgrade rolled back")
+			}
+
+			inst.tracker.Finish(err)
+
+			if err != nil {
+				var stErr *upgrade.SelfTestError
+				if errors.As(err, &stErr) {
+					// The server is still running.
+					zap.L().Error("upgrade", zap.Error(err), zap.String("status", "aborted"))
+					return
+				}
+
+				if rbErr != nil {
+					zap.L().Error("upgrade", zap.Error(err), zap.String("status", "rolled back"))
+					return
+				}
+
+				tracing.Flush()
 				zap.L().Fatal("upgrade", zap.Error(err), zap.String("status", "failure"))
 			}
 
 			zap.L().Info("upgrade", zap.String("status", "success"))
+			tracing.Flush()
 			os.Exit(0)
 		}()
+
+		// The self-test runs before the server is stopped, so its
+		// report can still be sent.
+		return <-tested
+	}
+}
+
+// confirmUpgrade probes this process, executed by an in-place upgrade,
+// and restarts the restored binary if the probes fail.
+func confirmUpgrade(inst *instance, hc upgrade.HealthCheck, bind string) {
+	upgrade.ObserveHandoff()
+
+	ctx := tracing.FromEnv(context.Background())
+	err := upgrade.Confirm(ctx, inst.tracker, hc, bind)
+	inst.tracker.Finish(err)
+
+	var rbErr *upgrade.RollbackError
+	switch {
+	case err == nil:
+		zap.L().Info("upgrade", zap.String("status", "success"))
+		return
+	case errors.As(err, &rbErr):
+		zap.L().Error("upgrade", zap.Error(err), zap.String("status", "rolled back"))
+		notify(systemd.Reloading, systemd.Status("upgrade rolled back"))
+		err = upgrade.Restart(ctx)
+	}
+
+	tracing.Flush()
+	zap.L().Fatal("upgrade", zap.Error(err), zap.String("status", "failure"))
+}
+
+func upgradeHandler(start upgradeStarter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zap.L().Info("handle HTTP request", zap.String("method", r.Method), zap.String("uri", r.RequestURI))
+
+		if err := start(r.Context()); err != nil {
+			upgradeErr(err, w)
+			return
+		}
+
+		if _, err := w.Write([]byte(page)); err != nil {
+			zap.L().Error("write response", zap.Error(err))
+		}
 	}
 }